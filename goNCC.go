@@ -1,30 +1,49 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"html/template"
 	"io"
+	"maps"
 	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -33,6 +52,8 @@ import (
 	"github.com/spf13/viper"
 	"github.com/vbauerster/mpb/v7"
 	"github.com/vbauerster/mpb/v7/decor"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/term"
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
@@ -41,28 +62,180 @@ import (
 
 type Config struct {
 	Clusters           []string
+	PrismCentrals      []string                     // discover clusters from these PC hosts
+	ClusterSourceURL   string                       // fetch in-scope clusters from this JSON endpoint
+	ClusterLabels      map[string]map[string]string // cluster -> label -> value, config file only
+	ClusterTimeouts    map[string]time.Duration     // cluster -> Timeout override, config file only
 	Username           string
 	Password           string
+	PasswordFile       string
+	PasswordStdin      bool
+	UseKeyring         bool // read/save the password in the OS keychain
+	KeyringClear       bool // delete the stored keychain entry first
 	InsecureSkipVerify bool
+	RequireSecure      bool          // refuse to run with InsecureSkipVerify (CI guard)
 	Timeout            time.Duration // per-cluster overall timeout
+	MaxTotalRuntime    time.Duration // wall-clock cap for the whole batch (0 = unlimited)
+	Watch              time.Duration // repeat the batch at this interval until interrupted (0 = once)
+	FailFast           bool          // cancel remaining clusters after the first failure
+	PollTimeout        time.Duration // bound on the polling phase only (0 = none)
 	RequestTimeout     time.Duration // per HTTP request timeout
 	PollInterval       time.Duration
 	PollJitter         time.Duration
+	PollBackoff        string        // none, exponential or stepped
+	PollMaxInterval    time.Duration // cap for PollBackoff
+	OutputDir          string        // run directory holding raw/ and reports/, if set
 	OutputDirLogs      string
+	CompressLogs       bool // write raw summaries as <cluster>.log.gz
 	OutputDirFiltered  string
 	OutputFormats      []string // html,csv
+	CombinedOutput     bool     // also write combined.csv/combined.json across clusters
+	AggregatedFilename string   // aggregated HTML name; the JSON one is derived, see aggregatedJSONName
+	SummaryOnly        bool     // render severity counts only, no detail rows
+	SortOrder          string   // severity, cluster or original
+	WriteManifest      bool     // write manifest.json with SHA-256 of every output
+	Archive            string   // package the run's reports into this .zip/.tar.gz (empty = off)
+	HistoryFile        string   // append a JSON Lines record per run (empty = off)
+	HistoryMaxSize     int64    // rotate the history file past this many bytes
 	MaxParallel        int
+	RampUp             time.Duration // spread the first MaxParallel cluster starts over this window (0 = all at once)
 	TLSMinVersion      uint16
 	LogFile            string
+	RunID              string   // generated per run; prefix of every X-Request-ID
+	ConfigFiles        []string // config files loaded, in merge order
 
 	// Logging options
-	LogLevel string // 0..5 or names
-	LogHTTP  bool   // dump HTTP request/response
+	LogLevel    string // 0..5 or names
+	LogRotation logRotation
+	Quiet       bool // suppress non-error console output
+	Verbose     bool // mirror log entries to stderr
+	LogHTTP     bool // dump HTTP request/response
+	LogStdout   bool // also write log entries to stdout (containers)
+	NoColor     bool // never emit ANSI colors on the console
+	ForceColor  bool // emit ANSI colors even when not on a terminal
+
+	// HTTP transport tuning
+	MaxIdleConnsPerHost int
+	DisableHTTP2        bool
+	UserAgent           string // sent on every Prism request
+	MaxResponseBytes    int64  // cap on buffered API response bodies (0 = unlimited)
+	MaxSummaryBytes     int64  // cap on run summary downloads (0 = unlimited)
+
+	// Skip the pre-flight NCC availability probe
+	SkipNCCCheck bool
+
+	// Treat a summary that parses to zero blocks as a cluster failure
+	FailOnEmpty bool
+
+	// Download the full NCC output for clusters with FAIL results
+	FetchFullLogs bool
+
+	// Write runSummary exactly as received, without unescaping
+	NoSanitize bool
+
+	// Let Prism send its own NCC email as well
+	NCCSendEmail bool
+
+	// Task endpoint and schema: auto (v2.0 endpoint, either schema), v2 or v3
+	TaskAPIVersion string
 
 	// Retry tuning
 	RetryMaxAttempts int
 	RetryBaseDelay   time.Duration
 	RetryMaxDelay    time.Duration
+	NoRetryOn        []string // transport error classes failed without retrying
+
+	// Re-fetch a summary that comes back empty (Prism still finalizing)
+	SummaryRetries    int
+	SummaryRetryDelay time.Duration
+
+	// Parser overrides (empty = built-in defaults)
+	BlockStartRegex string
+	BlockEndRegex   string
+
+	// Report timestamps: Go layout or name (RFC3339, RFC1123, ...) and zone
+	ReportTimeFormat string
+	ReportTimezone   string
+
+	// Write the single per-cluster report to stdout instead of a file
+	OutputStdout bool
+
+	// Print one RunResult JSON document to stdout after each run
+	JSONOutput bool
+
+	// Remove <cluster>.log.* outputs of clusters no longer configured
+	CleanStale bool
+
+	// --keep-last-runs: timestamped run directories kept after a successful run, 0 keeps all
+	KeepLastRuns int
+
+	// Shell command run after the reports are written; a failure fails the
+	// run only with OnCompleteStrict
+	OnComplete       string
+	OnCompleteStrict bool
+
+	// Error output on failure: text or json
+	ErrorFormat string
+
+	// Only report blocks detected at/after Since (zero = no filter)
+	Since            time.Time
+	SinceKeepUndated bool
+
+	// Drop blocks whose check name matches (compiled after logger setup;
+	// CheckExclude stays nil when unset or invalid)
+	FilterCheckExclude string
+	CheckExclude       *regexp.Regexp
+
+	// HTML reports keep at most this many rows per severity (0 = unlimited)
+	MaxRowsPerSeverity int
+
+	// --redact-pattern rules applied to check names and details before rendering
+	Redactions []redactRule
+
+	// severity-overrides rules, first match wins, config file only
+	SeverityOverrides []severityOverride
+
+	// Per-cluster HTML in collapsible sections per NCC plugin category
+	GroupByCategory bool
+
+	// --columns: report columns in order; nil keeps each report's default
+	Columns []string
+
+	// --template-var key=value pairs shown in report headers and JSON metadata
+	TemplateVars map[string]string
+
+	// --severity-color SEV=#hex overrides for the HTML report palette
+	SeverityColors []string
+
+	// Progress display: auto, bars, json or none
+	Progress string
+
+	// --cluster-source-url request options and fallback cache
+	ClusterSourceHeaders http.Header
+	ClusterSourceTimeout time.Duration
+	ClusterSourceCache   string
+
+	// Reuse a cluster's last successful run when it is younger than
+	// MinRecheckInterval; entries older than RecheckCacheTTL are dropped
+	MinRecheckInterval time.Duration
+	RecheckCache       string
+	RecheckCacheTTL    time.Duration
+
+	// Bulk-index results into Elasticsearch/OpenSearch after each run
+	ElasticEnabled  bool
+	ElasticURL      string
+	ElasticIndex    string
+	ElasticUsername string
+	ElasticPassword string
+	ElasticAPIKey   string
+
+	// Guardrail against accidentally huge cluster lists (0 = no cap)
+	MaxClusters int
+	AssumeYes   bool
+
+	// Pick a subset of the clusters interactively before running
+	Select             bool
+	ConfiguredClusters []string // cluster list before --select, kept by --clean-stale
 }
 
 const termsText = `
@@ -139,6 +312,113 @@ func splitCSV(s string) []string {
 	return out
 }
 
+// prismPort is the Prism Element / Central HTTPS port.
+const prismPort = 9440
+
+var reHostname = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*\.?$`)
+
+// normalizeCluster turns a pasted cluster entry ("https://10.0.0.1/",
+// "10.0.0.1:9440", " pe1.example.com ") into a host and port. The port
+// defaults to 9440. Anything that is not a hostname or IP is rejected.
+func normalizeCluster(raw string) (host string, port int, err error) {
+	bad := func(why string) (string, int, error) {
+		return "", 0, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid cluster %q: %s", raw, why), nil)
+	}
+	s := strings.TrimSpace(raw)
+	if i := strings.Index(s, "://"); i >= 0 {
+		switch strings.ToLower(s[:i]) {
+		case "https", "http":
+			s = s[i+3:]
+			if strings.Contains(s, "://") {
+				return bad("repeated scheme")
+			}
+		default:
+			return bad("unsupported scheme")
+		}
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i] // drop a pasted path such as /console/
+	}
+	if strings.Contains(s, "@") {
+		return bad("credentials belong in --username/--password")
+	}
+	if s == "" {
+		return bad("empty host")
+	}
+	host, port = s, prismPort
+	if h, p, serr := net.SplitHostPort(s); serr == nil {
+		n, perr := strconv.Atoi(p)
+		if perr != nil || n < 1 || n > 65535 {
+			return bad("port must be 1-65535")
+		}
+		host, port = h, n
+	} else if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		host = s[1 : len(s)-1]
+	} else if strings.Count(s, ":") == 1 {
+		return bad("port must be 1-65535")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), port, nil
+	}
+	if strings.Contains(host, ":") || !reHostname.MatchString(host) {
+		return bad("not a hostname or IP address")
+	}
+	return strings.TrimSuffix(host, "."), port, nil
+}
+
+// clusterKey is the normalized form used in cfg.Clusters, file names and
+// reports: the bare host, or host:port for a non-default port.
+func clusterKey(host string, port int) string {
+	if port == prismPort {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// normalizeClusters normalizes every entry, keeping their order.
+func normalizeClusters(raw []string) ([]string, error) {
+	out := make([]string, 0, len(raw))
+	for _, r := range raw {
+		host, port, err := normalizeCluster(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, clusterKey(host, port))
+	}
+	return out, nil
+}
+
+// hostPort returns the host:port to dial for a cluster key, adding 9440
+// when the key has no port.
+func hostPort(cluster string) string {
+	if _, _, err := net.SplitHostPort(cluster); err == nil {
+		return cluster
+	}
+	return net.JoinHostPort(cluster, strconv.Itoa(prismPort))
+}
+
+// knownOutputFormats are the per-cluster report formats understood by the
+// writers in runClusterWithBars and replay.
+var knownOutputFormats = []string{"html", "csv", "jsonl"}
+
+// validateOutputFormats lowercases formats in place and rejects unknown ones
+// up front, rather than after a full NCC run.
+func validateOutputFormats(formats []string) error {
+	var bad []string
+	for i, f := range formats {
+		f = strings.ToLower(strings.TrimSpace(f))
+		formats[i] = f
+		if !slices.Contains(knownOutputFormats, f) {
+			bad = append(bad, f)
+		}
+	}
+	if len(bad) > 0 {
+		return NewNCCError(ErrorTypeValidation, fmt.Sprintf("unknown output format(s) %s (want %s)",
+			strings.Join(bad, ","), strings.Join(knownOutputFormats, ",")), nil)
+	}
+	return nil
+}
+
 func mustParseDur(s string, def time.Duration) time.Duration {
 	if s == "" {
 		return def
@@ -279,22 +559,236 @@ func parseLogLevel(s string) zerolog.Level {
 	}
 }
 
+// defaultConfigDir is the per-user config directory searched by
+// discoverConfig: $XDG_CONFIG_HOME/ncc-orchestrator, else ~/.config/ncc-orchestrator.
+func defaultConfigDir() string {
+	if x := os.Getenv("XDG_CONFIG_HOME"); x != "" {
+		return filepath.Join(x, "ncc-orchestrator")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "ncc-orchestrator")
+	}
+	return ""
+}
+
+// discoverConfig picks the config files to load. Every --config is used, in
+// order; without one the first match wins among $NCC_CONFIG, ./config.yaml
+// and <config-dir>/config.yaml. explicit is true only for --config, which
+// keeps the create-dummy behavior.
+func discoverConfig() (paths []string, explicit bool) {
+	if ps := viper.GetStringSlice("config"); len(ps) > 0 {
+		return ps, true
+	}
+	dir := viper.GetString("config-dir")
+	if dir == "" {
+		dir = defaultConfigDir()
+	}
+	candidates := []string{os.Getenv("NCC_CONFIG"), "config.yaml"}
+	if dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "config.yaml"))
+	}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if st, err := os.Stat(c); err == nil && !st.IsDir() {
+			return []string{c}, false
+		}
+	}
+	return nil, false
+}
+
+// runDirTimeLayout is RFC3339 in UTC with ':' swapped out so the name is
+// valid on every filesystem, e.g. 2024-05-01T12-30-00Z.
+const runDirTimeLayout = "2006-01-02T15-04-05Z"
+
+// resolveOutputDir applies --output-dir: raw logs go to <dir>/raw and
+// reports to <dir>/reports unless the per-directory flags were set
+// explicitly. --timestamped-output-dir adds a per-run timestamp suffix.
+func resolveOutputDir(cfg *Config, now time.Time) error {
+	dir := viper.GetString("output-dir")
+	if viper.GetBool("timestamped-output-dir") {
+		if dir == "" {
+			return NewNCCError(ErrorTypeConfig, "--timestamped-output-dir requires --output-dir", nil)
+		}
+		dir = filepath.Clean(dir) + "-" + now.UTC().Format(runDirTimeLayout)
+	}
+	if dir == "" {
+		return nil
+	}
+	cfg.OutputDir = dir
+	if !viper.IsSet("output-dir-logs") {
+		cfg.OutputDirLogs = filepath.Join(dir, "raw")
+	}
+	if !viper.IsSet("output-dir-filtered") {
+		cfg.OutputDirFiltered = filepath.Join(dir, "reports")
+	}
+	return nil
+}
+
+var reLabelInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// sanitizeLabelName makes a label key a valid identifier ([A-Za-z_][A-Za-z0-9_]*)
+// so it can double as a metrics label.
+func sanitizeLabelName(k string) string {
+	k = reLabelInvalid.ReplaceAllString(strings.TrimSpace(k), "_")
+	if k == "" || (k[0] >= '0' && k[0] <= '9') {
+		k = "_" + k
+	}
+	return k
+}
+
+// loadClusterLabels reads the cluster-labels config key. It is a list rather
+// than a map because viper splits map keys on '.', which breaks IPs:
+//
+//	cluster-labels:
+//	  - cluster: 10.0.0.1
+//	    labels: {dc: lhr, env: prod}
+func loadClusterLabels() (map[string]map[string]string, error) {
+	var entries []struct {
+		Cluster string            `mapstructure:"cluster"`
+		Labels  map[string]string `mapstructure:"labels"`
+	}
+	if err := viper.UnmarshalKey("cluster-labels", &entries); err != nil {
+		return nil, NewNCCError(ErrorTypeConfig, "invalid cluster-labels", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]map[string]string, len(entries))
+	for _, e := range entries {
+		if strings.TrimSpace(e.Cluster) == "" {
+			return nil, NewNCCError(ErrorTypeConfig, "cluster-labels entry without cluster", nil)
+		}
+		host, port, err := normalizeCluster(e.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		c := clusterKey(host, port)
+		if out[c] == nil {
+			out[c] = map[string]string{}
+		}
+		for k, v := range e.Labels {
+			out[c][sanitizeLabelName(k)] = v
+		}
+	}
+	return out, nil
+}
+
+// loadClusterTimeouts reads the cluster-timeouts config key, a list for the
+// same reason as cluster-labels:
+//
+//	cluster-timeouts:
+//	  - cluster: 10.0.0.1
+//	    timeout: 40m
+func loadClusterTimeouts() (map[string]time.Duration, error) {
+	var entries []struct {
+		Cluster string `mapstructure:"cluster"`
+		Timeout string `mapstructure:"timeout"`
+	}
+	if err := viper.UnmarshalKey("cluster-timeouts", &entries); err != nil {
+		return nil, NewNCCError(ErrorTypeConfig, "invalid cluster-timeouts", err)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]time.Duration, len(entries))
+	for _, e := range entries {
+		if strings.TrimSpace(e.Cluster) == "" {
+			return nil, NewNCCError(ErrorTypeConfig, "cluster-timeouts entry without cluster", nil)
+		}
+		host, port, err := normalizeCluster(e.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		c := clusterKey(host, port)
+		d, err := time.ParseDuration(strings.TrimSpace(e.Timeout))
+		if err != nil || d <= 0 {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("cluster-timeouts: invalid timeout %q for %s", e.Timeout, c), err)
+		}
+		out[c] = d
+	}
+	return out, nil
+}
+
+// loadSeverityOverrides reads severity-overrides from the config file. Like
+// cluster-labels it is a list, since viper would split regex dots in map keys:
+//
+//	severity-overrides:
+//	  - check: ntp_check
+//	    severity: FAIL
+func loadSeverityOverrides() ([]severityOverride, error) {
+	var entries []struct {
+		Check    string `mapstructure:"check"`
+		Severity string `mapstructure:"severity"`
+	}
+	if err := viper.UnmarshalKey("severity-overrides", &entries); err != nil {
+		return nil, NewNCCError(ErrorTypeConfig, "invalid severity-overrides", err)
+	}
+	var out []severityOverride
+	for _, e := range entries {
+		if strings.TrimSpace(e.Check) == "" {
+			return nil, NewNCCError(ErrorTypeConfig, "severity-overrides entry without check", nil)
+		}
+		re, err := regexp.Compile(e.Check)
+		if err != nil {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("severity-overrides: invalid check regex %q", e.Check), err)
+		}
+		sev := strings.ToUpper(strings.TrimSpace(e.Severity))
+		if !slices.Contains(severityOrder, sev) {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("severity-overrides: invalid severity %q for %q (want %s)", e.Severity, e.Check, strings.Join(severityOrder, ", ")), nil)
+		}
+		out = append(out, severityOverride{re: re, severity: sev})
+	}
+	return out, nil
+}
+
+// clusterTimeout is the per-cluster overall timeout: the cluster-timeouts
+// entry if there is one, otherwise --timeout.
+func clusterTimeout(cfg Config, cluster string) time.Duration {
+	if d, ok := cfg.ClusterTimeouts[cluster]; ok {
+		return d
+	}
+	return cfg.Timeout
+}
+
+// labelKeys returns the sorted union of label names used by rows.
+func labelKeys(rows []AggBlock) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, r := range rows {
+		for k := range r.Labels {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func bindConfig() (Config, error) {
-	cfgFile := viper.GetString("config")
-	if cfgFile != "" {
-		viper.SetConfigFile(cfgFile)
-		if _, err := os.Stat(cfgFile); errors.Is(err, os.ErrNotExist) {
-			if err := writeDummyConfig(cfgFile); err != nil {
-				return Config{}, fmt.Errorf("failed to create dummy config at %s: %w", cfgFile, err)
-			}
-			fmt.Printf("Created dummy config at %s. Please edit it according to your Nutanix environment and re-run.\n", cfgFile)
+	cfgFiles, explicit := discoverConfig()
+	if len(cfgFiles) == 1 {
+		if _, err := os.Stat(cfgFiles[0]); explicit && errors.Is(err, os.ErrNotExist) {
+			if err := writeDummyConfig(cfgFiles[0]); err != nil {
+				return Config{}, fmt.Errorf("failed to create dummy config at %s: %w", cfgFiles[0], err)
+			}
+			Console{W: os.Stdout, Quiet: viper.GetBool("quiet")}.Printf("Created dummy config at %s. Please edit it according to your Nutanix environment and re-run.\n", cfgFiles[0])
 			return Config{}, errors.New("dummy config created; edit and re-run")
 		}
-		if err := viper.ReadInConfig(); err != nil {
-			var nf viper.ConfigFileNotFoundError
-			if !errors.As(err, &nf) {
-				return Config{}, fmt.Errorf("read config: %w", err)
-			}
+	}
+	// Later files win: viper deep-merges maps, while scalars and lists
+	// (clusters, cluster-labels) are replaced whole by the later file.
+	for i, f := range cfgFiles {
+		viper.SetConfigFile(f)
+		read := viper.MergeInConfig
+		if i == 0 {
+			read = viper.ReadInConfig
+		}
+		if err := read(); err != nil {
+			return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("read config %s", f), err)
 		}
 	}
 
@@ -303,26 +797,232 @@ func bindConfig() (Config, error) {
 	viper.AutomaticEnv()
 
 	cfg := Config{
+		ConfigFiles:        cfgFiles,
 		Clusters:           splitCSV(viper.GetString("clusters")),
+		PrismCentrals:      splitCSV(viper.GetString("prism-central")),
+		ClusterSourceURL:   strings.TrimSpace(viper.GetString("cluster-source-url")),
 		Username:           viper.GetString("username"),
 		Password:           viper.GetString("password"),
+		PasswordFile:       viper.GetString("password-file"),
+		PasswordStdin:      viper.GetBool("password-stdin"),
+		UseKeyring:         viper.GetBool("use-keyring"),
+		KeyringClear:       viper.GetBool("keyring-clear"),
 		InsecureSkipVerify: viper.GetBool("insecure-skip-verify"),
+		RequireSecure:      viper.GetBool("require-secure"),
 		Timeout:            mustParseDur(viper.GetString("timeout"), 15*time.Minute),
 		RequestTimeout:     mustParseDur(viper.GetString("request-timeout"), 20*time.Second),
 		PollInterval:       mustParseDur(viper.GetString("poll-interval"), 15*time.Second),
+		PollTimeout:        mustParseDur(viper.GetString("poll-timeout"), 0),
+		MaxTotalRuntime:    mustParseDur(viper.GetString("max-total-runtime"), 0),
+		Watch:              mustParseDur(viper.GetString("watch"), 0),
+		FailFast:           viper.GetBool("fail-fast") || !viper.GetBool("continue-on-error"),
 		PollJitter:         mustParseDur(viper.GetString("poll-jitter"), 2*time.Second),
+		PollBackoff:        strings.ToLower(strings.TrimSpace(viper.GetString("poll-backoff"))),
+		PollMaxInterval:    mustParseDur(viper.GetString("poll-max-interval"), 2*time.Minute),
 		OutputDirLogs:      viper.GetString("output-dir-logs"),
+		CompressLogs:       viper.GetBool("compress-logs"),
 		OutputDirFiltered:  viper.GetString("output-dir-filtered"),
 		OutputFormats:      splitCSV(viper.GetString("outputs")),
+		CombinedOutput:     viper.GetBool("combined-output"),
+		AggregatedFilename: strings.TrimSpace(viper.GetString("aggregated-filename")),
+		SummaryOnly:        viper.GetBool("summary-only"),
+		SortOrder:          strings.ToLower(viper.GetString("sort-order")),
+		WriteManifest:      viper.GetBool("write-manifest"),
+		Archive:            strings.TrimSpace(viper.GetString("archive")),
 		MaxParallel:        viper.GetInt("max-parallel"),
+		RampUp:             mustParseDur(viper.GetString("ramp-up"), 0),
 		TLSMinVersion:      tls.VersionTLS12,
 		LogFile:            viper.GetString("log-file"),
 		LogLevel:           viper.GetString("log-level"),
 		LogHTTP:            viper.GetBool("log-http"),
+		LogStdout:          viper.GetBool("log-stdout"),
+		NoColor:            viper.GetBool("no-color"),
+		ForceColor:         viper.GetBool("force-color"),
 		RetryMaxAttempts:   viper.GetInt("retry-max-attempts"),
 		RetryBaseDelay:     mustParseDur(viper.GetString("retry-base-delay"), 400*time.Millisecond),
 		RetryMaxDelay:      mustParseDur(viper.GetString("retry-max-delay"), 8*time.Second),
+		SummaryRetries:     viper.GetInt("summary-retries"),
+		SummaryRetryDelay:  mustParseDur(viper.GetString("summary-retry-delay"), 5*time.Second),
+		BlockStartRegex:    viper.GetString("block-start-regex"),
+		BlockEndRegex:      viper.GetString("block-end-regex"),
+		ReportTimeFormat:   strings.TrimSpace(viper.GetString("report-time-format")),
+		ReportTimezone:     strings.TrimSpace(viper.GetString("report-timezone")),
+		OutputStdout:       viper.GetBool("output-stdout"),
+		JSONOutput:         viper.GetBool("json-output"),
+		CleanStale:         viper.GetBool("clean-stale"),
+		KeepLastRuns:       viper.GetInt("keep-last-runs"),
+		OnComplete:         strings.TrimSpace(viper.GetString("on-complete")),
+		OnCompleteStrict:   viper.GetBool("on-complete-strict"),
+		ErrorFormat:        strings.ToLower(viper.GetString("error-format")),
+		MaxClusters:        viper.GetInt("max-clusters"),
+		Progress:           strings.ToLower(viper.GetString("progress")),
+		Quiet:              viper.GetBool("quiet"),
+		Verbose:            viper.GetBool("verbose"),
+		MaxRowsPerSeverity: viper.GetInt("max-rows-per-severity"),
+		GroupByCategory:    viper.GetBool("group-by-category"),
+		AssumeYes:          viper.GetBool("yes"),
+		Select:             viper.GetBool("select"),
+	}
+	if s := viper.GetString("since"); s != "" {
+		since, err := parseSince(s, time.Now())
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Since = since
+	}
+	cfg.SinceKeepUndated = viper.GetBool("since-keep-undated")
+	cfg.FilterCheckExclude = viper.GetString("filter-check-exclude")
+	redactions, err := parseRedactions(viper.GetStringSlice("redact-pattern"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Redactions = redactions
+	vars, err := parseTemplateVars(viper.GetStringSlice("template-var"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.TemplateVars = vars
+	if cfg.AggregatedFilename == "" {
+		cfg.AggregatedFilename = "index.html"
+	}
+	if err := validateAggregatedFilename(cfg.AggregatedFilename); err != nil {
+		return Config{}, err
+	}
+	cfg.SeverityColors = viper.GetStringSlice("severity-color")
+	columns, err := parseColumns(viper.GetString("columns"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Columns = columns
+	cfg.MaxIdleConnsPerHost = viper.GetInt("max-idle-conns-per-host")
+	cfg.DisableHTTP2 = viper.GetBool("disable-http2")
+	cfg.MaxResponseBytes = viper.GetInt64("max-response-bytes")
+	cfg.MaxSummaryBytes = viper.GetInt64("max-summary-bytes")
+	if cfg.MaxResponseBytes < 0 || cfg.MaxSummaryBytes < 0 {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--max-response-bytes and --max-summary-bytes must be >= 0", nil)
+	}
+	cfg.UserAgent = viper.GetString("user-agent")
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "ncc-orchestrator/" + Version
+	}
+	cfg.LogRotation = logRotation{
+		MaxSize:    viper.GetInt("log-max-size"),
+		MaxBackups: viper.GetInt("log-max-backups"),
+		MaxAge:     viper.GetInt("log-max-age"),
+		Compress:   viper.GetBool("log-compress"),
+	}
+	cfg.NoRetryOn = splitCSV(strings.ToLower(viper.GetString("no-retry-on")))
+	for _, c := range cfg.NoRetryOn {
+		if !slices.Contains(transportErrorClasses, c) {
+			return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --no-retry-on class %q (want %s)", c, strings.Join(transportErrorClasses, ", ")), nil)
+		}
+	}
+	cfg.SkipNCCCheck = viper.GetBool("skip-ncc-check")
+	cfg.FailOnEmpty = viper.GetBool("fail-on-empty")
+	cfg.FetchFullLogs = viper.GetBool("fetch-full-logs")
+	cfg.NoSanitize = viper.GetBool("no-sanitize")
+	cfg.NCCSendEmail = viper.GetBool("ncc-send-email")
+	switch cfg.PollBackoff {
+	case "":
+		cfg.PollBackoff = "none"
+	case "none", "exponential", "stepped":
+	default:
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --poll-backoff %q (want none, exponential or stepped)", cfg.PollBackoff), nil)
+	}
+	if cfg.PollBackoff != "none" && cfg.PollMaxInterval < cfg.PollInterval {
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("--poll-max-interval %s is below --poll-interval %s", cfg.PollMaxInterval, cfg.PollInterval), nil)
+	}
+	cfg.TaskAPIVersion = strings.ToLower(strings.TrimSpace(viper.GetString("task-api-version")))
+	switch cfg.TaskAPIVersion {
+	case "":
+		cfg.TaskAPIVersion = "auto"
+	case "auto", "v2", "v3":
+	default:
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --task-api-version %q (want auto, v2 or v3)", cfg.TaskAPIVersion), nil)
+	}
+	cfg.HistoryFile = viper.GetString("history-file")
+	if cfg.ClusterSourceURL != "" {
+		if u, err := url.Parse(cfg.ClusterSourceURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --cluster-source-url %q (want http(s)://host/path)", cfg.ClusterSourceURL), nil)
+		}
+		cfg.ClusterSourceHeaders = http.Header{}
+		for _, h := range viper.GetStringSlice("cluster-source-header") {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --cluster-source-header %q (want Name: value)", h), nil)
+			}
+			cfg.ClusterSourceHeaders.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		cfg.ClusterSourceTimeout = mustParseDur(viper.GetString("cluster-source-timeout"), 30*time.Second)
+		cfg.ClusterSourceCache = viper.GetString("cluster-source-cache")
+		if cfg.ClusterSourceCache == "" {
+			dir := viper.GetString("config-dir")
+			if dir == "" {
+				dir = defaultConfigDir()
+			}
+			if dir != "" {
+				cfg.ClusterSourceCache = filepath.Join(dir, "cluster-source.json")
+			}
+		}
+	}
+	cfg.MinRecheckInterval = mustParseDur(viper.GetString("min-recheck-interval"), 0)
+	if cfg.MinRecheckInterval > 0 {
+		cfg.RecheckCacheTTL = mustParseDur(viper.GetString("recheck-cache-ttl"), 24*time.Hour)
+		if cfg.RecheckCacheTTL < cfg.MinRecheckInterval {
+			return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("--recheck-cache-ttl %s is below --min-recheck-interval %s", cfg.RecheckCacheTTL, cfg.MinRecheckInterval), nil)
+		}
+		cfg.RecheckCache = viper.GetString("recheck-cache")
+		if cfg.RecheckCache == "" {
+			dir := viper.GetString("config-dir")
+			if dir == "" {
+				dir = defaultConfigDir()
+			}
+			if dir == "" {
+				return Config{}, NewNCCError(ErrorTypeConfig, "--min-recheck-interval needs --recheck-cache (no config directory)", nil)
+			}
+			cfg.RecheckCache = filepath.Join(dir, "recheck-cache.json")
+		}
+	}
+	cfg.ElasticEnabled = viper.GetBool("elastic-enabled")
+	cfg.ElasticURL = strings.TrimSpace(viper.GetString("elastic-url"))
+	cfg.ElasticIndex = viper.GetString("elastic-index")
+	cfg.ElasticUsername = viper.GetString("elastic-username")
+	cfg.ElasticPassword = viper.GetString("elastic-password")
+	cfg.ElasticAPIKey = viper.GetString("elastic-api-key")
+	cfg.HistoryMaxSize = int64(viper.GetInt("history-max-size")) << 20
+	if err := resolveOutputDir(&cfg, time.Now()); err != nil {
+		return Config{}, err
+	}
+	if cfg.KeepLastRuns < 0 {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--keep-last-runs must be >= 0", nil)
+	}
+	if cfg.KeepLastRuns > 0 && !viper.GetBool("timestamped-output-dir") {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--keep-last-runs requires --timestamped-output-dir", nil)
+	}
+	labels, err := loadClusterLabels()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ClusterLabels = labels
+	timeouts, err := loadClusterTimeouts()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ClusterTimeouts = timeouts
+	if cfg.SeverityOverrides, err = loadSeverityOverrides(); err != nil {
+		return Config{}, err
 	}
+	if cfg.Clusters, err = normalizeClusters(cfg.Clusters); err != nil {
+		return Config{}, err
+	}
+	for i, pc := range cfg.PrismCentrals {
+		host, port, err := normalizeCluster(pc)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.PrismCentrals[i] = clusterKey(host, port)
+	}
+	cfg.PrismCentrals = slices.Compact(cfg.PrismCentrals)
 	if cfg.OutputDirLogs == "" {
 		cfg.OutputDirLogs = "nccfiles"
 	}
@@ -332,12 +1032,18 @@ func bindConfig() (Config, error) {
 	if len(cfg.OutputFormats) == 0 {
 		cfg.OutputFormats = []string{"html"}
 	}
+	if err := validateOutputFormats(cfg.OutputFormats); err != nil {
+		return Config{}, err
+	}
 	if cfg.MaxParallel <= 0 {
 		cfg.MaxParallel = 4
 	}
 	if cfg.LogFile == "" {
 		cfg.LogFile = "logs/ncc-runner.log"
 	}
+	if err := cfg.LogRotation.validate(); err != nil {
+		return Config{}, err
+	}
 	if cfg.RetryMaxAttempts <= 0 {
 		cfg.RetryMaxAttempts = 6
 	}
@@ -347,99 +1053,571 @@ func bindConfig() (Config, error) {
 	if cfg.RetryMaxDelay <= 0 {
 		cfg.RetryMaxDelay = 8 * time.Second
 	}
-	return cfg, nil
-}
-
-/************** Logging **************/
-
-// In setupFileLogger, add the new version fields to the global logger context
-func setupFileLogger(logPath string, lvl zerolog.Level) error {
-	dir := filepath.Dir(logPath)
-	if dir != "." {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
+	if cfg.SummaryRetries < 0 {
+		cfg.SummaryRetries = 0
 	}
-	fileWriter := &lumberjack.Logger{
-		Filename:   logPath,
-		MaxSize:    20, // MB
-		MaxBackups: 5,
-		MaxAge:     30, // days
-		Compress:   true,
+	switch cfg.ErrorFormat {
+	case "":
+		cfg.ErrorFormat = "text"
+	case "text", "json":
+	default:
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --error-format %q (want text or json)", cfg.ErrorFormat), nil)
 	}
-	zerolog.TimeFieldFormat = time.RFC3339Nano
-	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	var gitRevision string
-	if bi, ok := debug.ReadBuildInfo(); ok {
-		for _, s := range bi.Settings {
-			if s.Key == "vcs.revision" {
-				gitRevision = s.Value
-				break
-			}
-		}
-		log.Logger = zerolog.New(fileWriter).Level(lvl).With().
-			Timestamp().
-			Str("git_revision", gitRevision).
-			Str("go_version", bi.GoVersion).
-			Str("Version", Version).
-			Str("stream", Stream).
-			Logger()
-	} else {
-		log.Logger = zerolog.New(fileWriter).Level(lvl).With().Timestamp().Logger()
+	if cfg.RequireSecure && cfg.InsecureSkipVerify {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--require-secure is set but insecure-skip-verify is enabled", nil)
 	}
-	return nil
-}
-
-/************** Retry helpers **************/
-
-func jitteredBackoff(base, maxDelay time.Duration, attempt int) time.Duration {
-	exp := float64(base) * math.Pow(2, float64(attempt-1))
-	capDelay := time.Duration(exp)
-	if capDelay > maxDelay {
-		capDelay = maxDelay
+	if cfg.Quiet && cfg.Verbose {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--quiet and --verbose are mutually exclusive", nil)
 	}
-	if capDelay <= 0 {
-		return 0
+	switch cfg.SortOrder {
+	case "":
+		cfg.SortOrder = "severity"
+	case "severity", "cluster", "original":
+	default:
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --sort-order %q (want severity, cluster or original)", cfg.SortOrder), nil)
 	}
-	return time.Duration(rand.Int63n(int64(capDelay)))
-}
-
-func isRetryableStatus(code int) bool {
-	switch code {
-	case 408, 429, 500, 502, 503, 504:
-		return true
+	switch cfg.Progress {
+	case "", "auto", "bars", "json", "none":
 	default:
-		return false
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --progress %q (want auto, bars, json or none)", cfg.Progress), nil)
 	}
-}
-
-func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
-	if resp == nil {
-		return 0, false
+	if _, ok := archiveKind(cfg.Archive); cfg.Archive != "" && !ok {
+		return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --archive %q (want .zip, .tar.gz or .tgz)", cfg.Archive), nil)
 	}
-	ra := resp.Header.Get("Retry-After")
-	if ra == "" {
-		return 0, false
+	if cfg.ElasticEnabled {
+		if u, err := url.Parse(cfg.ElasticURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --elastic-url %q (want http(s)://host:port)", cfg.ElasticURL), nil)
+		}
+		if cfg.ElasticIndex == "" {
+			cfg.ElasticIndex = "ncc-results"
+		}
 	}
-	if secs, err := strconv.Atoi(ra); err == nil {
-		return time.Duration(secs) * time.Second, true
+	if cfg.OutputStdout && (len(cfg.Clusters) > 1 || len(cfg.OutputFormats) != 1) {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--output-stdout requires a single cluster and a single output format", nil)
 	}
-	if t, err := http.ParseTime(ra); err == nil {
-		d := time.Until(t)
-		if d < 0 {
-			d = 0
-		}
-		return d, true
+	if cfg.NoColor && cfg.ForceColor {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--no-color and --force-color are mutually exclusive", nil)
 	}
-	return 0, false
+	if cfg.OutputStdout && cfg.LogStdout {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--log-stdout and --output-stdout both write to stdout", nil)
+	}
+	if cfg.JSONOutput && (cfg.OutputStdout || cfg.LogStdout) {
+		return Config{}, NewNCCError(ErrorTypeConfig, "--json-output cannot be combined with --output-stdout or --log-stdout", nil)
+	}
+	return cfg, nil
 }
 
-/************** HTTP and FS **************/
+const insecureBanner = `
+****************************************************************
+  WARNING: TLS certificate verification is DISABLED
+  (insecure-skip-verify). Prism credentials can be intercepted
+  by anyone on the path. Use only in trusted labs.
+****************************************************************
+`
+
+// warnInsecure logs, and prints to w unless --quiet, a banner when TLS
+// verification is off. Call it once per process, after the logger is set up.
+func warnInsecure(cfg Config, w io.Writer) {
+	if !cfg.InsecureSkipVerify {
+		return
+	}
+	log.Warn().Bool("insecureSkipVerify", true).Msg("TLS certificate verification is disabled")
+	if !cfg.Quiet {
+		fmt.Fprint(w, insecureBanner)
+	}
+}
+
+/************** Errors **************/
+
+type ErrorType string
+
+const (
+	ErrorTypeUnknown    ErrorType = "unknown"
+	ErrorTypeConfig     ErrorType = "config"
+	ErrorTypeValidation ErrorType = "validation"
+	ErrorTypeTimeout    ErrorType = "timeout"
+	ErrorTypeNetwork    ErrorType = "network"
+	ErrorTypeAuth       ErrorType = "auth"
+	ErrorTypeHTTP       ErrorType = "http"
+	ErrorTypeMultiple   ErrorType = "multiple"
+)
+
+// NCCError is a classified error so callers can tell configuration problems
+// apart from runtime failures.
+type NCCError struct {
+	Type    ErrorType
+	Message string
+	Cause   error
+	Context map[string]string
+}
+
+func (e *NCCError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *NCCError) Unwrap() error { return e.Cause }
+
+func NewNCCError(t ErrorType, msg string, cause error) *NCCError {
+	return &NCCError{Type: t, Message: msg, Cause: cause}
+}
+
+func (e *NCCError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type    ErrorType         `json:"type"`
+		Message string            `json:"message"`
+		Cause   any               `json:"cause,omitempty"`
+		Context map[string]string `json:"context,omitempty"`
+	}{e.Type, e.Message, causeJSON(e.Cause), e.Context})
+}
+
+// Is matches a bare &NCCError{Type: t} target by type, so callers can test
+// for a failure class anywhere in a chain (including inside a MultiError).
+func (e *NCCError) Is(target error) bool {
+	t, ok := target.(*NCCError)
+	return ok && t.Message == "" && t.Cause == nil && t.Type == e.Type
+}
+
+// HTTPError is a non-2xx response that was not (or no longer) retried.
+type HTTPError struct {
+	Op         string
+	StatusCode int
+	URL        string
+}
+
+func (e *HTTPError) Error() string { return fmt.Sprintf("%s HTTP %d", e.Op, e.StatusCode) }
+
+func (e *HTTPError) Type() ErrorType {
+	if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+		return ErrorTypeAuth
+	}
+	return ErrorTypeHTTP
+}
+
+func (e *HTTPError) Is(target error) bool {
+	t, ok := target.(*NCCError)
+	return ok && t.Message == "" && t.Cause == nil && t.Type == e.Type()
+}
+
+func (e *HTTPError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       ErrorType `json:"type"`
+		Message    string    `json:"message"`
+		StatusCode int       `json:"status_code"`
+		URL        string    `json:"url"`
+	}{e.Type(), e.Error(), e.StatusCode, e.URL})
+}
+
+// ClusterError ties a failure to the cluster it happened on.
+type ClusterError struct {
+	Cluster string
+	Err     error
+}
+
+func (e *ClusterError) Error() string { return e.Cluster + ": " + e.Err.Error() }
+func (e *ClusterError) Unwrap() error { return e.Err }
+
+func (e *ClusterError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Cluster string    `json:"cluster"`
+		Type    ErrorType `json:"type"`
+		Error   any       `json:"error"`
+	}{e.Cluster, errorType(e.Err), causeJSON(e.Err)})
+}
+
+// MultiError collects the per-cluster failures of a run.
+type MultiError struct {
+	Errors []*ClusterError
+}
+
+func (m *MultiError) Clusters() []string {
+	out := make([]string, 0, len(m.Errors))
+	for _, e := range m.Errors {
+		out = append(out, e.Cluster)
+	}
+	return out
+}
+
+func (m *MultiError) Error() string { return fmt.Sprintf("some clusters failed: %v", m.Clusters()) }
+
+func (m *MultiError) Unwrap() []error {
+	out := make([]error, 0, len(m.Errors))
+	for _, e := range m.Errors {
+		out = append(out, e)
+	}
+	return out
+}
+
+// Type is the shared type of all cluster errors, or "multiple" when they differ.
+func (m *MultiError) Type() ErrorType {
+	var t ErrorType
+	for _, e := range m.Errors {
+		et := errorType(e.Err)
+		if t != "" && t != et {
+			return ErrorTypeMultiple
+		}
+		t = et
+	}
+	if t == "" {
+		return ErrorTypeUnknown
+	}
+	return t
+}
+
+func (m *MultiError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type    ErrorType       `json:"type"`
+		Message string          `json:"message"`
+		Errors  []*ClusterError `json:"errors"`
+	}{m.Type(), m.Error(), m.Errors})
+}
+
+// causeJSON keeps classified causes structured and flattens the rest to text.
+func causeJSON(err error) any {
+	if err == nil {
+		return nil
+	}
+	if m, ok := err.(json.Marshaler); ok {
+		return m
+	}
+	return err.Error()
+}
+
+// errorType returns the type of the outermost classified error in the chain.
+func errorType(err error) ErrorType {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch v := e.(type) {
+		case *NCCError:
+			return v.Type
+		case *HTTPError:
+			return v.Type()
+		case *MultiError:
+			return v.Type()
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTypeTimeout
+	}
+	return ErrorTypeUnknown
+}
+
+// errorJSON renders err for --error-format json.
+func errorJSON(err error) ([]byte, error) {
+	if m, ok := err.(json.Marshaler); ok {
+		return json.Marshal(m)
+	}
+	return json.Marshal(&NCCError{Type: errorType(err), Message: err.Error(), Cause: errors.Unwrap(err)})
+}
+
+/************** Logging **************/
+
+// logRotation holds the lumberjack settings for the log file.
+type logRotation struct {
+	MaxSize    int // MB before rotating
+	MaxBackups int // rotated files kept (0 = all)
+	MaxAge     int // days rotated files are kept (0 = forever)
+	Compress   bool
+}
+
+func (r logRotation) validate() error {
+	switch {
+	case r.MaxSize <= 0:
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --log-max-size %d (want > 0 MB)", r.MaxSize), nil)
+	case r.MaxBackups < 0:
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --log-max-backups %d (want >= 0)", r.MaxBackups), nil)
+	case r.MaxAge < 0:
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --log-max-age %d (want >= 0 days)", r.MaxAge), nil)
+	}
+	return nil
+}
+
+// newLogFileWriter returns the rotating writer for logPath.
+func newLogFileWriter(logPath string, rot logRotation) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   logPath,
+		MaxSize:    rot.MaxSize,
+		MaxBackups: rot.MaxBackups,
+		MaxAge:     rot.MaxAge,
+		Compress:   rot.Compress,
+	}
+}
+
+// isTerminal reports whether w is an interactive terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// colorMode is "auto", "never" or "always"; see setColorMode.
+var colorMode = "auto"
+
+// setColorMode applies --no-color / --force-color. Without either, a
+// non-empty NO_COLOR (https://no-color.org) turns color off.
+func setColorMode(noColor, force bool) {
+	switch {
+	case noColor:
+		colorMode = "never"
+	case force:
+		colorMode = "always"
+		// zerolog's ConsoleWriter checks NO_COLOR on every write itself.
+		_ = os.Unsetenv("NO_COLOR")
+	case os.Getenv("NO_COLOR") != "":
+		colorMode = "never"
+	default:
+		colorMode = "auto"
+	}
+}
+
+// useColor reports whether console output to w may carry ANSI colors.
+func useColor(w io.Writer) bool {
+	switch colorMode {
+	case "never":
+		return false
+	case "always":
+		return true
+	}
+	return isTerminal(w)
+}
+
+// consoleLogWriter renders entries for humans, colored per useColor.
+func consoleLogWriter(w io.Writer) zerolog.ConsoleWriter {
+	return zerolog.ConsoleWriter{
+		Out:           w,
+		TimeFormat:    time.Kitchen,
+		FieldsExclude: []string{"git_revision", "go_version", "Version", "stream"},
+		NoColor:       !useColor(w),
+	}
+}
+
+// In setupFileLogger, add the new version fields to the global logger context.
+// A non-nil mirror (--verbose) also receives every entry in console format.
+// A non-nil stdout (--log-stdout) receives the same JSON as the file, or the
+// console format when it is a terminal.
+func setupFileLogger(logPath string, rot logRotation, lvl zerolog.Level, mirror, stdout io.Writer) error {
+	dir := filepath.Dir(logPath)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	fileWriter := newLogFileWriter(logPath, rot)
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	writers := []io.Writer{fileWriter}
+	if mirror != nil {
+		writers = append(writers, consoleLogWriter(mirror))
+	}
+	if stdout != nil {
+		if isTerminal(stdout) {
+			writers = append(writers, consoleLogWriter(stdout))
+		} else {
+			writers = append(writers, stdout)
+		}
+	}
+	var out io.Writer = fileWriter
+	if len(writers) > 1 {
+		out = zerolog.MultiLevelWriter(writers...)
+	}
+	var gitRevision string
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				gitRevision = s.Value
+				break
+			}
+		}
+		log.Logger = zerolog.New(out).Level(lvl).With().
+			Timestamp().
+			Str("git_revision", gitRevision).
+			Str("go_version", bi.GoVersion).
+			Str("Version", Version).
+			Str("stream", Stream).
+			Logger()
+	} else {
+		log.Logger = zerolog.New(out).Level(lvl).With().Timestamp().Logger()
+	}
+	return nil
+}
+
+// Console prints user-facing status lines; --quiet silences them. Errors
+// are reported by main and are never suppressed.
+type Console struct {
+	W     io.Writer
+	Quiet bool
+}
+
+func (c Console) Printf(format string, a ...any) {
+	if !c.Quiet {
+		fmt.Fprintf(c.W, format, a...)
+	}
+}
+
+func (c Console) Println(a ...any) {
+	if !c.Quiet {
+		fmt.Fprintln(c.W, a...)
+	}
+}
+
+/************** Retry helpers **************/
+
+// retryCount is the retries and total backoff slept for one op on one host.
+type retryCount struct {
+	Retries int
+	Backoff time.Duration
+}
+
+// retryRegistry accumulates retry counts across all cluster goroutines.
+type retryRegistry struct {
+	mu sync.Mutex
+	m  map[string]map[string]*retryCount // host -> op -> count
+}
+
+// retryStats is fed by doWithRetryBody and reported at the end of a batch.
+var retryStats = &retryRegistry{}
+
+func (r *retryRegistry) record(host, op string, backoff time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.m == nil {
+		r.m = map[string]map[string]*retryCount{}
+	}
+	if r.m[host] == nil {
+		r.m[host] = map[string]*retryCount{}
+	}
+	c := r.m[host][op]
+	if c == nil {
+		c = &retryCount{}
+		r.m[host][op] = c
+	}
+	c.Retries++
+	c.Backoff += backoff
+}
+
+// take returns the counts so far and clears them (once per --watch run).
+func (r *retryRegistry) take() map[string]map[string]retryCount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]map[string]retryCount, len(r.m))
+	for host, ops := range r.m {
+		out[host] = make(map[string]retryCount, len(ops))
+		for op, c := range ops {
+			out[host][op] = *c
+		}
+	}
+	r.m = nil
+	return out
+}
+
+// logRetryStats logs one line per host that needed retries, with per-op
+// retry counts and the total backoff slept.
+func logRetryStats(stats map[string]map[string]retryCount) {
+	for _, host := range slices.Sorted(maps.Keys(stats)) {
+		ops := zerolog.Dict()
+		total, backoff := 0, time.Duration(0)
+		for _, op := range slices.Sorted(maps.Keys(stats[host])) {
+			c := stats[host][op]
+			ops = ops.Int(op, c.Retries)
+			total += c.Retries
+			backoff += c.Backoff
+		}
+		log.Info().
+			Str("host", host).
+			Int("retries", total).
+			Float64("backoff_seconds", backoff.Seconds()).
+			Dict("retries_by_op", ops).
+			Msg("retry stats")
+	}
+}
+
+func jitteredBackoff(base, maxDelay time.Duration, attempt int) time.Duration {
+	exp := float64(base) * math.Pow(2, float64(attempt-1))
+	capDelay := time.Duration(exp)
+	if capDelay > maxDelay {
+		capDelay = maxDelay
+	}
+	if capDelay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(capDelay)))
+}
+
+// transportErrorClasses are the values --no-retry-on accepts.
+var transportErrorClasses = []string{"dns", "refused", "reset", "timeout", "other"}
+
+// transportErrorClass sorts a client.Do error into one of
+// transportErrorClasses: a refused dial means the cluster is down and rarely
+// recovers within the backoff window, while DNS blips and resets often do.
+func transportErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		switch {
+		case errors.Is(opErr.Err, syscall.ECONNREFUSED):
+			return "refused"
+		case errors.Is(opErr.Err, syscall.ECONNRESET), errors.Is(opErr.Err, syscall.EPIPE):
+			return "reset"
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "reset"
+	}
+	return "other"
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	ra := resp.Header.Get("Retry-After")
+	if ra == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(ra); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+/************** HTTP and FS **************/
 
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// unauthorizedHandler is implemented by an HTTPClient that holds a session
+// it can renew. On a 401 doWithRetryBody calls OnUnauthorized once and, if it
+// returns true (re-login succeeded), repeats the request without using up
+// a retry. Plain basic-auth clients do not implement it, so a 401 still
+// fails at once.
+type unauthorizedHandler interface {
+	OnUnauthorized(ctx context.Context) bool
+}
+
 type LoggingTransport struct {
 	Base    http.RoundTripper
 	MaxBody int // bytes; 0 = unlimited
@@ -458,22 +1636,25 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		log.Debug().
 			Str("method", req.Method).
 			Str("url", req.URL.String()).
+			Str("requestID", req.Header.Get(requestIDHeader)).
+			Str("userAgent", req.UserAgent()).
 			RawJSON("request_dump", dump).
 			Msg("http request")
 	}
 	resp, err := base.RoundTrip(req)
 	if err != nil {
-		log.Error().Err(err).Str("url", req.URL.String()).Msg("http roundtrip error")
+		log.Error().Err(err).Str("url", req.URL.String()).Str("requestID", req.Header.Get(requestIDHeader)).Msg("http roundtrip error")
 		return nil, err
 	}
 	if resp != nil {
-		if d, err := httputil.DumpResponse(resp, true); err == nil {
+		if d, err := t.dumpResponse(resp); err == nil {
 			dump := d
 			if t.MaxBody > 0 && len(dump) > t.MaxBody {
 				dump = append(dump[:t.MaxBody], []byte("...[truncated]")...)
 			}
 			log.Debug().
 				Int("status", resp.StatusCode).
+				Str("requestID", req.Header.Get(requestIDHeader)).
 				RawJSON("response_dump", dump).
 				Msg("http response")
 		}
@@ -481,6 +1662,58 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	return resp, nil
 }
 
+// dumpResponse dumps resp for the log. A gzip or deflate body the transport
+// left compressed is inflated in the dump (at most MaxBody bytes); callers
+// still read the original bytes.
+func (t *LoggingTransport) dumpResponse(resp *http.Response) ([]byte, error) {
+	enc := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if resp.Uncompressed || (enc != "gzip" && enc != "deflate") {
+		return httputil.DumpResponse(resp, true)
+	}
+	head, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := inflateForLog(enc, body, t.MaxBody)
+	if err != nil {
+		// Not really compressed (or corrupt): dump it as received.
+		return append(head, body...), nil
+	}
+	return append(head, plain...), nil
+}
+
+// inflateForLog decodes a gzip or deflate body, reading at most limit+1
+// bytes (limit <= 0 = unlimited) so the caller's truncation still shows.
+// HTTP "deflate" is usually zlib-wrapped, but raw deflate is accepted too.
+func inflateForLog(enc string, body []byte, limit int) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch enc {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	default:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, int64(limit)+1)
+	}
+	return io.ReadAll(src)
+}
+
 func NewHTTPClient(cfg Config) *http.Client {
 	tr := &http.Transport{
 		DialContext: (&net.Dialer{
@@ -494,17 +1727,107 @@ func NewHTTPClient(cfg Config) *http.Client {
 			InsecureSkipVerify: cfg.InsecureSkipVerify,
 			MinVersion:         cfg.TLSMinVersion,
 		},
-		IdleConnTimeout: 90 * time.Second,
-		MaxIdleConns:    100,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		// A custom TLSClientConfig turns HTTP/2 off unless forced back on.
+		ForceAttemptHTTP2: !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map is the documented way to disable h2.
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
 	}
 	rt := http.RoundTripper(tr)
 	if cfg.LogHTTP || os.Getenv("LOG_HTTP") == "1" {
 		rt = &LoggingTransport{Base: tr, MaxBody: 64 * 1024}
 	}
 	return &http.Client{
-		Timeout:   cfg.Timeout, // overall guard
-		Transport: rt,
+		Timeout:       cfg.Timeout, // overall guard
+		Transport:     rt,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+// maxRedirects bounds how many hops a single request may follow.
+const maxRedirects = 5
+
+var errTooManyRedirects = fmt.Errorf("stopped after %d redirects", maxRedirects)
+
+// redirectHeaders are carried over from the original request on a
+// same-cluster redirect.
+var redirectHeaders = []string{"Authorization", requestIDHeader, "Accept", "Content-Type"}
+
+// clusterMembers maps a cluster's host (as configured) to the other
+// addresses of the same cluster, its virtual IP and CVM IPs, learnt from
+// Prism by NCCClient.ResolveMembers and NCCAvailabilityCheck. Only these
+// may receive re-applied credentials on a redirect.
+var clusterMembers = struct {
+	sync.RWMutex
+	m map[string][]string
+}{m: map[string][]string{}}
+
+// canonicalHost lowercases a host and puts IP addresses in their standard
+// form, so 10.0.0.1, fe80::1 and FE80:0::1 compare as expected.
+func canonicalHost(h string) string {
+	h = strings.ToLower(strings.Trim(h, "[]"))
+	if ip := net.ParseIP(h); ip != nil {
+		return ip.String()
+	}
+	return h
+}
+
+// addClusterMembers records members as addresses of the cluster at host.
+func addClusterMembers(host string, members ...string) {
+	host = canonicalHost(host)
+	clusterMembers.Lock()
+	defer clusterMembers.Unlock()
+	for _, m := range members {
+		if m = canonicalHost(m); m != "" && m != host && !slices.Contains(clusterMembers.m[host], m) {
+			clusterMembers.m[host] = append(clusterMembers.m[host], m)
+		}
+	}
+}
+
+// isClusterMember reports whether target is host itself or a recorded
+// member of host's cluster.
+func isClusterMember(host, target string) bool {
+	host, target = canonicalHost(host), canonicalHost(target)
+	if host == target {
+		return true
+	}
+	clusterMembers.RLock()
+	defer clusterMembers.RUnlock()
+	return slices.Contains(clusterMembers.m[host], target)
+}
+
+// checkRedirect follows Prism Element's redirects to the cluster leader.
+// net/http drops Authorization when the host changes; the headers are
+// re-applied only when the target is HTTPS on the original port and is the
+// original host or a known member of its cluster (see clusterMembers).
+// Anything else is followed with net/http's default header handling, so
+// credentials never go to an unrelated host.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errTooManyRedirects
 	}
+	orig, prev := via[0], via[len(via)-1]
+	sameCluster := req.URL.Scheme == "https" && orig.URL.Scheme == "https" && req.URL.Port() == orig.URL.Port() &&
+		isClusterMember(orig.URL.Hostname(), req.URL.Hostname())
+	if sameCluster {
+		for _, h := range redirectHeaders {
+			if v := orig.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+	}
+	log.Info().
+		Str("from", prev.URL.Redacted()).
+		Str("to", req.URL.Redacted()).
+		Int("hop", len(via)).
+		Bool("sameCluster", sameCluster).
+		Str("requestID", orig.Header.Get(requestIDHeader)).
+		Msg("following redirect")
+	return nil
 }
 
 /************** FS **************/
@@ -514,7 +1837,16 @@ type FS interface {
 	WriteFile(path string, data []byte, perm os.FileMode) error
 	ReadFile(path string) ([]byte, error)
 	ReadDir(path string) ([]os.DirEntry, error)
-	Create(path string) (*os.File, error)
+	Create(path string) (io.WriteCloser, error)
+	Open(path string) (io.ReadCloser, error)
+	Remove(path string) error
+	// RemoveAll removes path and anything under it (see os.RemoveAll).
+	RemoveAll(path string) error
+	Stat(path string) (os.FileInfo, error)
+	// CreateTemp creates a new file in dir (see os.CreateTemp) and returns
+	// it with its path.
+	CreateTemp(dir, pattern string) (io.WriteCloser, string, error)
+	Rename(oldpath, newpath string) error
 }
 
 type OSFS struct{}
@@ -525,7 +1857,298 @@ func (OSFS) WriteFile(path string, data []byte, perm os.FileMode) error {
 }
 func (OSFS) ReadFile(path string) ([]byte, error)       { return os.ReadFile(path) }
 func (OSFS) ReadDir(path string) ([]os.DirEntry, error) { return os.ReadDir(path) }
-func (OSFS) Create(path string) (*os.File, error)       { return os.Create(path) }
+func (OSFS) Create(path string) (io.WriteCloser, error) { return os.Create(path) }
+func (OSFS) Open(path string) (io.ReadCloser, error)    { return os.Open(path) }
+func (OSFS) Remove(path string) error                   { return os.Remove(path) }
+func (OSFS) RemoveAll(path string) error                { return os.RemoveAll(path) }
+func (OSFS) Stat(path string) (os.FileInfo, error)      { return os.Stat(path) }
+func (OSFS) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+func (OSFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	// os.CreateTemp uses 0600; reports are meant to be shared like before.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, f.Name(), nil
+}
+
+// MemFS is an in-memory FS keyed by cleaned path. Directories are implicit.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	seq   int
+}
+
+func NewMemFS() *MemFS { return &MemFS{files: map[string][]byte{}} }
+
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+func (m *MemFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(path)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *MemFS) ReadFile(path string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.files[filepath.Clean(path)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func (m *MemFS) ReadDir(path string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir := filepath.Clean(path)
+	seen := map[string]bool{}
+	var out []os.DirEntry
+	for p, b := range m.files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		name, _, nested := strings.Cut(rel, string(filepath.Separator))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, memDirEntry{name: name, dir: nested, size: int64(len(b))})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (m *MemFS) Create(path string) (io.WriteCloser, error) {
+	if err := m.WriteFile(path, nil, 0644); err != nil {
+		return nil, err
+	}
+	return &memFile{fs: m, path: path}, nil
+}
+
+func (m *MemFS) Open(path string) (io.ReadCloser, error) {
+	b, err := m.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *MemFS) Remove(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(path)
+	if _, ok := m.files[p]; !ok {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(m.files, p)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(path)
+	prefix := p + string(filepath.Separator)
+	for f := range m.files {
+		if f == p || strings.HasPrefix(f, prefix) {
+			delete(m.files, f)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Stat(path string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := filepath.Clean(path)
+	if b, ok := m.files[p]; ok {
+		return memDirEntry{name: filepath.Base(p), size: int64(len(b))}, nil
+	}
+	prefix := p + string(filepath.Separator)
+	for f := range m.files {
+		if strings.HasPrefix(f, prefix) {
+			return memDirEntry{name: filepath.Base(p), dir: true}, nil
+		}
+	}
+	return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	m.mu.Lock()
+	m.seq++
+	n := m.seq
+	m.mu.Unlock()
+	name := pattern + strconv.Itoa(n)
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		name = pattern[:i] + strconv.Itoa(n) + pattern[i+1:]
+	}
+	path := filepath.Join(dir, name)
+	f, err := m.Create(path)
+	return f, path, err
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o := filepath.Clean(oldpath)
+	b, ok := m.files[o]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(m.files, o)
+	m.files[filepath.Clean(newpath)] = b
+	return nil
+}
+
+type memFile struct {
+	fs   *MemFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) { return f.buf.Write(p) }
+func (f *memFile) Close() error                { return f.fs.WriteFile(f.path, f.buf.Bytes(), 0644) }
+
+type memDirEntry struct {
+	name string
+	dir  bool
+	size int64
+}
+
+func (e memDirEntry) Name() string               { return e.name }
+func (e memDirEntry) IsDir() bool                { return e.dir }
+func (e memDirEntry) Type() os.FileMode          { return e.Mode().Type() }
+func (e memDirEntry) Info() (os.FileInfo, error) { return e, nil }
+func (e memDirEntry) Size() int64                { return e.size }
+func (e memDirEntry) ModTime() time.Time         { return time.Time{} }
+func (e memDirEntry) Sys() any                   { return nil }
+func (e memDirEntry) Mode() os.FileMode {
+	if e.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// WriterFS sends every created file to W (e.g. stdout) and delegates all
+// other operations to Base.
+type WriterFS struct {
+	Base FS
+	W    io.Writer
+}
+
+func (w WriterFS) MkdirAll(path string, perm os.FileMode) error { return w.Base.MkdirAll(path, perm) }
+func (w WriterFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return w.Base.WriteFile(path, data, perm)
+}
+func (w WriterFS) ReadFile(path string) ([]byte, error)       { return w.Base.ReadFile(path) }
+func (w WriterFS) ReadDir(path string) ([]os.DirEntry, error) { return w.Base.ReadDir(path) }
+func (w WriterFS) Create(path string) (io.WriteCloser, error) { return nopWriteCloser{w.W}, nil }
+func (w WriterFS) Open(path string) (io.ReadCloser, error)    { return w.Base.Open(path) }
+func (w WriterFS) Remove(path string) error                   { return w.Base.Remove(path) }
+func (w WriterFS) RemoveAll(path string) error                { return w.Base.RemoveAll(path) }
+func (w WriterFS) Stat(path string) (os.FileInfo, error)      { return w.Base.Stat(path) }
+
+// CreateTemp streams to W like Create; there is no file to rename.
+func (w WriterFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	return nopWriteCloser{w.W}, "", nil
+}
+func (w WriterFS) Rename(oldpath, newpath string) error {
+	if oldpath == "" {
+		return nil
+	}
+	return w.Base.Rename(oldpath, newpath)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// atomicFile is written to a temp file next to its target and renamed into
+// place by Commit, so readers never see a half-written report. Close without
+// a successful Commit discards the temp file; callers defer Close.
+type atomicFile struct {
+	fs     FS
+	w      io.WriteCloser
+	tmp    string
+	path   string
+	closed bool
+}
+
+func createAtomic(fs FS, path string) (*atomicFile, error) {
+	w, tmp, err := fs.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{fs: fs, w: w, tmp: tmp, path: path}, nil
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) { return f.w.Write(p) }
+
+// Commit flushes the temp file to disk and renames it over the target.
+func (f *atomicFile) Commit() error {
+	if s, ok := f.w.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	f.closed = true
+	if err := f.w.Close(); err != nil {
+		f.remove()
+		return err
+	}
+	if err := f.fs.Rename(f.tmp, f.path); err != nil {
+		f.remove()
+		return err
+	}
+	return nil
+}
+
+func (f *atomicFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	err := f.w.Close()
+	f.remove()
+	return err
+}
+
+func (f *atomicFile) remove() {
+	if f.tmp != "" {
+		_ = f.fs.Remove(f.tmp)
+	}
+}
+
+// writeFileAtomic is WriteFile through a temp file and rename.
+func writeFileAtomic(fs FS, path string, data []byte) error {
+	f, err := createAtomic(fs, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// reportFS returns the FS the per-cluster renderers should write to.
+func reportFS(cfg Config, fs FS) FS {
+	if cfg.OutputStdout {
+		return WriterFS{Base: fs, W: os.Stdout}
+	}
+	return fs
+}
 
 /************** API Types **************/
 
@@ -534,10 +2157,91 @@ type TaskStatus struct {
 	ProgressStatus     string `json:"progress_status"`
 }
 
+// taskWire holds the fields of both task schemas: v2.0 reports
+// progress_status, v3 reports status (QUEUED, RUNNING, SUCCEEDED, ...);
+// both carry percentage_complete.
+type taskWire struct {
+	PercentageComplete int    `json:"percentage_complete"`
+	ProgressStatus     string `json:"progress_status"`
+	Status             string `json:"status"`
+}
+
+// decodeTaskStatus reads a task body as the given schema, v2 or v3. "auto"
+// takes progress_status when present and falls back to v3's status.
+func decodeTaskStatus(body []byte, version string) (TaskStatus, error) {
+	var w taskWire
+	if err := json.Unmarshal(body, &w); err != nil {
+		return TaskStatus{}, err
+	}
+	st := TaskStatus{PercentageComplete: w.PercentageComplete, ProgressStatus: w.ProgressStatus}
+	switch version {
+	case "v3":
+		st.ProgressStatus = w.Status
+	case "v2":
+	default:
+		if st.ProgressStatus == "" {
+			st.ProgressStatus = w.Status
+		}
+	}
+	return st, nil
+}
+
+// pollNearDone is the percentage from which a backed-off poll drops back to
+// the base interval, so completion is picked up promptly.
+const pollNearDone = 90
+
+// pollDelay is the wait before the next task poll, without jitter. polls is
+// the number of polls made so far and pct the last reported percentage.
+// "exponential" doubles the interval per poll, "stepped" adds one interval
+// every four polls; both stop at maxInterval.
+func pollDelay(mode string, base, maxInterval time.Duration, polls, pct int) time.Duration {
+	if pct >= pollNearDone || maxInterval <= base {
+		return base
+	}
+	d := base
+	switch mode {
+	case "exponential":
+		for i := 0; i < polls && d < maxInterval; i++ {
+			d *= 2
+		}
+	case "stepped":
+		d = base * time.Duration(1+polls/4)
+	default:
+		return base
+	}
+	if d > maxInterval {
+		d = maxInterval
+	}
+	return d
+}
+
+// taskState classifies a v2.0 progress_status or v3 status. An empty status is
+// treated as non-terminal; callers fall back to the percentage in that case.
+func taskState(progress string) (succeeded, failed bool) {
+	switch strings.ToLower(strings.TrimSpace(progress)) {
+	case "succeeded", "success", "completed":
+		return true, false
+	case "failed", "aborted", "canceled", "cancelled":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
 type NCCSummary struct {
 	RunSummary string `json:"runSummary"`
 }
 
+// HealthCheck is one entry of GET /v1/health_checks, the cluster's catalogue
+// of NCC checks.
+type HealthCheck struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description,omitempty"`
+	CheckType     string   `json:"checkType,omitempty"`
+	CategoryTypes []string `json:"categoryTypes,omitempty"`
+}
+
 /************** Parser **************/
 
 var (
@@ -547,30 +2251,206 @@ var (
 )
 
 type Row struct {
-	Severity  string
-	CheckName string
-	Detail    template.HTML
+	Severity       string
+	CheckName      string
+	Category       string
+	Node           string
+	Detail         template.HTML
+	Remediation    string
+	RemediationURL string
 }
 
 type ParsedBlock struct {
-	Severity  string
-	CheckName string
-	DetailRaw string
+	Severity    string
+	CheckName   string
+	DetailRaw   string
+	DetectedAt  time.Time // latest timestamp found in the detail, zero if none
+	Node        string    // host from a "Node X:" header, empty if not per-node
+	Remediation string    // the block's "Refer to KB ..." line, empty if absent
+	Category    string    // plugin/category header the block sits under, empty if none
+	CheckID     string    // normalized CheckName, stable across AOS phrasings; see checkID
+}
+
+var (
+	// reRemediation matches the closing pointer NCC prints under a check,
+	// e.g. "Refer to KB 1513 (http://portal.nutanix.com/kb/1513) for details".
+	reRemediation = regexp.MustCompile(`(?i)^\s*(?:please\s+)?refer\s+to\s+(?:the\s+|nutanix\s+)?(?:kb[\s#-]*\d|https?://)`)
+	reKBNumber    = regexp.MustCompile(`(?i)\bKB[\s#-]*(\d+)\b`)
+)
+
+// remediationOf returns the last "Refer to KB" line of a block.
+func remediationOf(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if reRemediation.MatchString(lines[i]) {
+			return strings.TrimSpace(lines[i])
+		}
+	}
+	return ""
+}
+
+// remediationURL is the link for a remediation line: the URL it contains, or
+// the portal page for a bare "KB 1234".
+func remediationURL(rem string) string {
+	if u := reKBLink.FindString(rem); u != "" {
+		return u
+	}
+	if m := reKBNumber.FindStringSubmatch(rem); m != nil {
+		return "https://portal.nutanix.com/kb/" + m[1]
+	}
+	return ""
+}
+
+// Timestamp shapes seen in NCC detail text. Zone-less values are local time.
+var timestampFormats = []struct {
+	re     *regexp.Regexp
+	layout string
+}{
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`), time.RFC3339Nano},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`), "2006-01-02 15:04:05"},
+	{regexp.MustCompile(`\d{2}/\d{2}/\d{4} \d{2}:\d{2}:\d{2}`), "01/02/2006 15:04:05"},
+	{regexp.MustCompile(`(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun) (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ \d]\d \d{2}:\d{2}:\d{2}(?: [A-Z]{3,4})? \d{4}`), ""},
+}
+
+// detectTimestamp returns the most recent timestamp found in s.
+func detectTimestamp(s string) (time.Time, bool) {
+	var latest time.Time
+	for _, tf := range timestampFormats {
+		for _, m := range tf.re.FindAllString(s, -1) {
+			var t time.Time
+			var err error
+			if tf.layout == "" {
+				t, err = time.ParseInLocation(time.UnixDate, m, time.Local)
+				if err != nil {
+					t, err = time.ParseInLocation(time.ANSIC, m, time.Local)
+				}
+			} else {
+				t, err = time.ParseInLocation(tf.layout, m, time.Local)
+			}
+			if err == nil && t.After(latest) {
+				latest = t
+			}
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+var (
+	// reCheckIDPrefix is the block header wording in front of the check name.
+	reCheckIDPrefix = regexp.MustCompile(`^\s*(?:detailed\s+)?(?:information|details|results?)\s+(?:for|of|about)\s+`)
+	// reCheckIDVariable is a node address or a timestamp (replaced by \x00
+	// beforehand) with the words that usually introduce it. Group 1 is the
+	// address; IPv6 candidates are confirmed by checkIDAddress.
+	reCheckIDVariable = regexp.MustCompile(`(?:\b(?:on|at|for|from|since)\s+)?(?:\b(?:node|host|cvm|svm)\s*)?(\x00|\[?\b[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}\]?|\b\d{1,3}(?:\.\d{1,3}){3}\b)(?::\d+)?`)
+	reCheckIDSep      = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// checkID normalizes a check name so the same check gets the same ID
+// whatever the AOS release's phrasing: the "Detailed information for"
+// wording, node addresses and timestamps are dropped, the rest is
+// lowercased and joined with '_'. "Detailed information for Disk Usage
+// Check on node 10.0.0.1:" and "Detailed information for disk_usage_check:"
+// are both disk_usage_check.
+func checkID(name string) string {
+	for _, tf := range timestampFormats {
+		name = tf.re.ReplaceAllString(name, "\x00")
+	}
+	s := strings.ToLower(name)
+	s = reCheckIDPrefix.ReplaceAllString(s, "")
+	var b strings.Builder
+	last := 0
+	for _, m := range reCheckIDVariable.FindAllStringSubmatchIndex(s, -1) {
+		if !checkIDAddress(s, m[2], m[3]) {
+			continue
+		}
+		b.WriteString(s[last:m[0]])
+		b.WriteByte(' ')
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return strings.Trim(reCheckIDSep.ReplaceAllString(b.String(), "_"), "_")
+}
+
+// checkIDAddress reports whether s[start:end], group 1 of a
+// reCheckIDVariable match, is really a variable part. The IPv6 pattern also
+// matches words like "dead:beef:cafe" or the "::ba" in "foo::bar", so colon
+// forms must stand alone and parse as an IPv6 address with at least one hex
+// digit (a bare "::" is not one).
+func checkIDAddress(s string, start, end int) bool {
+	addr := s[start:end]
+	if !strings.Contains(addr, ":") {
+		return true
+	}
+	word := func(c byte) bool { return c == '_' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' }
+	if start > 0 && word(s[start-1]) || end < len(s) && word(s[end]) {
+		return false
+	}
+	a := strings.Trim(addr, "[]")
+	return net.ParseIP(a) != nil && strings.ContainsAny(a, "0123456789abcdef")
+}
+
+// setBlockPatterns replaces the block start/end patterns used by ParseSummary.
+// Empty strings keep the current pattern.
+func setBlockPatterns(start, end string) error {
+	if start != "" {
+		re, err := regexp.Compile(start)
+		if err != nil {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --block-start-regex %q", start), err)
+		}
+		reBlockStart = re
+	}
+	if end != "" {
+		re, err := regexp.Compile(end)
+		if err != nil {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --block-end-regex %q", end), err)
+		}
+		reBlockEnd = re
+	}
+	return nil
 }
 
+// maxScanLine caps a single line for bufio.Scanner in splitLines.
+const maxScanLine = 4 * 1024 * 1024
+
+// splitLines splits on newlines, dropping a trailing \r like bufio.ScanLines.
+// A line longer than maxScanLine stops the scanner, so that case falls back
+// to an uncapped split and logs the byte offset of the long line.
 func splitLines(s string) []string {
 	sc := bufio.NewScanner(strings.NewReader(s))
-	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	sc.Buffer(make([]byte, 0, 64*1024), maxScanLine)
 	lines := []string{}
 	for sc.Scan() {
 		lines = append(lines, sc.Text())
 	}
+	if err := sc.Err(); err != nil {
+		off := 0
+		for _, l := range strings.SplitAfter(s, "\n") {
+			if len(strings.TrimRight(l, "\r\n")) >= maxScanLine {
+				break
+			}
+			off += len(l)
+		}
+		log.Warn().Err(err).Int("offset", off).Int("maxLine", maxScanLine).Int("linesBefore", len(lines)).Msg("parse: line exceeds scanner limit, splitting without cap")
+		lines = lines[:0]
+		for _, l := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+			lines = append(lines, strings.TrimSuffix(l, "\r"))
+		}
+	}
 	if len(s) > 0 && strings.HasSuffix(s, "\n") {
 		lines = append(lines, "")
 	}
 	return lines
 }
 
+// reNCCMarker matches text every NCC run summary carries, with or without
+// findings (plugin tallies, PASS lines, the "Detailed information" headers).
+var reNCCMarker = regexp.MustCompile(`(?im)^\s*\|?\s*(?:total\s+)?plugins?\b|\bPASS\b|^Detailed information for |\bncc\b|\bhealth[_ ]checks?\b`)
+
+// looksLikeNCCSummary tells a clean run with zero findings apart from a
+// summary that is not NCC output at all (an HTML error page, say).
+func looksLikeNCCSummary(s string) bool {
+	return reNCCMarker.MatchString(s)
+}
+
 func detectSeverity(s string) string {
 	loc := reSeverity.FindStringSubmatch(s)
 	if len(loc) > 1 {
@@ -590,90 +2470,452 @@ func detectSeverity(s string) string {
 	}
 }
 
+// reCategoryHeader matches the plugin headers NCC prints between check
+// blocks, e.g. "Plugin: hardware_checks" or
+// "Running /health_checks/data_protection_checks/pd_check [ PASS ]".
+var reCategoryHeader = regexp.MustCompile(`^\s*(?:(?:Plugin|Category)\s*:\s*(\S.*?)|Running\s*:?\s*/?health_checks/([A-Za-z0-9_]+)\b.*?)\s*$`)
+
+// categoryOf returns the category named by a header line, shortened from
+// the plugin name ("data_protection_checks" becomes "data-protection").
+func categoryOf(line string) (string, bool) {
+	m := reCategoryHeader.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	name := m[1] + m[2]
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "health_checks/")
+	name, _, _ = strings.Cut(name, "/")
+	name = strings.TrimSuffix(name, "_checks")
+	return strings.ReplaceAll(name, "_", "-"), name != ""
+}
+
+var reNodeHeader = regexp.MustCompile(`^\s*Node\s+(\S+):\s*$`)
+
+type nodeSegment struct {
+	node  string
+	lines []string
+}
+
+// splitByNode splits a block's detail at "Node X:" headers so each node gets
+// its own result. Lines before the first header are repeated in every
+// segment. Without headers the block comes back whole with no node.
+func splitByNode(lines []string) []nodeSegment {
+	var preamble []string
+	var segs []nodeSegment
+	for _, ln := range lines {
+		if m := reNodeHeader.FindStringSubmatch(ln); m != nil {
+			segs = append(segs, nodeSegment{node: m[1], lines: append(slices.Clone(preamble), ln)})
+			continue
+		}
+		if len(segs) == 0 {
+			preamble = append(preamble, ln)
+		} else {
+			segs[len(segs)-1].lines = append(segs[len(segs)-1].lines, ln)
+		}
+	}
+	if len(segs) == 0 {
+		return []nodeSegment{{lines: preamble}}
+	}
+	return segs
+}
+
 func ParseSummary(text string) ([]ParsedBlock, error) {
 	lines := splitLines(text)
 	var blocks []ParsedBlock
+	var category string
 	for i := 0; i < len(lines); i++ {
+		if c, ok := categoryOf(lines[i]); ok {
+			category = c
+			continue
+		}
 		if reBlockStart.MatchString(lines[i]) {
 			checkName := lines[i]
 			i++
-			var buf []string
+			var buf, trailer []string
 			for i < len(lines) && !reBlockEnd.MatchString(lines[i]) {
 				buf = append(buf, lines[i])
 				i++
 			}
 			if i < len(lines) {
-				buf = append(buf, lines[i])
+				trailer = lines[i : i+1]
+			}
+			remediation := remediationOf(append(buf[:len(buf):len(buf)], trailer...))
+			for _, seg := range splitByNode(buf) {
+				joined := strings.Join(append(seg.lines, trailer...), "\n")
+				detected, _ := detectTimestamp(joined)
+				blocks = append(blocks, ParsedBlock{
+					Severity:    detectSeverity(joined),
+					CheckName:   checkName,
+					CheckID:     checkID(checkName),
+					DetailRaw:   joined,
+					DetectedAt:  detected,
+					Node:        seg.node,
+					Remediation: remediation,
+					Category:    category,
+				})
 			}
-			joined := strings.Join(buf, "\n")
-			blocks = append(blocks, ParsedBlock{
-				Severity:  detectSeverity(joined),
-				CheckName: checkName,
-				DetailRaw: joined,
-			})
 		}
 	}
 	return blocks, nil
 }
 
-/************** Renderers **************/
+/************** Filters **************/
 
-// func generateHTML(fs FS, rows []Row, filename string) error {
-// 	const tmpl = `
-// <html>
-// <head>
-// <meta charset="utf-8">
-// <style>
-// table { border: 2px solid black; border-collapse: collapse; width: 100%; }
-// th { border: 2px solid black; padding: 10px; text-align: center; background-color: #f2f2f2; }
-// td { border: 2px solid black; padding: 10px; text-align: left; }
-// .FAIL { background-color: red; color: white; }
-// .WARN { background-color: yellow; color: black; }
-// .INFO { background-color: blue; color: white; }
-// .ERR  { background-color: white; color: black; }
-// </style>
-// </head>
-// <body>
-// <table>
-//     <tr>
-//         <th>Severity</th>
-//         <th>NCC Check Name</th>
-//         <th>Detail Information</th>
-//     </tr>
-//     {{range .}}
-//     <tr>
-//         <td class="{{.Severity}}">{{.Severity}}</td>
-//         <td>{{.CheckName}}</td>
-//         <td>{{.Detail}}</td>
-//     </tr>
-//     {{end}}
-// </table>
-// </body>
-// </html>
-// `
-// 	f, err := fs.Create(filename)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer f.Close()
-// 	t := template.Must(template.New("table").Parse(tmpl))
-// 	return t.Execute(f, rows)
-// }
+// parseSince accepts a duration before now ("24h") or an absolute time
+// (RFC3339, "2006-01-02 15:04:05" or "2006-01-02", local time).
+func parseSince(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --since %q (want a duration like 24h or a timestamp)", s), nil)
+}
 
-func generateHTML(fs FS, rows []Row, filename string) error {
-	const tmpl = `
-<html>
-<head>
-  <meta charset="utf-8">
-  <title>NCC Report</title>
-  <style>
-    :root {
-      --fail: #ef4444;
-      --warn: #f59e0b;
-      --info: #3b82f6;
-      --err:  #374151;
-      --border: #d1d5db;
-      --thead: #f3f4f6;
+// compileCheckFilter compiles a check-name filter. An invalid pattern is
+// logged and ignored, so a typo never aborts a run.
+func compileCheckFilter(flag, pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Warn().Err(err).Str("flag", flag).Str("pattern", pattern).Msg("invalid check filter ignored")
+		return nil
+	}
+	return re
+}
+
+// applyFilters drops blocks excluded by the configured filters: --since
+// first, then --filter-check-exclude.
+func applyFilters(blocks []ParsedBlock, cfg Config) []ParsedBlock {
+	if cfg.Since.IsZero() && cfg.CheckExclude == nil {
+		return blocks
+	}
+	out := blocks[:0:0]
+	var old, undated, excluded int
+	for _, b := range blocks {
+		if !cfg.Since.IsZero() {
+			if b.DetectedAt.IsZero() {
+				if !cfg.SinceKeepUndated {
+					undated++
+					continue
+				}
+			} else if b.DetectedAt.Before(cfg.Since) {
+				old++
+				continue
+			}
+		}
+		if cfg.CheckExclude != nil && cfg.CheckExclude.MatchString(b.CheckName) {
+			excluded++
+			continue
+		}
+		out = append(out, b)
+	}
+	if old > 0 || undated > 0 {
+		log.Debug().Time("since", cfg.Since).Int("older", old).Int("undated", undated).Int("kept", len(out)).Msg("since filter applied")
+	}
+	if excluded > 0 {
+		log.Debug().Str("pattern", cfg.CheckExclude.String()).Int("excluded", excluded).Int("kept", len(out)).Msg("check exclude filter applied")
+	}
+	return out
+}
+
+// reTemplateVarKey limits --template-var names to ones usable in templates.
+var reTemplateVarKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// parseTemplateVars turns repeated key=value specs into a map; a repeated
+// key keeps its last value.
+func parseTemplateVars(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	vars := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		k, v, ok := strings.Cut(spec, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !reTemplateVarKey.MatchString(k) {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --template-var %q (want key=value)", spec), nil)
+		}
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// redactRule is one --redact-pattern: matches of re are replaced by repl,
+// which may use $1-style group references.
+type redactRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// parseRedactions compiles "<regex>=<replacement>" specs. The last '=' is the
+// separator, so replacements cannot contain one but patterns can.
+func parseRedactions(specs []string) ([]redactRule, error) {
+	var rules []redactRule
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, "=")
+		if i <= 0 {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --redact-pattern %q (want <regex>=<replacement>)", spec), nil)
+		}
+		re, err := regexp.Compile(spec[:i])
+		if err != nil {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --redact-pattern regex %q", spec[:i]), err)
+		}
+		rules = append(rules, redactRule{re: re, repl: spec[i+1:]})
+	}
+	return rules, nil
+}
+
+// severityOverride rewrites the severity of checks whose name matches re.
+type severityOverride struct {
+	re       *regexp.Regexp
+	severity string
+}
+
+// overrideSeverities applies the first matching rule to each block, before
+// redaction so rules see the check names NCC printed. Every change is logged.
+func overrideSeverities(blocks []ParsedBlock, rules []severityOverride, cluster string) []ParsedBlock {
+	for i := range blocks {
+		for _, r := range rules {
+			if !r.re.MatchString(blocks[i].CheckName) {
+				continue
+			}
+			if blocks[i].Severity != r.severity {
+				log.Info().Str("cluster", cluster).Str("check", blocks[i].CheckName).Str("node", blocks[i].Node).
+					Str("from", blocks[i].Severity).Str("to", r.severity).Str("rule", r.re.String()).Msg("severity override applied")
+				blocks[i].Severity = r.severity
+			}
+			break
+		}
+	}
+	return blocks
+}
+
+// redactBlocks applies the rules to every block's check name, detail,
+// remediation and node (which is taken from the detail) before anything is
+// rendered, so all formats and the aggregated report see the same redacted
+// text.
+func redactBlocks(blocks []ParsedBlock, rules []redactRule) []ParsedBlock {
+	if len(rules) == 0 {
+		return blocks
+	}
+	redact := func(s string) string {
+		for _, r := range rules {
+			s = r.re.ReplaceAllString(s, r.repl)
+		}
+		return s
+	}
+	for i := range blocks {
+		blocks[i].CheckName = redact(blocks[i].CheckName)
+		blocks[i].CheckID = checkID(blocks[i].CheckName) // nothing redacted may survive in the ID
+		blocks[i].DetailRaw = redact(blocks[i].DetailRaw)
+		blocks[i].Remediation = redact(blocks[i].Remediation)
+		blocks[i].Node = redact(blocks[i].Node)
+	}
+	return blocks
+}
+
+/************** Renderers **************/
+
+// func generateHTML(fs FS, rows []Row, filename string) error {
+// 	const tmpl = `
+// <html>
+// <head>
+// <meta charset="utf-8">
+// <style>
+// table { border: 2px solid black; border-collapse: collapse; width: 100%; }
+// th { border: 2px solid black; padding: 10px; text-align: center; background-color: #f2f2f2; }
+// td { border: 2px solid black; padding: 10px; text-align: left; }
+// .FAIL { background-color: red; color: white; }
+// .WARN { background-color: yellow; color: black; }
+// .INFO { background-color: blue; color: white; }
+// .ERR  { background-color: white; color: black; }
+// </style>
+// </head>
+// <body>
+// <table>
+//     <tr>
+//         <th>Severity</th>
+//         <th>NCC Check Name</th>
+//         <th>Detail Information</th>
+//     </tr>
+//     {{range .}}
+//     <tr>
+//         <td class="{{.Severity}}">{{.Severity}}</td>
+//         <td>{{.CheckName}}</td>
+//         <td>{{.Detail}}</td>
+//     </tr>
+//     {{end}}
+// </table>
+// </body>
+// </html>
+// `
+// 	f, err := fs.Create(filename)
+// 	if err != nil {
+// 		return err
+// 	}
+// 	defer f.Close()
+// 	t := template.Must(template.New("table").Parse(tmpl))
+// 	return t.Execute(f, rows)
+// }
+
+// reportTimeFormat and reportLocation stamp the HTML and JSON reports; see
+// setReportTime.
+var (
+	reportTimeFormat = time.RFC3339
+	reportLocation   = time.Local
+)
+
+// reportTimeLayouts are the layout names --report-time-format accepts
+// besides a literal Go layout.
+var reportTimeLayouts = map[string]string{
+	"RFC3339":  time.RFC3339,
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC822Z":  time.RFC822Z,
+	"DateTime": time.DateTime,
+}
+
+// setReportTime sets the report timestamp layout and zone. Empty strings
+// keep the current value (RFC3339, local time).
+func setReportTime(format, zone string) error {
+	if format != "" {
+		if l, ok := reportTimeLayouts[format]; ok {
+			format = l
+		}
+		// A layout without any date or time fields formats to itself.
+		if time.Date(2001, 3, 4, 17, 8, 9, 0, time.UTC).Format(format) == format {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --report-time-format %q: no date or time fields", format), nil)
+		}
+		reportTimeFormat = format
+	}
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --report-timezone %q", zone), err)
+		}
+		reportLocation = loc
+	}
+	return nil
+}
+
+// reportTime formats t for a report.
+func reportTime(t time.Time) string {
+	return t.In(reportLocation).Format(reportTimeFormat)
+}
+
+// severityColors holds the --severity-color overrides by severity; see
+// setSeverityColors. Severities without one keep each template's default.
+var severityColors = map[string]string{}
+
+var reHexColor = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// setSeverityColors parses SEV=#hex specs (FAIL, ERR, WARN or INFO, any
+// case) into the colors the HTML reports use. Nil keeps the defaults.
+func setSeverityColors(specs []string) error {
+	colors := map[string]string{}
+	for _, spec := range specs {
+		sev, color, ok := strings.Cut(spec, "=")
+		sev = strings.ToUpper(strings.TrimSpace(sev))
+		color = strings.TrimSpace(color)
+		if !ok || !slices.Contains(severityOrder, sev) {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --severity-color %q (want FAIL|ERR|WARN|INFO=#rrggbb)", spec), nil)
+		}
+		if !reHexColor.MatchString(color) {
+			return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --severity-color %q: %q is not a hex color like #c00 or #cc0000", spec, color), nil)
+		}
+		colors[sev] = color
+	}
+	severityColors = colors
+	return nil
+}
+
+// sevColor is the report color for sev: its --severity-color override, or
+// def. Overrides are validated hex, so they are safe as raw CSS.
+func sevColor(sev, def string) template.CSS {
+	if c, ok := severityColors[sev]; ok {
+		return template.CSS(c)
+	}
+	return template.CSS(def)
+}
+
+// reportFuncs are the template functions shared by the HTML reports.
+var reportFuncs = template.FuncMap{"sevColor": sevColor}
+
+// limitPerSeverity keeps the first n rows of each severity (n <= 0 keeps all)
+// and returns how many were dropped per severity.
+func limitPerSeverity[T any](rows []T, n int, sev func(T) string) ([]T, map[string]int) {
+	if n <= 0 {
+		return rows, nil
+	}
+	seen := map[string]int{}
+	omitted := map[string]int{}
+	kept := make([]T, 0, len(rows))
+	for _, r := range rows {
+		s := sev(r)
+		if seen[s] >= n {
+			omitted[s]++
+			continue
+		}
+		seen[s]++
+		kept = append(kept, r)
+	}
+	if len(omitted) == 0 {
+		return kept, nil
+	}
+	return kept, omitted
+}
+
+// rowGroup is one collapsible category section of the per-cluster HTML.
+type rowGroup struct {
+	Name string
+	Rows []Row
+}
+
+// groupRowsByCategory splits rows into categories in order of first
+// appearance, keeping row order within each; uncategorized rows go last.
+func groupRowsByCategory(rows []Row) []rowGroup {
+	var groups []rowGroup
+	idx := map[string]int{}
+	var none []Row
+	for _, r := range rows {
+		if r.Category == "" {
+			none = append(none, r)
+			continue
+		}
+		i, ok := idx[r.Category]
+		if !ok {
+			i = len(groups)
+			idx[r.Category] = i
+			groups = append(groups, rowGroup{Name: r.Category})
+		}
+		groups[i].Rows = append(groups[i].Rows, r)
+	}
+	if len(none) > 0 {
+		groups = append(groups, rowGroup{Rows: none})
+	}
+	return groups
+}
+
+func generateHTML(fs FS, rows []Row, filename string, maxPerSev int, vars map[string]string, groupByCategory bool, columns []string) error {
+	const tmpl = `
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>NCC Report</title>
+  <style>
+    :root {
+      --fail: {{sevColor "FAIL" "#ef4444"}};
+      --warn: {{sevColor "WARN" "#f59e0b"}};
+      --info: {{sevColor "INFO" "#3b82f6"}};
+      --err:  {{sevColor "ERR" "#374151"}};
+      --border: #d1d5db;
+      --thead: #f3f4f6;
     }
     * { box-sizing: border-box; }
     body { margin: 16px; font-family: system-ui, -apple-system, Segoe UI, Roboto, Arial, sans-serif; color: #111827; }
@@ -689,608 +2931,2069 @@ func generateHTML(fs FS, rows []Row, filename string) error {
     tbody tr:nth-child(odd) { background: #fafafa; }
     .sev { display: inline-block; padding: 2px 8px; border-radius: 999px; font-weight: 600; font-size: 12px; }
     .sev.FAIL { color: #fff; background: var(--fail); }
-    .sev.WARN { color: #111827; background: #fde68a; }
+    .sev.WARN { color: #111827; background: {{sevColor "WARN" "#fde68a"}}; }
     .sev.INFO { color: #fff; background: var(--info); }
-    .sev.ERR  { color: #111827; background: #e5e7eb; }
+    .sev.ERR  { color: #111827; background: {{sevColor "ERR" "#e5e7eb"}}; }
     .mono { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; white-space: pre-wrap; word-break: break-word; }
+    details { margin-bottom: 12px; }
+    summary { cursor: pointer; font-weight: 600; padding: 6px 0; }
+    .band { display: flex; align-items: center; gap: 8px; flex-wrap: wrap; padding: 10px 12px; margin-bottom: 12px; border: 1px solid var(--border); border-radius: 8px; background: var(--thead); }
+    .status { padding: 4px 12px; border-radius: 999px; font-weight: 700; font-size: 13px; color: #fff; margin-right: 8px; }
+    .status.fail { background: var(--fail); }
+    .status.warn { background: var(--warn); color: #111827; }
+    .status.ok   { background: #10b981; }
   </style>
 </head>
 <body>
   <h1>NCC Report</h1>
   <div class="meta">Generated at {{.Now}}</div>
+  {{if .Vars}}<div class="meta">{{range $k, $v := .Vars}}<span style="margin-right:16px"><b>{{$k}}:</b> {{$v}}</span>{{end}}</div>{{end}}
+  <div class="band">
+    <span class="status {{.Status}}">{{if eq .Status "fail"}}FAIL{{else if eq .Status "warn"}}WARN{{else}}PASS{{end}}</span>
+    {{range .Counts}}<span class="sev {{.Severity}}">{{.Severity}} {{.Count}}</span>{{end}}
+  </div>
+  {{range .Groups}}
+  {{if $.Grouped}}<details open><summary>{{if .Name}}{{.Name}}{{else}}uncategorized{{end}} ({{len .Rows}})</summary>{{end}}
   <table>
     <thead>
       <tr>
-        <th style="width:120px">Severity</th>
-        <th style="width:360px">NCC Check Name</th>
-        <th>Detail Information</th>
+        {{range $.Cols}}
+        {{if eq . "severity"}}<th style="width:120px">Severity</th>
+        {{else if eq . "check"}}<th style="width:360px">NCC Check Name</th>
+        {{else if eq . "category"}}<th style="width:160px">Category</th>
+        {{else if eq . "node"}}<th style="width:160px">Node</th>
+        {{else if eq . "detail"}}<th>Detail Information</th>
+        {{else if eq . "remediation"}}<th style="width:240px">Remediation</th>{{end}}
+        {{end}}
       </tr>
     </thead>
     <tbody>
-      {{range .Rows}}
+      {{if not .Rows}}
+      <tr><td colspan="{{len $.Cols}}">All checks passed: no findings.</td></tr>
+      {{end}}
+      {{range $r := .Rows}}
       <tr>
-        <td><span class="sev {{.Severity}}">{{.Severity}}</span></td>
-        <td class="mono">{{.CheckName}}</td>
-        <td class="mono">{{.Detail}}</td>
+        {{range $.Cols}}
+        {{if eq . "severity"}}<td><span class="sev {{$r.Severity}}">{{$r.Severity}}</span></td>
+        {{else if eq . "check"}}<td class="mono">{{$r.CheckName}}</td>
+        {{else if eq . "category"}}<td class="mono">{{$r.Category}}</td>
+        {{else if eq . "node"}}<td class="mono">{{$r.Node}}</td>
+        {{else if eq . "detail"}}<td class="mono">{{$r.Detail}}</td>
+        {{else if eq . "remediation"}}<td>{{if $r.RemediationURL}}<a href="{{$r.RemediationURL}}" target="_blank" rel="noopener">{{$r.Remediation}}</a>{{else}}{{$r.Remediation}}{{end}}</td>{{end}}
+        {{end}}
       </tr>
       {{end}}
     </tbody>
   </table>
+  {{if $.Grouped}}</details>{{end}}
+  {{end}}
+  {{range $sev, $n := .Omitted}}
+  <div class="meta">… and {{$n}} more {{$sev}} rows (see JSON)</div>
+  {{end}}
 </body>
 </html>`
-	f, err := fs.Create(filename)
+	f, err := createAtomic(fs, filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	// Counted before --max-rows-per-severity so the band reflects every result.
+	counts := countSeverities(rows, func(r Row) string { return r.Severity })
+	shown, omitted := limitPerSeverity(rows, maxPerSev, func(r Row) string { return r.Severity })
+	// Summaries without plugin headers keep the plain four-column table.
+	hasCategory := slices.ContainsFunc(shown, func(r Row) bool { return r.Category != "" })
+	grouped := groupByCategory && hasCategory
+	groups := []rowGroup{{Rows: shown}}
+	if grouped {
+		groups = groupRowsByCategory(shown)
+	}
+	cols := []string{"severity", "check", "detail", "remediation"}
+	if hasCategory && !grouped {
+		cols = slices.Insert(cols, 2, "category")
+	}
+	if len(columns) > 0 {
+		// cluster is implied by the file; the aggregated outputs carry it.
+		cols = slices.DeleteFunc(slices.Clone(columns), func(c string) bool { return c == "cluster" })
+	}
 	data := struct {
-		Rows []Row
-		Now  string
+		Groups  []rowGroup
+		Grouped bool
+		Cols    []string
+		Omitted map[string]int
+		Counts  []sevCount
+		Status  string
+		Vars    map[string]string
+		Now     string
 	}{
-		Rows: rows,
-		Now:  time.Now().Format(time.RFC3339),
+		Groups:  groups,
+		Grouped: grouped,
+		Cols:    cols,
+		Omitted: omitted,
+		Counts:  sevCountsOf(counts, len(rows)),
+		Status:  clusterStatus(counts),
+		Vars:    vars,
+		Now:     reportTime(time.Now()),
+	}
+	t := template.Must(template.New("table").Funcs(reportFuncs).Parse(tmpl))
+	if err := t.Execute(f, data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// reportColumns are the --columns names with their CSV headers, in the
+// order of the default combined.csv.
+var reportColumns = []struct{ Name, Header string }{
+	{"cluster", "Cluster"},
+	{"severity", "Severity"},
+	{"check", "CheckName"},
+	{"detail", "Detail"},
+	{"node", "Node"},
+	{"category", "Category"},
+	{"remediation", "Remediation"},
+}
+
+// parseColumns validates a --columns list; empty keeps every renderer's
+// default columns.
+func parseColumns(s string) ([]string, error) {
+	var cols []string
+	for _, c := range splitCSV(strings.ToLower(s)) {
+		if !slices.ContainsFunc(reportColumns, func(rc struct{ Name, Header string }) bool { return rc.Name == c }) {
+			names := make([]string, len(reportColumns))
+			for i, rc := range reportColumns {
+				names[i] = rc.Name
+			}
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --columns entry %q (want %s)", c, strings.Join(names, ", ")), nil)
+		}
+		if slices.Contains(cols, c) {
+			return nil, NewNCCError(ErrorTypeConfig, fmt.Sprintf("--columns lists %q twice", c), nil)
+		}
+		cols = append(cols, c)
+	}
+	if len(cols) == 1 && cols[0] == "cluster" {
+		return nil, NewNCCError(ErrorTypeConfig, "--columns needs at least one column besides cluster", nil)
+	}
+	return cols, nil
+}
+
+// columnHeader is the CSV header for a --columns name.
+func columnHeader(col string) string {
+	for _, rc := range reportColumns {
+		if rc.Name == col {
+			return rc.Header
+		}
 	}
-	t := template.Must(template.New("table").Parse(tmpl))
-	return t.Execute(f, data)
+	return col
 }
 
-func generateCSV(fs FS, blocks []ParsedBlock, filename string) error {
-	f, err := fs.Create(filename)
+// blockColumn is the CSV value of col for a block on cluster.
+func blockColumn(b ParsedBlock, cluster, col string) string {
+	switch col {
+	case "cluster":
+		return cluster
+	case "severity":
+		return b.Severity
+	case "check":
+		return b.CheckName
+	case "detail":
+		return b.DetailRaw
+	case "node":
+		return b.Node
+	case "category":
+		return b.Category
+	case "remediation":
+		return b.Remediation
+	}
+	return ""
+}
+
+// generateCSV writes the per-cluster CSV. columns picks and orders the
+// columns (cluster is left out: the file is per cluster); empty keeps
+// Severity,CheckName,Detail,Node,Category.
+func generateCSV(fs FS, blocks []ParsedBlock, filename string, columns []string) error {
+	f, err := createAtomic(fs, filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 	w := csv.NewWriter(f)
-	defer w.Flush()
-	if err := w.Write([]string{"Severity", "CheckName", "Detail"}); err != nil {
+	cols := []string{"severity", "check", "detail", "node", "category"}
+	if len(columns) > 0 {
+		cols = slices.DeleteFunc(slices.Clone(columns), func(c string) bool { return c == "cluster" })
+	}
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = columnHeader(c)
+	}
+	if err := w.Write(header); err != nil {
 		return err
 	}
 	for _, b := range blocks {
-		if err := w.Write([]string{b.Severity, b.CheckName, b.DetailRaw}); err != nil {
+		rec := make([]string, len(cols))
+		for i, c := range cols {
+			rec[i] = blockColumn(b, "", c)
+		}
+		if err := w.Write(rec); err != nil {
 			return err
 		}
 	}
-	return w.Error()
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Commit()
 }
 
-func rowsFromBlocks(blocks []ParsedBlock) []Row {
-	rows := make([]Row, 0, len(blocks))
+// reKBLink matches the same link the HTML report shows in its KB column.
+var reKBLink = regexp.MustCompile(`(?i)https?://[^\s)]+portal\.nutanix\.com/kb/\d+|https?://[^\s)]+`)
+
+// jsonlRecord is one line of the jsonl output.
+type jsonlRecord struct {
+	Cluster     string `json:"cluster"`
+	Severity    string `json:"severity"`
+	Check       string `json:"check"`
+	CheckID     string `json:"check_id,omitempty"`
+	Detail      string `json:"detail"`
+	KB          string `json:"kb,omitempty"`
+	Node        string `json:"node,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// generateJSONL writes one compact JSON object per result, for log
+// pipelines that ingest newline-delimited JSON.
+func generateJSONL(fs FS, blocks []ParsedBlock, cluster, filename string) error {
+	f, err := createAtomic(fs, filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
 	for _, b := range blocks {
-		detail := template.HTML(strings.ReplaceAll(html.EscapeString(b.DetailRaw), "\n", "<br>"))
-		rows = append(rows, Row{
-			Severity:  b.Severity,
-			CheckName: html.EscapeString(strings.ReplaceAll(b.CheckName, "\n", " ")),
-			Detail:    detail,
-		})
+		kb := remediationURL(b.Remediation)
+		if kb == "" {
+			kb = reKBLink.FindString(b.DetailRaw)
+		}
+		rec := jsonlRecord{
+			Cluster:     cluster,
+			Severity:    b.Severity,
+			Check:       b.CheckName,
+			CheckID:     b.CheckID,
+			Detail:      b.DetailRaw,
+			KB:          kb,
+			Node:        b.Node,
+			Category:    b.Category,
+			Remediation: b.Remediation,
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
 	}
-	return rows
+	return f.Commit()
 }
 
-/************** Aggregation **************/
+// countSeverities tallies rows per severity.
+func countSeverities[T any](rows []T, sev func(T) string) map[string]int {
+	counts := make(map[string]int, len(severityOrder))
+	for _, r := range rows {
+		counts[sev(r)]++
+	}
+	return counts
+}
 
-type AggBlock struct {
-	Cluster  string
+// clusterStatus is the per-cluster report badge: fail if any FAIL row, warn
+// if any WARN row, ok otherwise.
+func clusterStatus(counts map[string]int) string {
+	switch {
+	case counts["FAIL"] > 0:
+		return "fail"
+	case counts["WARN"] > 0:
+		return "warn"
+	}
+	return "ok"
+}
+
+// sevCount is one severity's share of a cluster's results, for the
+// --summary-only renderers.
+type sevCount struct {
 	Severity string
-	Check    string
-	Detail   string
+	Count    int
+	Pct      int
 }
 
-func writeAggregatedHTMLSingle(fs FS, outDir string, rows []AggBlock, perCluster []struct{ Cluster, HTML, CSV string }) error {
-	if err := fs.MkdirAll(outDir, 0755); err != nil {
-		return fmt.Errorf("mkdir %s: %w", outDir, err)
+// sevCounts returns a count per known severity, in severityOrder, followed
+// by any others NCC reported.
+func sevCounts(blocks []ParsedBlock) []sevCount {
+	return sevCountsOf(countSeverities(blocks, func(b ParsedBlock) string { return b.Severity }), len(blocks))
+}
+
+// sevCountsOf is sevCounts over an existing tally of total rows.
+func sevCountsOf(counts map[string]int, total int) []sevCount {
+	sevs := slices.Clone(severityOrder)
+	for _, s := range slices.Sorted(maps.Keys(counts)) {
+		if !slices.Contains(sevs, s) {
+			sevs = append(sevs, s)
+		}
 	}
-	path := filepath.Join(outDir, "index.html")
-	abs, _ := filepath.Abs(path)
-	const tmpl = `
-	<html>
-	<head>
-	<meta charset="utf-8">
-	<title>NCC Aggregated Report</title>
-	<style>
-	:root {
-	  --bg: #0f172a;
-	  --card: #111827;
-	  --text: #e5e7eb;
-	  --muted: #9ca3af;
-	  --accent: #2563eb;
-	  --row1: #0b1224;
-	  --row2: #0e1630;
-	  --border: #1f2937;
-	  --fail: #ef4444;
-	  --warn: #f59e0b;
-	  --info: #3b82f6;
-	  --details: #aaa;
-	  --err:  #94a3b8;
+	out := make([]sevCount, 0, len(sevs))
+	for _, s := range sevs {
+		c := sevCount{Severity: s, Count: counts[s]}
+		if total > 0 {
+			c.Pct = c.Count * 100 / total
+		}
+		out = append(out, c)
 	}
-	* { box-sizing: border-box; }
-	html, body { height: 100%; }
-	body {
-	  margin: 0;
-	  font-family: ui-sans-serif, system-ui, -apple-system, Segoe UI, Roboto, Arial, sans-serif;
-	  background: linear-gradient(180deg,#0b1224,#0e1630);
-	  color: var(--text);
+	return out
+}
+
+// generateSummaryHTML is the --summary-only per-cluster page: a count and
+// bar per severity instead of the detail table.
+func generateSummaryHTML(fs FS, blocks []ParsedBlock, cluster, filename string, vars map[string]string) error {
+	const tmpl = `
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>NCC Summary</title>
+  <style>
+    :root { --fail: {{sevColor "FAIL" "#ef4444"}}; --warn: {{sevColor "WARN" "#f59e0b"}}; --info: {{sevColor "INFO" "#3b82f6"}}; --err: {{sevColor "ERR" "#374151"}}; --border: #d1d5db; --thead: #f3f4f6; }
+    body { margin: 16px; font-family: system-ui, -apple-system, Segoe UI, Roboto, Arial, sans-serif; color: #111827; }
+    h1 { margin: 0 0 8px 0; font-size: 20px; }
+    .meta { color: #6b7280; font-size: 12px; margin-bottom: 12px; }
+    table { border-collapse: collapse; border: 1px solid var(--border); min-width: 480px; }
+    th { background: var(--thead); padding: 10px; text-align: left; font-size: 13px; }
+    td { border-top: 1px solid var(--border); padding: 10px; }
+    .bar { background: #f3f4f6; width: 240px; height: 10px; border-radius: 5px; overflow: hidden; }
+    .bar span { display: block; height: 100%; background: #9ca3af; }
+    .bar .FAIL { background: var(--fail); } .bar .WARN { background: var(--warn); }
+    .bar .ERR { background: var(--err); } .bar .INFO { background: var(--info); }
+  </style>
+</head>
+<body>
+  <h1>NCC Summary: {{.Cluster}}</h1>
+  <div class="meta">Generated at {{.Now}}</div>
+  {{if .Vars}}<div class="meta">{{range $k, $v := .Vars}}<span style="margin-right:16px"><b>{{$k}}:</b> {{$v}}</span>{{end}}</div>{{end}}
+  <table>
+    <thead><tr><th>Severity</th><th>Count</th><th></th></tr></thead>
+    <tbody>
+      {{range .Counts}}
+      <tr><td>{{.Severity}}</td><td>{{.Count}}</td><td><div class="bar"><span class="{{.Severity}}" style="width:{{.Pct}}%"></span></div></td></tr>
+      {{end}}
+      <tr><th>Total</th><th>{{.Total}}</th><th></th></tr>
+    </tbody>
+  </table>
+</body>
+</html>`
+	f, err := createAtomic(fs, filename)
+	if err != nil {
+		return err
 	}
-	.container { max-width: 1200px; margin: 24px auto; padding: 0 16px; }
-	.header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 16px; }
-	.title h1 { margin: 0; font-size: 22px; font-weight: 700; }
-	.title .sub { color: var(--muted); font-size: 12px; }
-	.controls { display: flex; flex-wrap: wrap; gap: 12px; align-items: center; margin: 12px 0 18px 0; }
-	.control { background: #0d152b; border: 1px solid var(--border); border-radius: 10px; padding: 10px 12px; display: flex; gap: 8px; align-items: center; }
-	.control label { font-size: 12px; color: var(--muted); margin-right: 6px; }
-	input[type="text"] { background: #0a1123; border: 1px solid var(--border); color: var(--text); padding: 8px 10px; border-radius: 8px; outline: none; width: 280px; }
-	select, button { background: #0a1123; border: 1px solid var(--border); color: var(--text); padding: 8px 10px; border-radius: 8px; outline: none; }
-	button:hover { border-color: var(--accent); cursor: pointer; }
-	.badge { display:inline-flex; align-items:center; gap:6px; padding: 6px 10px; border-radius: 999px; background:#0a1123; border:1px solid var(--border); user-select:none; }
-	.badge .dot { width: 8px; height: 8px; border-radius: 999px; display:inline-block; }
-	.dot.fail{ background: var(--fail); } .dot.warn{ background: var(--warn); }
-	.dot.info{ background: var(--info); } .dot.err{ background: var(--err); }
-	.legend { display:flex; gap:8px; flex-wrap: wrap; }
-	.card { background: #0d152b; border: 1px solid var(--border); border-radius: 12px; padding: 12px; }
-	
-	/* Summary counters visible */
-	.summary { display:grid; grid-template-columns: repeat(5, 1fr); gap:12px; margin: 16px 0; }
-	.sum-item { background: #0a1123; border: 1px solid var(--border); border-radius: 10px; padding: 10px; }
-	.sum-item .label { font-size: 12px; color: var(--muted); }
-	.sum-item .count { font-size: 18px; font-weight: 700; margin-top: 6px; }
-	.progress { height: 6px; border-radius: 999px; background: #0d152b; margin-top: 8px; overflow: hidden; border:1px solid var(--border); }
-	.progress > span { display:block; height:100%; }
-	.progress.fail > span { background: var(--fail); } .progress.warn > span { background: var(--warn); }
-	.progress.err  > span { background: var(--err); }  .progress.info > span { background: var(--info); }
-	
-	/* Scroll container for wide tables */
-	.scroll { overflow-x: auto; overflow-y: hidden; }
-	.scroll::-webkit-scrollbar { height: 10px; }
-	.scroll::-webkit-scrollbar-thumb { background: #22304d; border-radius: 8px; }
-	.scroll::-webkit-scrollbar-track { background: #0a1123; }
-	
-	/* Table */
-	table { width: 100%; border-collapse: collapse; table-layout: fixed; }
-	thead th {
-	  position: sticky; top: 0; z-index: 1;
-	  background: #0d152b; border-bottom: 1px solid var(--border);
-	  padding: 10px; text-align: left; font-size: 12px; color: var(--muted);
+	defer f.Close()
+	data := struct {
+		Cluster string
+		Counts  []sevCount
+		Total   int
+		Vars    map[string]string
+		Now     string
+	}{
+		Cluster: cluster,
+		Counts:  sevCounts(blocks),
+		Total:   len(blocks),
+		Vars:    vars,
+		Now:     reportTime(time.Now()),
 	}
-	tbody td { padding: 10px; border-bottom: 1px solid var(--border); vertical-align: top; }
-	thead th, tbody td { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
-	
-	tbody tr:nth-child(odd) { background: var(--row1); }
-	tbody tr:nth-child(even){ background: var(--row2); }
-	
-	td .severity { padding: 2px 8px; border-radius: 999px; font-size: 12px; }
-	.sev-FAIL { background: #2b0d0d; color: var(--fail); border: 1px solid #4c1d1d; }
-	.sev-WARN { background: #2b1f0d; color: var(--warn); border: 1px solid #4a3112; }
-	.sev-INFO { background: #0c1f35; color: var(--info); border: 1px solid #173e6d; }
-	.sev-ERR  { background: #1b2130; color: var(--err);  border: 1px solid #2c354a; }
-	
-	small.mono { color: var(--muted); font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; }
-	.highlight { background: #3b82f655; }
-	
-	/* Column sizing */
-	th.col-cluster, td.col-cluster   { width: 140px; }
-	th.col-sev,     td.col-sev       { width: 96px; }
-	th.col-title,   td.col-title     { width: 240px; }
-	th.col-kb,      td.col-kb        { width: 110px; }
-	th.col-detail,  td.col-detail    { width: 640px; }
-	th.col-actions, td.col-actions   { width: 220px; }
-	
-    td.col-detail { white-space: normal; overflow: visible; }
-    .detail-full { color: var(--details); font-size: 13px; line-height: 1.35; }
-	
-	/* Actions */
-	tbody tr.selected { outline: 2px solid var(--accent); outline-offset: -2px; }
-	.actions { white-space: nowrap; display: inline-flex; gap: 6px; flex-wrap: wrap; }
-	.actions button { background:#0a1123; border:1px solid var(--border); color:var(--text); padding:6px 8px; border-radius:8px; }
-	.actions button:hover { border-color: var(--accent); cursor:pointer; }
-	
-	/* Link styling (URLs) */
-	a { color: #93c5fd; text-decoration: none; }
-	a:hover { text-decoration: underline; color: #bfdbfe; }
-	a:visited { color: #a5b4fc; }
-	a[href^="http"]::after {
-	  content: "↗";
-	  font-size: 11px;
-	  margin-left: 4px;
-	  color: #64748b;
+	t := template.Must(template.New("summary").Funcs(reportFuncs).Parse(tmpl))
+	if err := t.Execute(f, data); err != nil {
+		return err
 	}
-	  /* Custom checkbox - hide default */
-.control input[type="checkbox"] {
-  position: absolute;
-  opacity: 0;
-  cursor: pointer;
-  height: 0;
-  width: 0;
+	return f.Commit()
+}
+
+// generateSummaryCSV writes one Severity,Count line per severity.
+func generateSummaryCSV(fs FS, blocks []ParsedBlock, filename string) error {
+	f, err := createAtomic(fs, filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"Severity", "Count"}); err != nil {
+		return err
+	}
+	for _, c := range sevCounts(blocks) {
+		if err := w.Write([]string{c.Severity, strconv.Itoa(c.Count)}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Commit()
 }
 
+// generateSummaryJSONL writes one {"cluster","severity","count"} line per
+// severity.
+func generateSummaryJSONL(fs FS, blocks []ParsedBlock, cluster, filename string) error {
+	f, err := createAtomic(fs, filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, c := range sevCounts(blocks) {
+		rec := struct {
+			Cluster  string `json:"cluster"`
+			Severity string `json:"severity"`
+			Count    int    `json:"count"`
+		}{cluster, c.Severity, c.Count}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return f.Commit()
+}
 
-.control span {
-  display: flex;
-  align-items: center;
-  justify-content: center;
-  position: relative;
-  padding-left: 24px;
-  min-height: 16px; /* Match box height */
-  cursor: pointer;
-  color: var(--muted);
+func rowsFromBlocks(blocks []ParsedBlock) []Row {
+	rows := make([]Row, 0, len(blocks))
+	for _, b := range blocks {
+		detail := template.HTML(strings.ReplaceAll(html.EscapeString(b.DetailRaw), "\n", "<br>"))
+		rows = append(rows, Row{
+			Severity:       b.Severity,
+			CheckName:      html.EscapeString(strings.ReplaceAll(b.CheckName, "\n", " ")),
+			Category:       b.Category,
+			Node:           b.Node,
+			Detail:         detail,
+			Remediation:    b.Remediation,
+			RemediationURL: remediationURL(b.Remediation),
+		})
+	}
+	return rows
 }
 
+/************** Ordering **************/
 
-.control span::before {
-  content: "";
-  position: absolute;
-  top: 50%;
-  left: 0;
-  transform: translateY(-50%); /* Vertically center the box itself */
-  height: 16px;
-  width: 16px;
-  background-color: #0a1123;
-  border: 1px solid var(--border);
-  border-radius: 4px;
-  box-sizing: border-box; /* Ensure border is included in size */
+// severityOrder is the single source of severity ranking for every
+// renderer, including the aggregated page's client-side sort.
+var severityOrder = []string{"FAIL", "ERR", "WARN", "INFO"}
+
+// severityRank orders severities most severe first; unknown ones sort last.
+func severityRank(sev string) int {
+	if i := slices.Index(severityOrder, sev); i >= 0 {
+		return i
+	}
+	return len(severityOrder)
 }
 
+func sevRankJSON() []byte {
+	m := make(map[string]int, len(severityOrder))
+	for i, s := range severityOrder {
+		m[s] = i + 1
+	}
+	b, _ := json.Marshal(m)
+	return b
+}
 
-.control span::after {
-  content: "";
-  width: 9px;
-  height: 9px;
-  background-color: var(--muted);
-  position: absolute;
-  top: 50%;
-  left: 8px; /* Half of box width (16px / 2 = 8px) for horizontal center */
-  transform: translate(-50%, -50%) scale(0); /* Vertical center with translate */
-  transition: transform 0.2s ease-in-out;
-  border-radius: 2px;
+// sortBlocks orders a cluster's results for --sort-order: severity then
+// check name, or parse order for "original". Per cluster, "cluster" is the
+// same as "severity".
+func sortBlocks(blocks []ParsedBlock, order string) {
+	if order == "original" {
+		return
+	}
+	slices.SortStableFunc(blocks, func(a, b ParsedBlock) int {
+		if c := severityRank(a.Severity) - severityRank(b.Severity); c != 0 {
+			return c
+		}
+		return strings.Compare(a.CheckName, b.CheckName)
+	})
+}
+
+// sortAggRows orders aggregated rows for --sort-order: "severity" (then
+// cluster, check), "cluster" (then severity, check) or "original". Checks
+// compare by CheckID, so one check's rows stay together across phrasings.
+func sortAggRows(rows []AggBlock, order string) {
+	if order == "original" {
+		return
+	}
+	slices.SortStableFunc(rows, func(a, b AggBlock) int {
+		bySev := severityRank(a.Severity) - severityRank(b.Severity)
+		byCluster := strings.Compare(a.Cluster, b.Cluster)
+		if order == "cluster" {
+			if byCluster != 0 {
+				return byCluster
+			}
+			if bySev != 0 {
+				return bySev
+			}
+		} else {
+			if bySev != 0 {
+				return bySev
+			}
+			if byCluster != 0 {
+				return byCluster
+			}
+		}
+		if c := strings.Compare(a.CheckID, b.CheckID); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Check, b.Check)
+	})
 }
 
+/************** Aggregation **************/
 
-.control input[type="checkbox"]:checked ~ span::after {
-  transform: translate(-50%, -50%) scale(1);
+// clusterRank maps each configured cluster to its position in clusters.
+func clusterRank(clusters []string) map[string]int {
+	rank := make(map[string]int, len(clusters))
+	for i, c := range clusters {
+		if _, ok := rank[c]; !ok {
+			rank[c] = i
+		}
+	}
+	return rank
 }
 
+type AggBlock struct {
+	Cluster        string            `json:"cluster"`
+	Severity       string            `json:"severity"`
+	Check          string            `json:"check"`
+	CheckID        string            `json:"check_id,omitempty"`
+	Detail         string            `json:"detail"`
+	Node           string            `json:"node,omitempty"`
+	Category       string            `json:"category,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Remediation    string            `json:"remediation,omitempty"`
+	RemediationURL string            `json:"remediation_url,omitempty"`
+}
 
-/* Hover effect on box */
-.control span:hover::before {
-  border-color: var(--accent);
+func newAggBlock(cluster string, b ParsedBlock, labels map[string]string) AggBlock {
+	return AggBlock{
+		Cluster:        cluster,
+		Severity:       b.Severity,
+		Check:          b.CheckName,
+		CheckID:        b.CheckID,
+		Detail:         b.DetailRaw,
+		Node:           b.Node,
+		Category:       b.Category,
+		Labels:         labels,
+		Remediation:    b.Remediation,
+		RemediationURL: remediationURL(b.Remediation),
+	}
 }
 
+// ClusterSummary is the per-cluster status entry of the aggregated JSON report.
+type ClusterSummary struct {
+	Cluster         string             `json:"cluster"`
+	Status          string             `json:"status"`
+	Error           string             `json:"error,omitempty"`
+	Reachable       bool               `json:"reachable"`
+	Started         bool               `json:"ncc_started"`
+	Completed       bool               `json:"completed"`
+	Fail            int                `json:"fail"`
+	Warn            int                `json:"warn"`
+	Err             int                `json:"err"`
+	Info            int                `json:"info"`
+	DurationSeconds float64            `json:"duration_seconds,omitempty"`
+	PhaseSeconds    map[string]float64 `json:"phase_seconds,omitempty"`
+	Labels          map[string]string  `json:"labels,omitempty"`
+	Cached          bool               `json:"cached,omitempty"`
+}
 
-/* Focus effect for accessibility */
-.control input[type="checkbox"]:focus + span::before {
-  outline: 2px solid var(--accent);
+// summarizeResult builds a cluster's status entry from its run result.
+func summarizeResult(r ClusterResult, labels map[string]string) ClusterSummary {
+	sum := ClusterSummary{
+		Cluster:         r.Cluster,
+		Status:          "ok",
+		Reachable:       r.Reachable,
+		Started:         r.Started,
+		Completed:       r.Err == nil,
+		DurationSeconds: r.Duration.Seconds(),
+		PhaseSeconds:    phaseSeconds(r.Phases),
+		Labels:          labels,
+		Cached:          r.Cached,
+	}
+	if r.Err != nil {
+		sum.Status = "failed"
+		if r.Aborted {
+			sum.Status = "aborted"
+		}
+		sum.Error = r.Err.Error()
+	}
+	for _, b := range r.Blocks {
+		switch b.Severity {
+		case "FAIL":
+			sum.Fail++
+		case "WARN":
+			sum.Warn++
+		case "ERR":
+			sum.Err++
+		case "INFO":
+			sum.Info++
+		}
+	}
+	return sum
 }
 
-	</style>
-	<script>
-	// Embedded data
-	const AGG = {{.JSON}};
-	
-	// State
-	let state = {
-	  sortKey: "Cluster",
-	  sortDir: "asc",
-	  filterSev: new Set(["FAIL","WARN","ERR","INFO"]),
-	  filterClusters: new Set(),
-	  search: ""
-	};
-	
-	const sevRank = { FAIL: 1, WARN: 2, ERR: 3, INFO: 4 };
-	let selIndex = -1;
-	
-	function init() {
-	  buildClusterFilter();
-	  updateAndRender();
-	  document.addEventListener("keydown", onKey);
+// aggregatedJSONName is the aggregated JSON file name for the aggregated
+// HTML name: index.html gives index.json, nightly.htm gives nightly.json.
+func aggregatedJSONName(htmlName string) string {
+	return strings.TrimSuffix(htmlName, filepath.Ext(htmlName)) + ".json"
+}
+
+// validateAggregatedFilename checks --aggregated-filename is a plain file
+// name whose derived JSON name is distinct and does not clash with the
+// other shared outputs.
+func validateAggregatedFilename(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || filepath.Base(name) != name {
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --aggregated-filename %q: want a file name without path separators", name), nil)
 	}
-	
-	function buildClusterFilter() {
-	  const clusters = Array.from(new Set(AGG.map(r => r.Cluster))).sort();
-	  const sel = document.getElementById("clusterSel");
-	  sel.innerHTML = "";
-	  clusters.forEach(c => {
-		const opt = document.createElement("option");
-		opt.value = c; opt.textContent = c;
-		sel.appendChild(opt);
-	  });
-	  state.filterClusters = new Set(clusters); // select all by default
-	  sel.size = Math.min(6, clusters.length);
+	js := aggregatedJSONName(name)
+	if js == name {
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --aggregated-filename %q: the aggregated JSON would overwrite it; use an .html name", name), nil)
 	}
-	
-	function setSev(checked, sev) {
-	  if (checked) state.filterSev.add(sev); else state.filterSev.delete(sev);
-	  updateAndRender();
+	if slices.Contains([]string{"combined.csv", "combined.json", "manifest.json"}, name) || slices.Contains([]string{"combined.json", "manifest.json"}, js) {
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("invalid --aggregated-filename %q: clashes with %s or another report file", name, js), nil)
 	}
-	
-	function onClusterChange(sel) {
-	  const chosen = new Set(Array.from(sel.selectedOptions).map(o => o.value));
-	  if (chosen.size === 0) {
-		Array.from(sel.options).forEach(o => o.selected = true);
-		chosen.clear(); Array.from(sel.options).forEach(o => chosen.add(o.value));
-	  }
-	  state.filterClusters = chosen;
-	  updateAndRender();
+	return nil
+}
+
+// writeAggregatedJSON writes the aggregated JSON (index.json by default).
+// summary holds the severity totals across clusters; results is left out
+// with --summary-only.
+func writeAggregatedJSON(fs FS, outDir, filename string, clusters []ClusterSummary, rows []AggBlock, vars map[string]string, summaryOnly bool) error {
+	if err := fs.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", outDir, err)
 	}
-	
-	function onSearch(inp) {
-	  state.search = inp.value.trim();
-	  updateAndRender();
+	path := filepath.Join(outDir, filename)
+	report := struct {
+		GeneratedAt string            `json:"generated_at"`
+		Metadata    map[string]string `json:"metadata,omitempty"`
+		Clusters    []ClusterSummary  `json:"clusters"`
+		Summary     map[string]int    `json:"summary"`
+		Results     *[]AggBlock       `json:"results,omitempty"`
+	}{
+		GeneratedAt: reportTime(time.Now()),
+		Metadata:    vars,
+		Clusters:    clusters,
+		Summary:     countSeverities(rows, func(r AggBlock) string { return r.Severity }),
 	}
-	
-	let debounceTimer;
-	function onSearchDebounced(inp) {
-	  clearTimeout(debounceTimer);
-	  debounceTimer = setTimeout(() => onSearch(inp), 150);
+	if !summaryOnly {
+		if rows == nil {
+			rows = []AggBlock{}
+		}
+		report.Results = &rows
 	}
-	
-	function sortBy(key) {
-	  if (state.sortKey === key) state.sortDir = state.sortDir === "asc" ? "desc" : "asc";
-	  else { state.sortKey = key; state.sortDir = "asc"; }
-	  updateAndRender();
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal agg json: %w", err)
 	}
-	
-	function filterData() {
-	  const needle = state.search.toLowerCase();
-	  return AGG.filter(r => {
-		if (!state.filterSev.has(r.Severity)) return false;
-		if (!state.filterClusters.has(r.Cluster)) return false;
-		if (!needle) return true;
-		const hay = (r.Cluster + " " + r.Severity + " " + r.Check + " " + r.Detail).toLowerCase();
-		return hay.includes(needle);
-	  });
+	if err := writeFileAtomic(fs, path, b); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
 	}
-	
-	function sortData(rows) {
-	  const k = state.sortKey, dir = state.sortDir;
-	  const mul = dir === "asc" ? 1 : -1;
-	  rows.sort((a,b) => {
-		let av = a[k], bv = b[k];
-		if (k === "Severity") { av = sevRank[av] || 99; bv = sevRank[bv] || 99; }
-		return (av > bv ? 1 : av < bv ? -1 : 0) * mul;
-	  });
-	  return rows;
+	log.Info().Str("file", path).Int("rows", len(rows)).Int("clusters", len(clusters)).Msg("aggregated JSON generated")
+	return nil
+}
+
+// writeCombined writes combined.csv and combined.json with every cluster's
+// results, for --combined-output.
+func writeCombined(fs FS, outDir string, rows []AggBlock, columns []string) error {
+	if err := fs.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", outDir, err)
 	}
-	
-	function updateCounts(rows) {
-	  const total = rows.length;
-	  const cnt = { FAIL:0, WARN:0, ERR:0, INFO:0 };
-	  rows.forEach(r => { if (cnt[r.Severity] !== undefined) cnt[r.Severity]++; });
-	
-	  document.getElementById("countTotal").textContent = total;
-	  document.getElementById("countFail").textContent = cnt.FAIL;
-	  document.getElementById("countWarn").textContent = cnt.WARN;
-	  document.getElementById("countErr").textContent  = cnt.ERR;
-	  document.getElementById("countInfo").textContent = cnt.INFO;
-	
-	  const pct = {};
-	  Object.keys(cnt).forEach(k => pct[k] = total ? Math.round(cnt[k]*100/total) : 0);
-	  document.getElementById("barFail").style.width = pct.FAIL + "%";
-	  document.getElementById("barWarn").style.width = pct.WARN + "%";
-	  document.getElementById("barErr").style.width  = pct.ERR  + "%";
-	  document.getElementById("barInfo").style.width = pct.INFO + "%";
-	
-	  // Per-cluster summary with links
-	  const pc = document.getElementById("perCluster");
-	  pc.innerHTML = "";
-	  const map = {};
-	  rows.forEach(r => {
-		map[r.Cluster] = map[r.Cluster] || { FAIL:0,WARN:0,ERR:0,INFO:0, total:0 };
-		map[r.Cluster][r.Severity]++; map[r.Cluster].total++;
-	  });
-	  const table = document.createElement("table");
-	  table.innerHTML = '<thead><tr><th>Cluster</th><th>FAIL</th><th>WARN</th><th>ERR</th><th>INFO</th><th>Total</th></tr></thead><tbody></tbody>';
-	  const tb = table.querySelector("tbody");
-	  Object.keys(map).sort().forEach(c => {
-		const m = map[c];
-		const tr = document.createElement("tr");
-		const link = encodeURIComponent(c) + '.log.html';
-		tr.innerHTML =
-		  '<td><a class="mono" href="' + link + '">' + escapeHtml(c) + '</a></td>' +
-		  '<td><span class="severity sev-FAIL">' + m.FAIL + '</span></td>' +
-		  '<td><span class="severity sev-WARN">' + m.WARN + '</span></td>' +
-		  '<td><span class="severity sev-ERR">'  + m.ERR  + '</span></td>' +
-		  '<td><span class="severity sev-INFO">' + m.INFO + '</span></td>' +
-		  '<td>' + m.total + '</td>';
-		tb.appendChild(tr);
-	  });
-	  pc.appendChild(table);
+	csvPath := filepath.Join(outDir, "combined.csv")
+	f, err := createAtomic(fs, csvPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", csvPath, err)
 	}
-	
-	function extractKB(detail) {
-	  const text = detail || "";
-	  const re = /(https?:\/\/[^\s)]+portal\.nutanix\.com\/kb\/\d+|https?:\/\/[^\s)]+)/i;
-	  const m = text.match(re);
-	  return m ? m[0] : "";
+	defer f.Close()
+	w := csv.NewWriter(f)
+	keys := labelKeys(rows)
+	cols := columns
+	if len(cols) == 0 {
+		cols = []string{"cluster", "severity", "check", "detail", "node", "category"}
 	}
-	function kbLabel(url) {
-	  if (!url) return "";
-	  const m = url.match(/\/kb\/(\d+)\b/i);
-	  return m ? ('KB-' + m[1]) : 'KB';
+	header := make([]string, 0, len(cols)+len(keys))
+	for _, c := range cols {
+		header = append(header, columnHeader(c))
 	}
-	
-	function escapeHtml(s) {
-	  return (s || "").toString()
-		.replaceAll("&","&amp;").replaceAll("<","&lt;").replaceAll(">","&gt;")
-		.replaceAll('"',"&quot;").replaceAll("'","&#39;");
+	_ = w.Write(append(header, keys...))
+	for _, r := range rows {
+		b := ParsedBlock{Severity: r.Severity, CheckName: r.Check, DetailRaw: r.Detail, Node: r.Node, Category: r.Category, Remediation: r.Remediation}
+		rec := make([]string, 0, len(cols)+len(keys))
+		for _, c := range cols {
+			rec = append(rec, blockColumn(b, r.Cluster, c))
+		}
+		for _, k := range keys {
+			rec = append(rec, r.Labels[k])
+		}
+		_ = w.Write(rec)
 	}
-	
-	function highlight(text, needle) {
-	  if (!needle) return escapeHtml(text);
-	  const re = new RegExp("(" + needle.replace(/[.*+?^${}()|[\\]\\\\]/g, "\\$&") + ")", "ig");
-	  return escapeHtml(text).replace(re, '<span class="highlight">$1</span>');
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write %s: %w", csvPath, err)
 	}
-	
-	function formatCheckTitle(s) {
- 	 s = s || "";
-  	return s.replace(/^detailed information for\s*/i, "").replace(/:$/, "");
+	if err := f.Commit(); err != nil {
+		return fmt.Errorf("commit %s: %w", csvPath, err)
 	}
 
-	function jsEscape(s) {
-	  return (s || "").toString()
-		.replaceAll("\\", "\\\\").replaceAll("\n", "\\n").replaceAll("\r", " ")
-		.replaceAll("'", "\\'").replaceAll("\"", "\\\"");
+	if rows == nil {
+		rows = []AggBlock{}
 	}
-	
-	async function copyText(text) {
-	  try { await navigator.clipboard.writeText(text); }
-	  catch {
-		const ta = document.createElement("textarea");
-		ta.value = text; document.body.appendChild(ta);
-		ta.select(); document.execCommand("copy");
-		document.body.removeChild(ta);
-	  }
+	b, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal combined json: %w", err)
 	}
-	
-	function renderTable(rows) {
-	  const tbody = document.getElementById("tbody");
-	  tbody.innerHTML = "";
-	  const needle = state.search;
-	  const frag = document.createDocumentFragment();
-	  rows.forEach((r, idx) => {
-		const tr = document.createElement("tr");
-		tr.setAttribute("tabindex", "0");
-		tr.dataset.index = idx.toString();
-	
-		const detailEsc = (r.Detail || "").replaceAll("\\n","<br>");
-	
-		const kb = extractKB(r.Detail);
-		const kbCell = kb ? ('<a href="' + kb + '" target="_blank" rel="noopener">' + kbLabel(kb) + '</a>') : '';
-		const clusterUrl = 'https://' + encodeURIComponent(r.Cluster) + ':9440';
-		const rowText = (r.Cluster + " " + r.Severity + " " + r.Check + " " + (r.Detail || "")).trim();
-		const actHTML =
-		  '<div class="actions">' +
-		  '<button onclick="copyText(\'' + jsEscape(rowText) + '\')">Copy row</button>' +
-		  '<button onclick="copyText(\'' + jsEscape(r.Detail || "") + '\')">Copy detail</button>' +
-		  '</div>';
-		const checkTitle = formatCheckTitle(r.Check || "");
-		tr.innerHTML =
-		  '<td class="col-cluster"><small class="mono"><a href="' + clusterUrl + '" target="_blank" rel="noopener">' + highlight(r.Cluster, needle) + '</a></small></td>' +
-		  '<td class="col-sev"><span class="severity sev-' + r.Severity + '">' + r.Severity + '</span></td>' +
-		  '<td class="col-title"><small class="mono">' + highlight(checkTitle, needle) + '</small></td>' +
-		  '<td class="col-kb">' + kbCell + '</td>' +
-		  '<td class="col-detail"><div class="detail-full">' + highlight(detailEsc, needle) + '</div></td>' +
-		  '<td class="col-actions">' + actHTML + '</td>';
-	
-		tr.addEventListener("focus", () => selectRow(tr));
-		frag.appendChild(tr);
-	  });
-	  tbody.appendChild(frag);
+	jsonPath := filepath.Join(outDir, "combined.json")
+	if err := writeFileAtomic(fs, jsonPath, b); err != nil {
+		return fmt.Errorf("write %s: %w", jsonPath, err)
 	}
-	
-	function selectRow(tr) {
-	  const tbody = document.getElementById("tbody");
-	  Array.from(tbody.querySelectorAll("tr.selected")).forEach(x => x.classList.remove("selected"));
-	  tr.classList.add("selected");
-	  selIndex = parseInt(tr.dataset.index || "-1", 10);
+	log.Info().Str("csv", csvPath).Str("json", jsonPath).Int("rows", len(rows)).Msg("combined outputs generated")
+	return nil
+}
+
+/************** History **************/
+
+// HistoryRecord is one line of --history-file: a run's severity counts per
+// successful cluster plus the clusters that failed.
+type HistoryRecord struct {
+	Time   time.Time                 `json:"time"`
+	RunID  string                    `json:"run_id,omitempty"`
+	Counts map[string]map[string]int `json:"counts"` // cluster -> severity -> rows
+	Failed []string                  `json:"failed_clusters,omitempty"`
+}
+
+const (
+	historyLockWait  = 10 * time.Second
+	historyLockStale = 30 * time.Second
+)
+
+func historyRecord(runID string, now time.Time, summaries []ClusterSummary, rows []AggBlock) HistoryRecord {
+	rec := HistoryRecord{Time: now.UTC(), RunID: runID, Counts: map[string]map[string]int{}}
+	for _, s := range summaries {
+		if s.Status != "ok" {
+			rec.Failed = append(rec.Failed, s.Cluster)
+			continue
+		}
+		rec.Counts[s.Cluster] = map[string]int{}
 	}
-	
-	function focusRow(i) {
-	  const rows = document.querySelectorAll("#tbody tr");
-	  if (!rows.length) return;
-	  if (i < 0) i = 0;
-	  if (i >= rows.length) i = rows.length - 1;
-	  selIndex = i;
-	  const tr = rows[i];
-	  tr.focus({preventScroll:false});
-	  selectRow(tr);
-	  tr.scrollIntoView({block:"nearest", inline:"nearest"});
+	for _, r := range rows {
+		if c, ok := rec.Counts[r.Cluster]; ok {
+			c[r.Severity]++
+		}
 	}
-	
-	function onKey(e) {
-	  const k = e.key;
-	  if (k === "/") {
-		e.preventDefault();
-		const sb = document.getElementById("searchBox");
-		sb.focus(); sb.select();
-		return;
-	  }
-	  if (k === "Escape") {
-		if (state.search) {
-		  state.search = ""; document.getElementById("searchBox").value = "";
-		  updateAndRender();
+	return rec
+}
+
+// lockFile takes an exclusive lock by creating <path>.lock with O_EXCL, which
+// behaves the same on every OS. A lock older than historyLockStale is left
+// over from a crashed run and is broken.
+func lockFile(path string, wait time.Duration) (unlock func(), err error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { _ = os.Remove(lock) }, nil
 		}
-		return;
-	  }
-	  if (k === "ArrowDown") { e.preventDefault(); focusRow(selIndex + 1); return; }
-	  if (k === "ArrowUp")   { e.preventDefault(); focusRow(selIndex - 1); return; }
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("create %s: %w", lock, err)
+		}
+		if st, serr := os.Stat(lock); serr == nil && time.Since(st.ModTime()) > historyLockStale {
+			log.Warn().Str("lock", lock).Msg("removing stale lock")
+			_ = os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for %s", wait, lock)
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
-	
-	function updateAndRender() {
-	  let rows = filterData();
-	  // Update visible counters
-	  const total = rows.length;
-	  const cnt = { FAIL:0, WARN:0, ERR:0, INFO:0 };
-	  rows.forEach(r => { if (cnt[r.Severity] !== undefined) cnt[r.Severity]++; });
-	  document.getElementById("countTotal").textContent = total;
-	  document.getElementById("countFail").textContent = cnt.FAIL;
-	  document.getElementById("countWarn").textContent = cnt.WARN;
-	  document.getElementById("countErr").textContent  = cnt.ERR;
-	  document.getElementById("countInfo").textContent = cnt.INFO;
-	  const pct = {};
-	  Object.keys(cnt).forEach(k => pct[k] = total ? Math.round(cnt[k]*100/total) : 0);
-	  document.getElementById("barFail").style.width = pct.FAIL + "%";
-	  document.getElementById("barWarn").style.width = pct.WARN + "%";
-	  document.getElementById("barErr").style.width  = pct.ERR  + "%";
-	  document.getElementById("barInfo").style.width = pct.INFO + "%";
-	
-	  // Per-cluster summary and table
-	  updateCounts(rows);
-	  rows = sortData(rows.slice());
-	  renderTable(rows);
+}
+
+// appendHistory appends rec to the JSON Lines file at path under lockFile.
+// When the file would grow past maxSize bytes it is first rotated to
+// <path>.1, replacing any earlier rotation.
+func appendHistory(path string, rec HistoryRecord, maxSize int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
 	}
-	
-	function downloadCSV() {
-		const rows = filterData();
-		const headers = ["Cluster","Severity","NCC Alert Title","Detail"];
-		const lines = [headers.join(",")];
-		rows.forEach(r => {
-		  const title = formatCheckTitle(r.Check || "");
-		  const row = [r.Cluster, r.Severity, title, r.Detail || ""].map(v => {
-		    const s = (v ?? "").toString().replaceAll('"','""').replaceAll("\r"," ").replaceAll("\n","\\n");
-		    return '"' + s + '"';
-		  }).join(",");
-		  lines.push(row);
-		});
-	  const blob = new Blob([lines.join("\n")], {type: "text/csv;charset=utf-8;"});
-	  triggerDownload(blob, "aggregated_filtered.csv");
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal history: %w", err)
 	}
-	
-	function downloadJSON() {
-	  const rows = filterData();
-	  const blob = new Blob([JSON.stringify(rows, null, 2)], {type: "application/json;charset=utf-8;"});
-	  triggerDownload(blob, "aggregated_filtered.json");
+	b = append(b, '\n')
+
+	unlock, err := lockFile(path, historyLockWait)
+	if err != nil {
+		return err
 	}
-	
-	function triggerDownload(blob, name) {
-	  const a = document.createElement("a");
-	  a.href = URL.createObjectURL(blob);
-	  a.download = name;
-	  document.body.appendChild(a);
-	  a.click();
-	  document.body.removeChild(a);
+	defer unlock()
+
+	if st, err := os.Stat(path); err == nil && maxSize > 0 && st.Size()+int64(len(b)) > maxSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("rotate %s: %w", path, err)
+		}
+		log.Info().Str("file", path).Int64("size", st.Size()).Msg("history rotated")
 	}
-	</script>
-	</head>
-	<body onload="init()">
-	<div class="container">
-	  <div class="header">
-		<div class="title">
-		  <h1>NCC Aggregated Report</h1>
-		  <div class="sub">Generated at {{.GeneratedAt}}</div>
-		</div>
-        <!--
-        <div class="legend">
-          <span class="badge"><span class="dot fail"></span> FAIL</span>
-          <span class="badge"><span class="dot warn"></span> WARN</span>
-          <span class="badge"><span class="dot err"></span> ERR</span>
-          <span class="badge"><span class="dot info"></span> INFO</span>
-        </div>
-        -->
-	  </div>
-	
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", path, err)
+	}
+	log.Info().Str("file", path).Int("clusters", len(rec.Counts)).Int("failed", len(rec.Failed)).Msg("history appended")
+	return nil
+}
+
+// readHistory loads <path>.1 (if rotated) and path, oldest first. Lines that
+// don't parse are skipped and counted.
+func readHistory(path string) (recs []HistoryRecord, skipped int, err error) {
+	found := false
+	for _, p := range []string{path + ".1", path} {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("read %s: %w", p, err)
+		}
+		found = true
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var rec HistoryRecord
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				skipped++
+				continue
+			}
+			recs = append(recs, rec)
+		}
+	}
+	if !found {
+		return nil, 0, fmt.Errorf("history file %s not found", path)
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].Time.Before(recs[j].Time) })
+	return recs, skipped, nil
+}
+
+// trendPoint is one run of one cluster; Failed means the cluster's run
+// failed and there is no count.
+type trendPoint struct {
+	Time   time.Time
+	Fail   int
+	Failed bool
+}
+
+// failTrend returns the FAIL count per cluster over time, limited to the
+// given clusters when any are given.
+func failTrend(recs []HistoryRecord, only []string) map[string][]trendPoint {
+	out := map[string][]trendPoint{}
+	want := func(c string) bool { return len(only) == 0 || slices.Contains(only, c) }
+	for _, rec := range recs {
+		for c, counts := range rec.Counts {
+			if want(c) {
+				out[c] = append(out[c], trendPoint{Time: rec.Time, Fail: counts["FAIL"]})
+			}
+		}
+		for _, c := range rec.Failed {
+			if want(c) {
+				out[c] = append(out[c], trendPoint{Time: rec.Time, Failed: true})
+			}
+		}
+	}
+	return out
+}
+
+func writeTrendText(w io.Writer, trend map[string][]trendPoint) {
+	const width = 40
+	maxFail := 0
+	for _, pts := range trend {
+		for _, p := range pts {
+			maxFail = max(maxFail, p.Fail)
+		}
+	}
+	for _, c := range slices.Sorted(maps.Keys(trend)) {
+		fmt.Fprintln(w, c)
+		for _, p := range trend[c] {
+			ts := p.Time.Local().Format("2006-01-02 15:04")
+			if p.Failed {
+				fmt.Fprintf(w, "  %s  run failed\n", ts)
+				continue
+			}
+			bar := 0
+			if maxFail > 0 {
+				bar = (p.Fail*width + maxFail - 1) / maxFail
+			}
+			fmt.Fprintf(w, "  %s  FAIL %4d %s\n", ts, p.Fail, strings.Repeat("#", bar))
+		}
+	}
+}
+
+// writeTrendHTML writes a self-contained page with one SVG line per cluster.
+// Failed runs are drawn as hollow markers on the axis.
+func writeTrendHTML(fs FS, path string, trend map[string][]trendPoint) error {
+	const w, h, pad = 900, 360, 40
+	var tmin, tmax time.Time
+	maxFail := 1
+	for _, pts := range trend {
+		for _, p := range pts {
+			if tmin.IsZero() || p.Time.Before(tmin) {
+				tmin = p.Time
+			}
+			if p.Time.After(tmax) {
+				tmax = p.Time
+			}
+			maxFail = max(maxFail, p.Fail)
+		}
+	}
+	span := tmax.Sub(tmin).Seconds()
+	x := func(t time.Time) float64 {
+		if span == 0 {
+			return pad + float64(w-2*pad)/2
+		}
+		return pad + t.Sub(tmin).Seconds()/span*float64(w-2*pad)
+	}
+	y := func(n int) float64 { return float64(h-pad) - float64(n)/float64(maxFail)*float64(h-2*pad) }
+	palette := []string{"#ef4444", "#3b82f6", "#f59e0b", "#10b981", "#a855f7", "#ec4899", "#14b8a6", "#eab308"}
+
+	var b strings.Builder
+	b.WriteString("<html><head><meta charset=\"utf-8\"><title>NCC FAIL trend</title></head>\n")
+	b.WriteString("<body style=\"font-family:sans-serif;background:#0f172a;color:#e5e7eb\">\n<h2>FAIL checks per cluster</h2>\n")
+	fmt.Fprintf(&b, "<svg width=\"%d\" height=\"%d\" style=\"background:#111827\">\n", w, h)
+	fmt.Fprintf(&b, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#9ca3af\"/>\n", pad, h-pad, w-pad, h-pad)
+	fmt.Fprintf(&b, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#9ca3af\"/>\n", pad, pad, pad, h-pad)
+	fmt.Fprintf(&b, "<text x=\"4\" y=\"%d\" fill=\"#9ca3af\" font-size=\"12\">%d</text>\n", pad+4, maxFail)
+	fmt.Fprintf(&b, "<text x=\"%d\" y=\"%d\" fill=\"#9ca3af\" font-size=\"12\">%s</text>\n", pad, h-pad+16, tmin.In(reportLocation).Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "<text x=\"%d\" y=\"%d\" fill=\"#9ca3af\" font-size=\"12\" text-anchor=\"end\">%s</text>\n", w-pad, h-pad+16, tmax.In(reportLocation).Format("2006-01-02 15:04"))
+	var legend strings.Builder
+	for i, c := range slices.Sorted(maps.Keys(trend)) {
+		color := palette[i%len(palette)]
+		var pts []string
+		for _, p := range trend[c] {
+			if p.Failed {
+				fmt.Fprintf(&b, "<circle cx=\"%.1f\" cy=\"%d\" r=\"4\" fill=\"none\" stroke=\"%s\"><title>%s %s: run failed</title></circle>\n",
+					x(p.Time), h-pad, color, html.EscapeString(c), reportTime(p.Time))
+				continue
+			}
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Fail)))
+			fmt.Fprintf(&b, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"3\" fill=\"%s\"><title>%s %s: %d FAIL</title></circle>\n",
+				x(p.Time), y(p.Fail), color, html.EscapeString(c), reportTime(p.Time), p.Fail)
+		}
+		if len(pts) > 1 {
+			fmt.Fprintf(&b, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"%s\"/>\n", color, strings.Join(pts, " "))
+		}
+		fmt.Fprintf(&legend, "<span style=\"color:%s;margin-right:16px\">&#9632; %s</span>\n", color, html.EscapeString(c))
+	}
+	b.WriteString("</svg>\n<p>")
+	b.WriteString(legend.String())
+	b.WriteString("</p>\n</body></html>\n")
+
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", filepath.Dir(path), err)
+	}
+	if err := writeFileAtomic(fs, path, []byte(b.String())); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func newTrendCmd() *cobra.Command {
+	var htmlOut string
+	var only []string
+	cmd := &cobra.Command{
+		Use:   "trend [history.jsonl]",
+		Short: "Show FAIL counts per cluster over time from a --history-file",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := viper.GetString("history-file")
+			if len(args) == 1 {
+				path = args[0]
+			}
+			if path == "" {
+				return NewNCCError(ErrorTypeConfig, "no history file: pass a path or set --history-file / NCC_HISTORY_FILE", nil)
+			}
+			recs, skipped, err := readHistory(path)
+			if err != nil {
+				return err
+			}
+			if skipped > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "skipped %d unreadable line(s) in %s\n", skipped, path)
+			}
+			trend := failTrend(recs, only)
+			if len(trend) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "no matching history")
+				return nil
+			}
+			if htmlOut != "" {
+				// report-time-format / report-timezone from the config file or env
+				if err := setReportTime(viper.GetString("report-time-format"), viper.GetString("report-timezone")); err != nil {
+					return err
+				}
+				if err := writeTrendHTML(OSFS{}, htmlOut, trend); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "trend chart written to %s\n", htmlOut)
+				return nil
+			}
+			writeTrendText(cmd.OutOrStdout(), trend)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&htmlOut, "html", "", "Write an HTML chart to this path instead of printing")
+	cmd.Flags().StringSliceVar(&only, "cluster", nil, "Only show these clusters (repeatable or comma-separated)")
+	return cmd
+}
+
+/************** Manifest **************/
+
+// ManifestEntry is one produced file in manifest.json. Path is relative to
+// the manifest's directory.
+type ManifestEntry struct {
+	Path        string `json:"path"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	GeneratedAt string `json:"generated_at"`
+}
+
+type Manifest struct {
+	Tool        string          `json:"tool"`
+	Version     string          `json:"version"`
+	GeneratedAt string          `json:"generated_at"`
+	Params      map[string]any  `json:"params"`
+	Files       []ManifestEntry `json:"files"`
+}
+
+// reportFiles lists the outputs of this run that exist in the filtered dir:
+// per-cluster filtered logs and reports for clusters that finished, plus the
+// aggregated and combined files. The filtered logs are written before
+// --redact-pattern is applied, so they are left out when redactions are
+// configured and the list may be shared (archive, manifest, JSON result).
+func reportFiles(fs FS, cfg Config, clusters []string) []string {
+	var candidates []string
+	for _, c := range clusters {
+		base := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(c)+".log")
+		if len(cfg.Redactions) == 0 {
+			candidates = append(candidates, base)
+		}
+		for _, f := range cfg.OutputFormats {
+			candidates = append(candidates, base+"."+f)
+		}
+	}
+	for _, name := range []string{cfg.AggregatedFilename, aggregatedJSONName(cfg.AggregatedFilename), "combined.csv", "combined.json"} {
+		candidates = append(candidates, filepath.Join(cfg.OutputDirFiltered, name))
+	}
+	var out []string
+	for _, p := range candidates {
+		if st, err := fs.Stat(p); err == nil && !st.IsDir() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// manifestParams is the run configuration recorded in the manifest, with
+// credentials left out.
+func manifestParams(cfg Config) map[string]any {
+	p := map[string]any{
+		"clusters":   cfg.Clusters,
+		"username":   cfg.Username,
+		"outputs":    cfg.OutputFormats,
+		"timeout":    cfg.Timeout.String(),
+		"logs_dir":   cfg.OutputDirLogs,
+		"report_dir": cfg.OutputDirFiltered,
+	}
+	if cfg.Password != "" {
+		p["password"] = "[redacted]"
+	}
+	if !cfg.Since.IsZero() {
+		p["since"] = cfg.Since.Format(time.RFC3339)
+	}
+	if len(cfg.PrismCentrals) > 0 {
+		p["prism_central"] = strings.Join(cfg.PrismCentrals, ",")
+	}
+	return p
+}
+
+func fileDigest(fs FS, path string) (string, int64, error) {
+	b, err := fs.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), int64(len(b)), nil
+}
+
+// buildManifest hashes files (read through fs, so MemFS works too), with
+// paths relative to dir.
+func buildManifest(fs FS, dir string, files []string, cfg Config) (Manifest, error) {
+	now := time.Now()
+	m := Manifest{
+		Tool:        "ncc-orchestrator",
+		Version:     Version,
+		GeneratedAt: now.Format(time.RFC3339),
+		Params:      manifestParams(cfg),
+		Files:       []ManifestEntry{},
+	}
+	for _, p := range files {
+		digest, size, err := fileDigest(fs, p)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("hash %s: %w", p, err)
+		}
+		genAt := now
+		if st, err := fs.Stat(p); err == nil && !st.ModTime().IsZero() {
+			genAt = st.ModTime()
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			rel = p
+		}
+		m.Files = append(m.Files, ManifestEntry{Path: filepath.ToSlash(rel), SHA256: digest, Size: size, GeneratedAt: genAt.Format(time.RFC3339)})
+	}
+	return m, nil
+}
+
+// writeManifest writes the manifest of files as manifest.json into dir.
+func writeManifest(fs FS, dir string, files []string, cfg Config) error {
+	m, err := buildManifest(fs, dir, files, cfg)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := writeFileAtomic(fs, path, b); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	log.Info().Str("file", path).Int("files", len(m.Files)).Msg("manifest written")
+	return nil
+}
+
+// verifyManifest re-hashes every file listed in the manifest at path and
+// returns one line per missing or altered file.
+func verifyManifest(fs FS, path string) ([]string, error) {
+	b, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, NewNCCError(ErrorTypeValidation, "invalid manifest "+path, err)
+	}
+	dir := filepath.Dir(path)
+	var problems []string
+	for _, e := range m.Files {
+		p := filepath.Join(dir, filepath.FromSlash(e.Path))
+		digest, size, err := fileDigest(fs, p)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", e.Path, err))
+		case digest != e.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: sha256 mismatch (size %d, manifest %d)", e.Path, size, e.Size))
+		}
+	}
+	return problems, nil
+}
+
+/************** Archive **************/
+
+// archiveKind returns "zip" or "tar.gz" from the --archive extension.
+func archiveKind(path string) (string, bool) {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", true
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", true
+	}
+	return "", false
+}
+
+// writeArchive packages files (read through fs) into a zip or tar.gz at
+// path, named relative to dir, plus a manifest.json describing them. The
+// originals are left in place.
+func writeArchive(fs FS, dir, path string, files []string, cfg Config) error {
+	kind, ok := archiveKind(path)
+	if !ok {
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("unsupported archive %q (want .zip, .tar.gz or .tgz)", path), nil)
+	}
+	m, err := buildManifest(fs, dir, files, cfg)
+	if err != nil {
+		return err
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	if d := filepath.Dir(path); d != "." {
+		if err := fs.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", d, err)
+		}
+	}
+	f, err := createAtomic(fs, path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	now := time.Now()
+	// add writes one archive member; the manifest goes last.
+	var add func(name string, data []byte) error
+	var finish func() error
+	switch kind {
+	case "zip":
+		zw := zip.NewWriter(f)
+		add = func(name string, data []byte) error {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}
+		finish = zw.Close
+	default:
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		add = func(name string, data []byte) error {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}); err != nil {
+				return err
+			}
+			_, err := tw.Write(data)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	}
+	for i, p := range files {
+		data, err := fs.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", p, err)
+		}
+		if err := add(m.Files[i].Path, data); err != nil {
+			return fmt.Errorf("archive %s: %w", p, err)
+		}
+	}
+	if err := add("manifest.json", manifest); err != nil {
+		return fmt.Errorf("archive manifest: %w", err)
+	}
+	if err := finish(); err != nil {
+		return fmt.Errorf("finish %s: %w", path, err)
+	}
+	if err := f.Commit(); err != nil {
+		return fmt.Errorf("commit %s: %w", path, err)
+	}
+	log.Info().Str("file", path).Str("format", kind).Int("files", len(files)).Msg("archive written")
+	return nil
+}
+
+func newListChecksCmd() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "list-checks <cluster>",
+		Short: "List the NCC checks a cluster knows about (names for selecting a subset)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := bindConfig()
+			if err != nil {
+				return err
+			}
+			if err := setupFileLogger(cfg.LogFile, cfg.LogRotation, parseLogLevel(cfg.LogLevel), nil, nil); err != nil {
+				return fmt.Errorf("setup logger: %w", err)
+			}
+			cfg.RunID = newRunID()
+			warnInsecure(cfg, os.Stderr)
+			host, port, err := normalizeCluster(args[0])
+			if err != nil {
+				return err
+			}
+			cluster := clusterKey(host, port)
+			cfg.Clusters = []string{cluster} // keyring entry matches a single-cluster run
+			cfg.Password, err = promptPasswordIfEmpty(cfg, cmd.Flags().Changed("password"))
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			defer cancel()
+			checks, err := NewNCCClient(cluster, cfg.Username, cfg.Password, NewHTTPClient(cfg), cfg).ListChecks(ctx)
+			if err != nil {
+				return err
+			}
+			sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				if checks == nil {
+					checks = []HealthCheck{}
+				}
+				b, err := json.MarshalIndent(checks, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(out, string(b))
+				return nil
+			}
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tCATEGORY\tTYPE\tID")
+			for _, c := range checks {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, strings.Join(c.CategoryTypes, ","), c.CheckType, c.ID)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON instead of a table")
+	return cmd
+}
+
+// parseDetailWidth is how many runes of a detail `parse` prints per row.
+const parseDetailWidth = 80
+
+// oneLine collapses a multi-line detail to one line of at most width runes
+// (0 for no limit).
+func oneLine(s string, width int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); width > 0 && len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+	return s
+}
+
+func newParseCmd() *cobra.Command {
+	var (
+		asJSON     bool
+		width      int
+		start, end string
+	)
+	cmd := &cobra.Command{
+		Use:   "parse <file>",
+		Short: "Print the blocks the parser extracts from a saved NCC summary (- reads stdin)",
+		Long: "Runs the summary parser on a captured NCC summary and prints the blocks it finds, with\n" +
+			"per-severity counts. Nothing is rendered and no cluster is contacted, so it is a quick\n" +
+			"way to see how a log parses.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setBlockPatterns(start, end); err != nil {
+				return err
+			}
+			var (
+				data []byte
+				err  error
+			)
+			if args[0] == "-" {
+				data, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return NewNCCError(ErrorTypeConfig, fmt.Sprintf("read %s", args[0]), err)
+			}
+			blocks, err := ParseSummary(string(data))
+			if err != nil {
+				return err
+			}
+			counts := sevCounts(blocks)
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				type parsedJSON struct {
+					Severity    string `json:"severity"`
+					Check       string `json:"check"`
+					CheckID     string `json:"check_id,omitempty"`
+					Detail      string `json:"detail"`
+					Node        string `json:"node,omitempty"`
+					Category    string `json:"category,omitempty"`
+					Remediation string `json:"remediation,omitempty"`
+				}
+				res := struct {
+					File   string         `json:"file"`
+					Total  int            `json:"total"`
+					Counts map[string]int `json:"counts"`
+					Blocks []parsedJSON   `json:"blocks"`
+				}{File: args[0], Total: len(blocks), Counts: map[string]int{}, Blocks: []parsedJSON{}}
+				for _, c := range counts {
+					res.Counts[c.Severity] = c.Count
+				}
+				for _, b := range blocks {
+					res.Blocks = append(res.Blocks, parsedJSON{b.Severity, b.CheckName, b.CheckID, b.DetailRaw, b.Node, b.Category, b.Remediation})
+				}
+				b, err := json.MarshalIndent(res, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(out, string(b))
+				return nil
+			}
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "SEVERITY\tCHECK\tNODE\tDETAIL")
+			for _, b := range blocks {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", b.Severity, b.CheckName, b.Node, oneLine(b.DetailRaw, width))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			parts := make([]string, 0, len(counts))
+			for _, c := range counts {
+				parts = append(parts, fmt.Sprintf("%s=%d", c.Severity, c.Count))
+			}
+			fmt.Fprintf(out, "\n%d block(s): %s\n", len(blocks), strings.Join(parts, " "))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON (full details) instead of a table")
+	cmd.Flags().IntVar(&width, "width", parseDetailWidth, "Truncate details in the table to this many characters (0 for no limit)")
+	cmd.Flags().StringVar(&start, "block-start-regex", "", "Override regex matching the start of a summary block")
+	cmd.Flags().StringVar(&end, "block-end-regex", "", "Override regex matching the end of a summary block")
+	return cmd
+}
+
+func newVerifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [manifest.json]",
+		Short: "Check report files against a manifest written by --write-manifest",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := filepath.Join(viper.GetString("output-dir-filtered"), "manifest.json")
+			if len(args) == 1 {
+				path = args[0]
+			}
+			problems, err := verifyManifest(OSFS{}, path)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				fmt.Fprintln(cmd.OutOrStdout(), p)
+			}
+			if len(problems) > 0 {
+				return NewNCCError(ErrorTypeValidation, fmt.Sprintf("%d file(s) failed verification against %s", len(problems), path), nil)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "all files match %s\n", path)
+			return nil
+		},
+	}
+}
+
+func writeAggregatedHTMLSingle(fs FS, outDir, filename string, rows []AggBlock, perCluster []struct{ Cluster, HTML, CSV string }, status []ClusterSummary, maxPerSev int, vars map[string]string, summaryOnly bool, columns []string) error {
+	if err := fs.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", outDir, err)
+	}
+	path := filepath.Join(outDir, filename)
+	abs, _ := filepath.Abs(path)
+	const tmpl = `
+	<html>
+	<head>
+	<meta charset="utf-8">
+	<title>NCC Aggregated Report</title>
+	<style>
+	:root {
+	  --bg: #0f172a;
+	  --card: #111827;
+	  --text: #e5e7eb;
+	  --muted: #9ca3af;
+	  --accent: #2563eb;
+	  --row1: #0b1224;
+	  --row2: #0e1630;
+	  --border: #1f2937;
+	  --fail: {{sevColor "FAIL" "#ef4444"}};
+	  --warn: {{sevColor "WARN" "#f59e0b"}};
+	  --info: {{sevColor "INFO" "#3b82f6"}};
+	  --details: #aaa;
+	  --err:  {{sevColor "ERR" "#94a3b8"}};
+	}
+	* { box-sizing: border-box; }
+	html, body { height: 100%; }
+	body {
+	  margin: 0;
+	  font-family: ui-sans-serif, system-ui, -apple-system, Segoe UI, Roboto, Arial, sans-serif;
+	  background: linear-gradient(180deg,#0b1224,#0e1630);
+	  color: var(--text);
+	}
+	.container { max-width: 1200px; margin: 24px auto; padding: 0 16px; }
+	.header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 16px; }
+	.title h1 { margin: 0; font-size: 22px; font-weight: 700; }
+	.title .sub { color: var(--muted); font-size: 12px; }
+	.controls { display: flex; flex-wrap: wrap; gap: 12px; align-items: center; margin: 12px 0 18px 0; }
+	.control { background: #0d152b; border: 1px solid var(--border); border-radius: 10px; padding: 10px 12px; display: flex; gap: 8px; align-items: center; }
+	.control label { font-size: 12px; color: var(--muted); margin-right: 6px; }
+	input[type="text"] { background: #0a1123; border: 1px solid var(--border); color: var(--text); padding: 8px 10px; border-radius: 8px; outline: none; width: 280px; }
+	select, button { background: #0a1123; border: 1px solid var(--border); color: var(--text); padding: 8px 10px; border-radius: 8px; outline: none; }
+	button:hover { border-color: var(--accent); cursor: pointer; }
+	.badge { display:inline-flex; align-items:center; gap:6px; padding: 6px 10px; border-radius: 999px; background:#0a1123; border:1px solid var(--border); user-select:none; }
+	.badge .dot { width: 8px; height: 8px; border-radius: 999px; display:inline-block; }
+	.dot.fail{ background: var(--fail); } .dot.warn{ background: var(--warn); }
+	.dot.info{ background: var(--info); } .dot.err{ background: var(--err); }
+	.legend { display:flex; gap:8px; flex-wrap: wrap; }
+	.card { background: #0d152b; border: 1px solid var(--border); border-radius: 12px; padding: 12px; }
+	
+	/* Summary counters visible */
+	.summary { display:grid; grid-template-columns: repeat(5, 1fr); gap:12px; margin: 16px 0; }
+	.sum-item { background: #0a1123; border: 1px solid var(--border); border-radius: 10px; padding: 10px; }
+	.sum-item .label { font-size: 12px; color: var(--muted); }
+	.sum-item .count { font-size: 18px; font-weight: 700; margin-top: 6px; }
+	.progress { height: 6px; border-radius: 999px; background: #0d152b; margin-top: 8px; overflow: hidden; border:1px solid var(--border); }
+	.progress > span { display:block; height:100%; }
+	.progress.fail > span { background: var(--fail); } .progress.warn > span { background: var(--warn); }
+	.progress.err  > span { background: var(--err); }  .progress.info > span { background: var(--info); }
+	
+	/* Scroll container for wide tables */
+	.scroll { overflow-x: auto; overflow-y: hidden; }
+	.scroll::-webkit-scrollbar { height: 10px; }
+	.scroll::-webkit-scrollbar-thumb { background: #22304d; border-radius: 8px; }
+	.scroll::-webkit-scrollbar-track { background: #0a1123; }
+	
+	/* Table */
+	table { width: 100%; border-collapse: collapse; table-layout: fixed; }
+	thead th {
+	  position: sticky; top: 0; z-index: 1;
+	  background: #0d152b; border-bottom: 1px solid var(--border);
+	  padding: 10px; text-align: left; font-size: 12px; color: var(--muted);
+	}
+	tbody td { padding: 10px; border-bottom: 1px solid var(--border); vertical-align: top; }
+	thead th, tbody td { overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+	
+	tbody tr:nth-child(odd) { background: var(--row1); }
+	tbody tr:nth-child(even){ background: var(--row2); }
+	
+	td .severity { padding: 2px 8px; border-radius: 999px; font-size: 12px; }
+	.sev-FAIL { background: #2b0d0d; color: var(--fail); border: 1px solid #4c1d1d; }
+	.sev-WARN { background: #2b1f0d; color: var(--warn); border: 1px solid #4a3112; }
+	.sev-INFO { background: #0c1f35; color: var(--info); border: 1px solid #173e6d; }
+	.sev-ERR  { background: #1b2130; color: var(--err);  border: 1px solid #2c354a; }
+	
+	small.mono { color: var(--muted); font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; }
+	.highlight { background: #3b82f655; }
+	
+	/* Column sizing */
+	th.col-cluster, td.col-cluster   { width: 140px; }
+	th.col-sev,     td.col-sev       { width: 96px; }
+	th.col-title,   td.col-title     { width: 240px; }
+	th.col-kb,      td.col-kb        { width: 110px; }
+	th.col-node,    td.col-node      { width: 140px; }
+	th.col-category, td.col-category { width: 160px; }
+	th.col-detail,  td.col-detail    { width: 640px; }
+	th.col-actions, td.col-actions   { width: 220px; }
+	
+    td.col-detail { white-space: normal; overflow: visible; }
+    .detail-full { color: var(--details); font-size: 13px; line-height: 1.35; }
+	
+	/* Actions */
+	tbody tr.selected { outline: 2px solid var(--accent); outline-offset: -2px; }
+	.actions { white-space: nowrap; display: inline-flex; gap: 6px; flex-wrap: wrap; }
+	.actions button { background:#0a1123; border:1px solid var(--border); color:var(--text); padding:6px 8px; border-radius:8px; }
+	.actions button:hover { border-color: var(--accent); cursor:pointer; }
+	
+	/* Link styling (URLs) */
+	a { color: #93c5fd; text-decoration: none; }
+	a:hover { text-decoration: underline; color: #bfdbfe; }
+	a:visited { color: #a5b4fc; }
+	a[href^="http"]::after {
+	  content: "↗";
+	  font-size: 11px;
+	  margin-left: 4px;
+	  color: #64748b;
+	}
+	  /* Custom checkbox - hide default */
+.control input[type="checkbox"] {
+  position: absolute;
+  opacity: 0;
+  cursor: pointer;
+  height: 0;
+  width: 0;
+}
+
+
+.control span {
+  display: flex;
+  align-items: center;
+  justify-content: center;
+  position: relative;
+  padding-left: 24px;
+  min-height: 16px; /* Match box height */
+  cursor: pointer;
+  color: var(--muted);
+}
+
+
+.control span::before {
+  content: "";
+  position: absolute;
+  top: 50%;
+  left: 0;
+  transform: translateY(-50%); /* Vertically center the box itself */
+  height: 16px;
+  width: 16px;
+  background-color: #0a1123;
+  border: 1px solid var(--border);
+  border-radius: 4px;
+  box-sizing: border-box; /* Ensure border is included in size */
+}
+
+
+.control span::after {
+  content: "";
+  width: 9px;
+  height: 9px;
+  background-color: var(--muted);
+  position: absolute;
+  top: 50%;
+  left: 8px; /* Half of box width (16px / 2 = 8px) for horizontal center */
+  transform: translate(-50%, -50%) scale(0); /* Vertical center with translate */
+  transition: transform 0.2s ease-in-out;
+  border-radius: 2px;
+}
+
+
+.control input[type="checkbox"]:checked ~ span::after {
+  transform: translate(-50%, -50%) scale(1);
+}
+
+
+/* Hover effect on box */
+.control span:hover::before {
+  border-color: var(--accent);
+}
+
+
+/* Focus effect for accessibility */
+.control input[type="checkbox"]:focus + span::before {
+  outline: 2px solid var(--accent);
+}
+
+	table.status td, table.status th { padding: 6px 10px; text-align: left; }
+	tr.st-ok      { background: rgba(16, 185, 129, 0.12); }
+	tr.st-failed  { background: rgba(239, 68, 68, 0.16); }
+	tr.st-aborted { background: rgba(148, 163, 184, 0.16); }
+	.st-badge { font-weight: 600; }
+	tr.st-ok .st-badge      { color: #10b981; }
+	tr.st-failed .st-badge  { color: var(--fail); }
+	tr.st-aborted .st-badge { color: var(--err); }
+
+	</style>
+	<script>
+	// Embedded data
+	const AGG = {{.JSON}};
+	// cluster -> severity -> count over all rows, before any row limit
+	const COUNTS = {{.Counts}};
+	const TRUNCATED = {{.Truncated}};
+	// --summary-only: no rows are embedded, only COUNTS
+	const SUMMARY_ONLY = {{.SummaryOnly}};
+	// cluster -> per-cluster HTML file name
+	const FILES = {{.Files}};
+	// cluster label names, one column each
+	const LABEL_KEYS = {{.LabelKeys}};
+	// table columns in --columns order; "labels" stands for the label columns
+	const COLUMNS = {{.Columns}};
+	// Export CSV columns, as in combined.csv; label columns are appended
+	const EXPORT_COLUMNS = {{.ExportColumns}};
+	function labelOf(r, k) { return (r.Labels || {})[k] || ""; }
+	
+	// State
+	let state = {
+	  sortKey: "", // "" keeps the embedded (--sort-order) order until a header is clicked
+	  sortDir: "asc",
+	  filterSev: new Set(["FAIL","WARN","ERR","INFO"]),
+	  filterClusters: new Set(),
+	  search: ""
+	};
+	
+	const sevRank = {{.SevRank}};
+	let selIndex = -1;
+	
+	function init() {
+	  buildClusterFilter();
+	  updateAndRender();
+	  if (!SUMMARY_ONLY) document.addEventListener("keydown", onKey);
+	}
+	
+	function buildClusterFilter() {
+	  const clusters = Object.keys(COUNTS).sort();
+	  const sel = document.getElementById("clusterSel");
+	  sel.innerHTML = "";
+	  clusters.forEach(c => {
+		const opt = document.createElement("option");
+		opt.value = c; opt.textContent = c;
+		sel.appendChild(opt);
+	  });
+	  state.filterClusters = new Set(clusters); // select all by default
+	  sel.size = Math.min(6, clusters.length);
+	}
+	
+	function setSev(checked, sev) {
+	  if (checked) state.filterSev.add(sev); else state.filterSev.delete(sev);
+	  updateAndRender();
+	}
+	
+	function onClusterChange(sel) {
+	  const chosen = new Set(Array.from(sel.selectedOptions).map(o => o.value));
+	  if (chosen.size === 0) {
+		Array.from(sel.options).forEach(o => o.selected = true);
+		chosen.clear(); Array.from(sel.options).forEach(o => chosen.add(o.value));
+	  }
+	  state.filterClusters = chosen;
+	  updateAndRender();
+	}
+	
+	function onSearch(inp) {
+	  state.search = inp.value.trim();
+	  updateAndRender();
+	}
+	
+	let debounceTimer;
+	function onSearchDebounced(inp) {
+	  clearTimeout(debounceTimer);
+	  debounceTimer = setTimeout(() => onSearch(inp), 150);
+	}
+	
+	function sortBy(key) {
+	  if (state.sortKey === key) state.sortDir = state.sortDir === "asc" ? "desc" : "asc";
+	  else { state.sortKey = key; state.sortDir = "asc"; }
+	  updateAndRender();
+	}
+	
+	function filterData() {
+	  const needle = state.search.toLowerCase();
+	  return AGG.filter(r => {
+		if (!state.filterSev.has(r.Severity)) return false;
+		if (!state.filterClusters.has(r.Cluster)) return false;
+		if (!needle) return true;
+		const hay = (r.Cluster + " " + (r.Node || "") + " " + (r.Category || "") + " " + LABEL_KEYS.map(k => labelOf(r, k)).join(" ") + " " + r.Severity + " " + r.Check + " " + r.Detail).toLowerCase();
+		return hay.includes(needle);
+	  });
+	}
+	
+	function sortData(rows) {
+	  const k = state.sortKey, dir = state.sortDir;
+	  if (!k) return rows;
+	  const mul = dir === "asc" ? 1 : -1;
+	  rows.sort((a,b) => {
+		let av = a[k] || "", bv = b[k] || "";
+		if (k === "Severity") { av = sevRank[av] || 99; bv = sevRank[bv] || 99; }
+		return (av > bv ? 1 : av < bv ? -1 : 0) * mul;
+	  });
+	  return rows;
+	}
+	
+	// tally counts visible rows; when rows were limited and no search is active
+	// it uses the true totals so summaries are not understated.
+	function tally(rows) {
+	  const cnt = { FAIL:0, WARN:0, ERR:0, INFO:0 };
+	  const map = {};
+	  if (TRUNCATED && !state.search) {
+		Object.keys(COUNTS).forEach(c => {
+		  if (!state.filterClusters.has(c)) return;
+		  Object.keys(COUNTS[c]).forEach(s => {
+			if (!state.filterSev.has(s)) return;
+			const n = COUNTS[c][s];
+			map[c] = map[c] || { FAIL:0,WARN:0,ERR:0,INFO:0, total:0 };
+			map[c][s] = (map[c][s] || 0) + n; map[c].total += n;
+			if (cnt[s] !== undefined) cnt[s] += n;
+		  });
+		});
+	  } else {
+		rows.forEach(r => {
+		  if (cnt[r.Severity] !== undefined) cnt[r.Severity]++;
+		  map[r.Cluster] = map[r.Cluster] || { FAIL:0,WARN:0,ERR:0,INFO:0, total:0 };
+		  map[r.Cluster][r.Severity]++; map[r.Cluster].total++;
+		});
+	  }
+	  const total = Object.keys(cnt).reduce((a, k) => a + cnt[k], 0);
+	  return { cnt, map, total };
+	}
+
+	function updateCounts(rows) {
+	  const { cnt, map, total } = tally(rows);
+	
+	  document.getElementById("countTotal").textContent = total;
+	  document.getElementById("countFail").textContent = cnt.FAIL;
+	  document.getElementById("countWarn").textContent = cnt.WARN;
+	  document.getElementById("countErr").textContent  = cnt.ERR;
+	  document.getElementById("countInfo").textContent = cnt.INFO;
+	
+	  const pct = {};
+	  Object.keys(cnt).forEach(k => pct[k] = total ? Math.round(cnt[k]*100/total) : 0);
+	  document.getElementById("barFail").style.width = pct.FAIL + "%";
+	  document.getElementById("barWarn").style.width = pct.WARN + "%";
+	  document.getElementById("barErr").style.width  = pct.ERR  + "%";
+	  document.getElementById("barInfo").style.width = pct.INFO + "%";
+	
+	  // Per-cluster summary with links
+	  const pc = document.getElementById("perCluster");
+	  pc.innerHTML = "";
+	  const table = document.createElement("table");
+	  table.innerHTML = '<thead><tr><th>Cluster</th><th>FAIL</th><th>WARN</th><th>ERR</th><th>INFO</th><th>Total</th></tr></thead><tbody></tbody>';
+	  const tb = table.querySelector("tbody");
+	  Object.keys(map).sort().forEach(c => {
+		const m = map[c];
+		const tr = document.createElement("tr");
+		const link = FILES[c] ? encodeURIComponent(FILES[c]) : encodeURIComponent(c) + '.log.html';
+		tr.innerHTML =
+		  '<td><a class="mono" href="' + link + '">' + escapeHtml(c) + '</a></td>' +
+		  '<td><span class="severity sev-FAIL">' + m.FAIL + '</span></td>' +
+		  '<td><span class="severity sev-WARN">' + m.WARN + '</span></td>' +
+		  '<td><span class="severity sev-ERR">'  + m.ERR  + '</span></td>' +
+		  '<td><span class="severity sev-INFO">' + m.INFO + '</span></td>' +
+		  '<td>' + m.total + '</td>';
+		tb.appendChild(tr);
+	  });
+	  pc.appendChild(table);
+	}
+	
+	function extractKB(detail) {
+	  const text = detail || "";
+	  const re = /(https?:\/\/[^\s)]+portal\.nutanix\.com\/kb\/\d+|https?:\/\/[^\s)]+)/i;
+	  const m = text.match(re);
+	  return m ? m[0] : "";
+	}
+	function kbLabel(url) {
+	  if (!url) return "";
+	  const m = url.match(/\/kb\/(\d+)\b/i);
+	  return m ? ('KB-' + m[1]) : 'KB';
+	}
+	
+	function escapeHtml(s) {
+	  return (s || "").toString()
+		.replaceAll("&","&amp;").replaceAll("<","&lt;").replaceAll(">","&gt;")
+		.replaceAll('"',"&quot;").replaceAll("'","&#39;");
+	}
+	
+	function highlight(text, needle) {
+	  if (!needle) return escapeHtml(text);
+	  const re = new RegExp("(" + needle.replace(/[.*+?^${}()|[\\]\\\\]/g, "\\$&") + ")", "ig");
+	  return escapeHtml(text).replace(re, '<span class="highlight">$1</span>');
+	}
+	
+	function formatCheckTitle(s) {
+ 	 s = s || "";
+  	return s.replace(/^detailed information for\s*/i, "").replace(/:$/, "");
+	}
+
+	function jsEscape(s) {
+	  return (s || "").toString()
+		.replaceAll("\\", "\\\\").replaceAll("\n", "\\n").replaceAll("\r", " ")
+		.replaceAll("'", "\\'").replaceAll("\"", "\\\"");
+	}
+	
+	async function copyText(text) {
+	  try { await navigator.clipboard.writeText(text); }
+	  catch {
+		const ta = document.createElement("textarea");
+		ta.value = text; document.body.appendChild(ta);
+		ta.select(); document.execCommand("copy");
+		document.body.removeChild(ta);
+	  }
+	}
+	
+	function renderTable(rows) {
+	  const tbody = document.getElementById("tbody");
+	  tbody.innerHTML = "";
+	  const needle = state.search;
+	  if (rows.length === 0) {
+		const msg = AGG.length === 0 ? "All checks passed on every cluster: no findings." : "No rows match the current filters.";
+		tbody.innerHTML = '<tr><td colspan="' + (COLUMNS.length + LABEL_KEYS.length) + '">' + msg + '</td></tr>';
+		return;
+	  }
+	  const frag = document.createDocumentFragment();
+	  rows.forEach((r, idx) => {
+		const tr = document.createElement("tr");
+		tr.setAttribute("tabindex", "0");
+		tr.dataset.index = idx.toString();
+	
+		const detailEsc = (r.Detail || "").replaceAll("\\n","<br>");
+	
+		const kb = r.RemediationURL || extractKB(r.Detail);
+		const kbCell = kb ? ('<a href="' + escapeHtml(kb) + '" target="_blank" rel="noopener" title="' + escapeHtml(r.Remediation || "") + '">' + kbLabel(kb) + '</a>') : '';
+		// Cluster keys are a host, [v6]:port / host:port, or a bare IPv6 address.
+		const hasPort = /^(\[[^\]]+\]|[^:]+):\d+$/.test(r.Cluster);
+		const clusterUrl = escapeHtml('https://' + (hasPort ? r.Cluster : (r.Cluster.includes(':') ? '[' + r.Cluster + ']' : r.Cluster) + ':9440'));
+		const rowText = (r.Cluster + (r.Node ? " " + r.Node : "") + " " + r.Severity + " " + r.Check + " " + (r.Detail || "")).trim();
+		const actHTML =
+		  '<div class="actions">' +
+		  '<button onclick="copyText(\'' + jsEscape(rowText) + '\')">Copy row</button>' +
+		  '<button onclick="copyText(\'' + jsEscape(r.Detail || "") + '\')">Copy detail</button>' +
+		  '</div>';
+		const checkTitle = formatCheckTitle(r.Check || "");
+		// Node and category sit under cluster and check unless they have their own column.
+		const cells = {
+		  cluster: '<td class="col-cluster"><small class="mono"><a href="' + clusterUrl + '" target="_blank" rel="noopener">' + highlight(r.Cluster, needle) + '</a></small>' +
+			(r.Node && !COLUMNS.includes("node") ? '<br><small class="mono">node ' + highlight(r.Node, needle) + '</small>' : '') + '</td>',
+		  labels: LABEL_KEYS.map(k => '<td class="col-label"><small class="mono">' + highlight(labelOf(r, k), needle) + '</small></td>').join(''),
+		  severity: '<td class="col-sev"><span class="severity sev-' + r.Severity + '">' + r.Severity + '</span></td>',
+		  check: '<td class="col-title"><small class="mono">' + highlight(checkTitle, needle) + '</small>' +
+			(r.Category && !COLUMNS.includes("category") ? '<br><small class="mono">' + highlight(r.Category, needle) + '</small>' : '') + '</td>',
+		  node: '<td class="col-node"><small class="mono">' + highlight(r.Node || "", needle) + '</small></td>',
+		  category: '<td class="col-category"><small class="mono">' + highlight(r.Category || "", needle) + '</small></td>',
+		  remediation: '<td class="col-kb">' + kbCell + '</td>',
+		  detail: '<td class="col-detail"><div class="detail-full">' + highlight(detailEsc, needle) + '</div></td>'
+		};
+		tr.innerHTML = COLUMNS.map(c => cells[c]).join('') + '<td class="col-actions">' + actHTML + '</td>';
+	
+		tr.addEventListener("focus", () => selectRow(tr));
+		frag.appendChild(tr);
+	  });
+	  tbody.appendChild(frag);
+	}
+	
+	function selectRow(tr) {
+	  const tbody = document.getElementById("tbody");
+	  Array.from(tbody.querySelectorAll("tr.selected")).forEach(x => x.classList.remove("selected"));
+	  tr.classList.add("selected");
+	  selIndex = parseInt(tr.dataset.index || "-1", 10);
+	}
+	
+	function focusRow(i) {
+	  const rows = document.querySelectorAll("#tbody tr");
+	  if (!rows.length) return;
+	  if (i < 0) i = 0;
+	  if (i >= rows.length) i = rows.length - 1;
+	  selIndex = i;
+	  const tr = rows[i];
+	  tr.focus({preventScroll:false});
+	  selectRow(tr);
+	  tr.scrollIntoView({block:"nearest", inline:"nearest"});
+	}
+	
+	function onKey(e) {
+	  const k = e.key;
+	  if (k === "/") {
+		e.preventDefault();
+		const sb = document.getElementById("searchBox");
+		sb.focus(); sb.select();
+		return;
+	  }
+	  if (k === "Escape") {
+		if (state.search) {
+		  state.search = ""; document.getElementById("searchBox").value = "";
+		  updateAndRender();
+		}
+		return;
+	  }
+	  if (k === "ArrowDown") { e.preventDefault(); focusRow(selIndex + 1); return; }
+	  if (k === "ArrowUp")   { e.preventDefault(); focusRow(selIndex - 1); return; }
+	}
+	
+	function updateAndRender() {
+	  let rows = filterData();
+	  // Update visible counters
+	  const { cnt, total } = tally(rows);
+	  document.getElementById("countTotal").textContent = total;
+	  document.getElementById("countFail").textContent = cnt.FAIL;
+	  document.getElementById("countWarn").textContent = cnt.WARN;
+	  document.getElementById("countErr").textContent  = cnt.ERR;
+	  document.getElementById("countInfo").textContent = cnt.INFO;
+	  const pct = {};
+	  Object.keys(cnt).forEach(k => pct[k] = total ? Math.round(cnt[k]*100/total) : 0);
+	  document.getElementById("barFail").style.width = pct.FAIL + "%";
+	  document.getElementById("barWarn").style.width = pct.WARN + "%";
+	  document.getElementById("barErr").style.width  = pct.ERR  + "%";
+	  document.getElementById("barInfo").style.width = pct.INFO + "%";
+	
+	  // Per-cluster summary and table
+	  updateCounts(rows);
+	  if (SUMMARY_ONLY) return;
+	  rows = sortData(rows.slice());
+	  renderTable(rows);
+	}
+	
+	function downloadCSV() {
+		const rows = filterData();
+		const cols = {
+		  cluster: ["Cluster", r => r.Cluster],
+		  severity: ["Severity", r => r.Severity],
+		  check: ["NCC Alert Title", r => formatCheckTitle(r.Check || "")],
+		  detail: ["Detail", r => r.Detail],
+		  node: ["Node", r => r.Node],
+		  category: ["Category", r => r.Category],
+		  remediation: ["Remediation", r => r.Remediation]
+		};
+		const headers = EXPORT_COLUMNS.map(c => cols[c][0]).concat(LABEL_KEYS);
+		const lines = [headers.join(",")];
+		rows.forEach(r => {
+		  const row = EXPORT_COLUMNS.map(c => cols[c][1](r) || "").concat(LABEL_KEYS.map(k => labelOf(r, k))).map(v => {
+		    const s = (v ?? "").toString().replaceAll('"','""').replaceAll("\r"," ").replaceAll("\n","\\n");
+		    return '"' + s + '"';
+		  }).join(",");
+		  lines.push(row);
+		});
+	  const blob = new Blob([lines.join("\n")], {type: "text/csv;charset=utf-8;"});
+	  triggerDownload(blob, "aggregated_filtered.csv");
+	}
+	
+	function downloadJSON() {
+	  const rows = filterData();
+	  const blob = new Blob([JSON.stringify(rows, null, 2)], {type: "application/json;charset=utf-8;"});
+	  triggerDownload(blob, "aggregated_filtered.json");
+	}
+	
+	function triggerDownload(blob, name) {
+	  const a = document.createElement("a");
+	  a.href = URL.createObjectURL(blob);
+	  a.download = name;
+	  document.body.appendChild(a);
+	  a.click();
+	  document.body.removeChild(a);
+	}
+	</script>
+	</head>
+	<body onload="init()">
+	<div class="container">
+	  <div class="header">
+		<div class="title">
+		  <h1>NCC Aggregated Report</h1>
+		  <div class="sub">Generated at {{.GeneratedAt}}</div>
+		  {{if .Vars}}<div class="sub">{{range $k, $v := .Vars}}<span style="margin-right:16px"><b>{{$k}}:</b> {{$v}}</span>{{end}}</div>{{end}}
+		</div>
+        <!--
+        <div class="legend">
+          <span class="badge"><span class="dot fail"></span> FAIL</span>
+          <span class="badge"><span class="dot warn"></span> WARN</span>
+          <span class="badge"><span class="dot err"></span> ERR</span>
+          <span class="badge"><span class="dot info"></span> INFO</span>
+        </div>
+        -->
+	  </div>
+	
+	  <div class="card" style="margin-bottom:14px">
+		<div class="label" style="margin-bottom:8px">Cluster Status</div>
+		<table class="status">
+		  <thead><tr><th>Cluster</th><th>Status</th><th>Reachable</th><th>NCC started</th><th>Completed</th><th>FAIL</th><th>WARN</th><th>Duration</th><th>Error</th></tr></thead>
+		  <tbody>
+		  {{range .Status}}
+			<tr class="st-{{.Status}}">
+			  <td><small class="mono">{{.Cluster}}</small></td>
+			  <td><span class="st-badge">{{.Status}}</span>{{if .Cached}} <small>(cached)</small>{{end}}</td>
+			  <td>{{if .Reachable}}yes{{else}}no{{end}}</td>
+			  <td>{{if .Started}}yes{{else}}no{{end}}</td>
+			  <td>{{if .Completed}}yes{{else}}no{{end}}</td>
+			  <td>{{.Fail}}</td>
+			  <td>{{.Warn}}</td>
+			  <td>{{if .DurationSeconds}}{{printf "%.0fs" .DurationSeconds}}{{end}}</td>
+			  <td><small class="mono">{{.Error}}</small></td>
+			</tr>
+		  {{end}}
+		  </tbody>
+		</table>
+	  </div>
+
 	  <div class="controls">
+		{{if not .SummaryOnly}}
 		<div class="control">
 		  <label>Search</label>
 		  <input id="searchBox" type="text" placeholder="Type to filter..." oninput="onSearchDebounced(this)" />
 		</div>
+		{{end}}
 		<div class="control">
 		  <label>Severity</label>
 <label>
@@ -1314,10 +5017,12 @@ func writeAggregatedHTMLSingle(fs FS, outDir string, rows []AggBlock, perCluster
 		  <label>Clusters</label>
 		  <select id="clusterSel" multiple onchange="onClusterChange(this)"></select>
 		</div>
+		{{if not .SummaryOnly}}
 		<div class="control">
 		  <button onclick="downloadCSV()">Export CSV</button>
 		  <button onclick="downloadJSON()">Export JSON</button>
 		</div>
+		{{end}}
 	  </div>
 	
 	  <div class="summary">
@@ -1352,320 +5057,1564 @@ func writeAggregatedHTMLSingle(fs FS, outDir string, rows []AggBlock, perCluster
 		<div id="perCluster"></div>
 	  </div>
 	
+	  {{if not .SummaryOnly}}
 	  <div class="card">
 		<div class="scroll">
 		  <table>
 			<thead>
 			  <tr>
-				<th class="col-cluster" onclick="sortBy('Cluster')">Cluster</th>
-				<th class="col-sev" onclick="sortBy('Severity')">Severity</th>
-				<th class="col-title" onclick="sortBy('Check')">NCC Alert Title</th>
-				<th class="col-kb">KB</th>
-				<th class="col-detail">Detail</th>
+				{{range .Cols}}
+				{{if eq . "cluster"}}<th class="col-cluster" onclick="sortBy('Cluster')">Cluster</th>
+				{{else if eq . "labels"}}{{range $.LabelNames}}<th class="col-label">{{.}}</th>{{end}}
+				{{else if eq . "severity"}}<th class="col-sev" onclick="sortBy('Severity')">Severity</th>
+				{{else if eq . "check"}}<th class="col-title" onclick="sortBy('Check')">NCC Alert Title</th>
+				{{else if eq . "node"}}<th class="col-node" onclick="sortBy('Node')">Node</th>
+				{{else if eq . "category"}}<th class="col-category" onclick="sortBy('Category')">Category</th>
+				{{else if eq . "remediation"}}<th class="col-kb">Remediation</th>
+				{{else if eq . "detail"}}<th class="col-detail">Detail</th>{{end}}
+				{{end}}
 				<th class="col-actions">Actions</th>
 			  </tr>
 			</thead>
 			<tbody id="tbody"></tbody>
 		  </table>
 		</div>
+		{{range $sev, $n := .Omitted}}
+		<div class="label" style="margin-top:8px">… and {{$n}} more {{$sev}} rows (see JSON)</div>
+		{{end}}
 	  </div>
 	
      <footer class="report-footer">
     Keyboard: “/” to focus search, ↑/↓ to move, Esc to clear search. Full details visible in table.
 </footer>
+	  {{end}}
+
+
+<style>
+    .report-footer {
+        font-size: 0.8125rem;
+        color: #666; /* Better contrast than #aaa */
+        margin-bottom: 0;
+        padding: 10px; /* Adds breathing room */
+        bottom: 0;
+        left: 0;
+        width: 100%;
+    }
+</style>
+	</div>
+	</body>
+	</html>`
+
+	// Build data for template with embedded JSON
+	type tmplRow struct {
+		Cluster        string
+		Severity       string
+		Check          string
+		CheckID        string `json:",omitempty"`
+		Detail         string
+		Node           string            `json:",omitempty"`
+		Category       string            `json:",omitempty"`
+		Labels         map[string]string `json:",omitempty"`
+		Remediation    string            `json:",omitempty"`
+		RemediationURL string            `json:",omitempty"`
+	}
+	counts := map[string]map[string]int{}
+	for _, pc := range perCluster {
+		counts[pc.Cluster] = map[string]int{}
+	}
+	for _, r := range rows {
+		if counts[r.Cluster] == nil {
+			counts[r.Cluster] = map[string]int{}
+		}
+		counts[r.Cluster][r.Severity]++
+	}
+	shown, omitted := limitPerSeverity(rows, maxPerSev, func(r AggBlock) string { return r.Severity })
+	if summaryOnly {
+		// Counts come from COUNTS; no rows are embedded.
+		shown, omitted = nil, nil
+	}
+	aggRows := make([]tmplRow, 0, len(shown))
+	for _, r := range shown {
+		aggRows = append(aggRows, tmplRow(r))
+	}
+	// Embed JSON safely
+	jsonBytes, err := json.Marshal(aggRows)
+	if err != nil {
+		return fmt.Errorf("marshal agg json: %w", err)
+	}
+	countBytes, err := json.Marshal(counts)
+	if err != nil {
+		return fmt.Errorf("marshal agg counts: %w", err)
+	}
+	files := make(map[string]string, len(perCluster))
+	for _, pc := range perCluster {
+		files[pc.Cluster] = pc.HTML
+	}
+	fileBytes, err := json.Marshal(files)
+	if err != nil {
+		return fmt.Errorf("marshal agg files: %w", err)
+	}
+	keys := labelKeys(rows)
+	keyBytes, err := json.Marshal(append([]string{}, keys...))
+	if err != nil {
+		return fmt.Errorf("marshal agg label keys: %w", err)
+	}
+	// --columns picks and orders the table and the CSV export. Label
+	// columns follow cluster in the table, or lead when it is left out.
+	cols := []string{"cluster", "severity", "check", "remediation", "detail"}
+	export := []string{"cluster", "severity", "check", "detail", "node", "category"}
+	if len(columns) > 0 {
+		cols, export = slices.Clone(columns), columns
+	}
+	cols = slices.Insert(cols, slices.Index(cols, "cluster")+1, "labels")
+	colBytes, err := json.Marshal(cols)
+	if err != nil {
+		return fmt.Errorf("marshal agg columns: %w", err)
+	}
+	exportBytes, err := json.Marshal(export)
+	if err != nil {
+		return fmt.Errorf("marshal agg export columns: %w", err)
+	}
+	data := struct {
+		JSON          template.JS
+		Counts        template.JS
+		Truncated     bool
+		Files         template.JS
+		LabelKeys     template.JS
+		Columns       template.JS
+		ExportColumns template.JS
+		SevRank       template.JS
+		LabelNames    []string
+		Cols          []string
+		Omitted       map[string]int
+		Clusters      []struct{ Cluster, HTML, CSV string }
+		Status        []ClusterSummary
+		Vars          map[string]string
+		SummaryOnly   bool
+		GeneratedAt   string
+	}{
+		JSON:          template.JS(jsonBytes), // trusted program output
+		Counts:        template.JS(countBytes),
+		Truncated:     len(omitted) > 0 || summaryOnly,
+		Files:         template.JS(fileBytes),
+		LabelKeys:     template.JS(keyBytes),
+		Columns:       template.JS(colBytes),
+		ExportColumns: template.JS(exportBytes),
+		SevRank:       template.JS(sevRankJSON()),
+		LabelNames:    keys,
+		Cols:          cols,
+		Omitted:       omitted,
+		Clusters:      perCluster,
+		Status:        status,
+		Vars:          vars,
+		SummaryOnly:   summaryOnly,
+		GeneratedAt:   reportTime(time.Now()),
+	}
+
+	f, err := createAtomic(fs, path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+	t := template.Must(template.New("index").Funcs(reportFuncs).Parse(tmpl))
+	if err := t.Execute(f, data); err != nil {
+		return fmt.Errorf("template execute %s: %w", path, err)
+	}
+	if err := f.Commit(); err != nil {
+		return fmt.Errorf("commit %s: %w", path, err)
+	}
+	log.Info().Str("file", abs).Int("rows", len(rows)).Int("clusters", len(perCluster)).Msg("aggregated HTML generated")
+	return nil
+}
+
+// cleanStaleOutputs removes <cluster>.log.* files in dir that belong to
+// clusters not in the current list. Returns the removed paths.
+func cleanStaleOutputs(fs FS, dir string, clusters []string) ([]string, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(clusters))
+	for _, c := range clusters {
+		keep[sanitizeFilename(c)] = true
+	}
+	var removed []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		i := strings.LastIndex(name, ".log.")
+		if i <= 0 {
+			continue
+		}
+		if keep[name[:i]] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := fs.Remove(path); err != nil {
+			log.Warn().Err(err).Str("file", path).Msg("remove stale output failed")
+			continue
+		}
+		log.Info().Str("file", path).Str("cluster", name[:i]).Msg("removed stale output")
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// staleKeep returns the clusters whose outputs --clean-stale keeps: the
+// configured list from before --select narrowed it, if there was one.
+func staleKeep(cfg Config) []string {
+	if cfg.ConfiguredClusters != nil {
+		return cfg.ConfiguredClusters
+	}
+	return cfg.Clusters
+}
+
+// pruneRunDirs removes all but the newest keep timestamped run directories
+// next to runDir (<base>-<runDirTimeLayout>, see resolveOutputDir). Only
+// directories named <base>-<timestamp> for runDir's base are touched, and
+// runDir itself is always kept. Returns the removed paths.
+func pruneRunDirs(fs FS, runDir string, keep int) ([]string, error) {
+	name := filepath.Base(runDir)
+	i := len(name) - len(runDirTimeLayout)
+	if i < 2 || name[i-1] != '-' {
+		return nil, fmt.Errorf("%s is not a timestamped run directory", runDir)
+	}
+	if _, err := time.Parse(runDirTimeLayout, name[i:]); err != nil {
+		return nil, fmt.Errorf("%s is not a timestamped run directory", runDir)
+	}
+	prefix := name[:i]
+	parent := filepath.Dir(runDir)
+	entries, err := fs.ReadDir(parent)
+	if err != nil {
+		return nil, err
+	}
+	type run struct {
+		path string
+		at   time.Time
+	}
+	var runs []run
+	for _, e := range entries {
+		ts, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.IsDir() || e.Name() == name {
+			continue
+		}
+		at, err := time.Parse(runDirTimeLayout, ts)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run{filepath.Join(parent, e.Name()), at})
+	}
+	// runDir is the newest and counts as one of the kept runs.
+	if len(runs) < keep {
+		return nil, nil
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].at.After(runs[j].at) })
+	var removed []string
+	for _, r := range runs[keep-1:] {
+		if err := fs.RemoveAll(r.path); err != nil {
+			log.Warn().Err(err).Str("dir", r.path).Msg("prune run directory failed")
+			continue
+		}
+		log.Info().Str("dir", r.path).Time("run", r.at).Msg("pruned old run directory")
+		removed = append(removed, r.path)
+	}
+	return removed, nil
+}
+
+/************** Retryable HTTP wrappers **************/
+
+// backoffFits reports whether sleeping for back leaves time before ctx's
+// deadline. Retrying past the cluster deadline only trades the real error
+// for a context one, so callers return the last error instead.
+func backoffFits(ctx context.Context, back time.Duration, op string, attempt int) bool {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return true
+	}
+	remaining := time.Until(dl)
+	if remaining > back {
+		return true
+	}
+	log.Warn().Str("op", op).Int("attempt", attempt).Dur("backoff", back).Dur("remaining", remaining).Msg("retries cut short by deadline")
+	return false
+}
+
+func doWithRetry(ctx context.Context, client HTTPClient, req *http.Request, cfg Config, op string) (*http.Response, []byte, error) {
+	return doWithRetryLimit(ctx, client, req, cfg, op, cfg.MaxResponseBytes)
+}
+
+// doWithRetryLimit is doWithRetry with an explicit body cap (0 = unlimited).
+func doWithRetryLimit(ctx context.Context, client HTTPClient, req *http.Request, cfg Config, op string, limit int64) (*http.Response, []byte, error) {
+	return doWithRetryBody(ctx, client, req, cfg, op, func(resp *http.Response) ([]byte, error) {
+		return readLimited(resp.Body, limit, op)
+	})
+}
+
+// errResponseTooLarge marks a body past --max-response-bytes or
+// --max-summary-bytes. It is not retried: the endpoint would send it again.
+var errResponseTooLarge = errors.New("response body too large")
+
+// readLimited reads r whole, failing once it passes limit bytes (0 =
+// unlimited) so a runaway endpoint cannot exhaust memory.
+func readLimited(r io.Reader, limit int64, op string) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, NewNCCError(ErrorTypeNetwork, fmt.Sprintf("%s response exceeds %d bytes", op, limit), errResponseTooLarge)
+	}
+	return b, nil
+}
+
+// doWithRetryStream is doWithRetry for large responses: a 2xx body is copied
+// straight into path on fs instead of being buffered, and the file is
+// recreated on every attempt. Past limit bytes (0 = unlimited) the file is
+// removed and the request fails. Non-2xx bodies are still returned for
+// logging.
+func doWithRetryStream(ctx context.Context, client HTTPClient, req *http.Request, cfg Config, op string, fs FS, path string, limit int64) (*http.Response, []byte, error) {
+	return doWithRetryBody(ctx, client, req, cfg, op, func(resp *http.Response) ([]byte, error) {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return readLimited(resp.Body, cfg.MaxResponseBytes, op)
+		}
+		f, err := fs.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		var src io.Reader = resp.Body
+		if limit > 0 {
+			src = io.LimitReader(resp.Body, limit+1)
+		}
+		n, err := io.Copy(f, src)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && limit > 0 && n > limit {
+			_ = fs.Remove(path)
+			return nil, NewNCCError(ErrorTypeNetwork, fmt.Sprintf("%s response exceeds %d bytes", op, limit), errResponseTooLarge)
+		}
+		if err == nil {
+			log.Debug().Str("op", op).Str("path", path).Int64("bytes", n).Msg("response streamed to file")
+		}
+		return nil, err
+	})
+}
+
+// doWithRetryBody holds the retry loop; read consumes each response body.
+func doWithRetryBody(ctx context.Context, client HTTPClient, req *http.Request, cfg Config, op string, read func(*http.Response) ([]byte, error)) (*http.Response, []byte, error) {
+	attempts := cfg.RetryMaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var lastErr error
+	var resp *http.Response
+	var body []byte
+
+	// Snapshot original body if present
+	var origBody []byte
+	var hasBody bool
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		_ = req.Body.Close()
+		origBody = b
+		hasBody = true
+		req.Body = io.NopCloser(bytes.NewReader(origBody))
+	}
+
+	reauthed := false
+	for attempt := 1; attempt <= attempts; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
+		reqClone := req.Clone(reqCtx)
+		if hasBody {
+			reqClone.Body = io.NopCloser(bytes.NewReader(origBody))
+			// 307/308 redirects replay the body through GetBody.
+			reqClone.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(origBody)), nil
+			}
+		}
+
+		resp, lastErr = client.Do(reqClone)
+		if lastErr != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if errors.Is(lastErr, errTooManyRedirects) {
+				return nil, nil, lastErr
+			}
+			class := transportErrorClass(lastErr)
+			if slices.Contains(cfg.NoRetryOn, class) {
+				log.Error().Str("op", op).Int("attempt", attempt).Str("class", class).Err(lastErr).Msg("transport error, not retrying this class")
+				return nil, nil, NewNCCError(ErrorTypeNetwork, op+" transport error ("+class+")", lastErr)
+			}
+			back := jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
+			if attempt < attempts && backoffFits(ctx, back, op, attempt) {
+				log.Warn().Str("op", op).Int("attempt", attempt).Str("class", class).Err(lastErr).Dur("backoff", back).Msg("transport error, retrying")
+				retryStats.record(req.URL.Hostname(), op, back)
+				select {
+				case <-ctx.Done():
+					return nil, nil, ctx.Err()
+				case <-time.After(back):
+				}
+				continue
+			}
+			return nil, nil, NewNCCError(ErrorTypeNetwork, op+" transport error ("+class+")", lastErr)
+		}
+
+		func() {
+			defer cancel()
+			defer resp.Body.Close()
+			var err error
+			body, err = read(resp)
+			if err != nil {
+				lastErr = err
+			} else {
+				lastErr = nil
+			}
+		}()
+		if lastErr != nil {
+			if errors.Is(lastErr, errResponseTooLarge) {
+				log.Error().Str("op", op).Int("status", resp.StatusCode).Err(lastErr).Msg("response too large, not retrying")
+				return resp, nil, lastErr
+			}
+			back := jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
+			if attempt < attempts && backoffFits(ctx, back, op, attempt) {
+				log.Warn().Str("op", op).Int("attempt", attempt).Err(lastErr).Dur("backoff", back).Msg("read body failed, retrying")
+				retryStats.record(req.URL.Hostname(), op, back)
+				select {
+				case <-ctx.Done():
+					return nil, nil, ctx.Err()
+				case <-time.After(back):
+				}
+				continue
+			}
+			return resp, nil, lastErr
+		}
+
+		status := resp.StatusCode
+		if status >= 200 && status < 300 {
+			log.Debug().Str("op", op).Int("status", status).Str("proto", resp.Proto).Msg("request succeeded")
+			return resp, body, nil
+		}
+
+		if status == http.StatusUnauthorized && !reauthed {
+			if h, ok := client.(unauthorizedHandler); ok {
+				reauthed = true
+				if h.OnUnauthorized(ctx) {
+					log.Info().Str("op", op).Int("attempt", attempt).Msg("re-authenticated after 401, retrying once")
+					attempts++
+					continue
+				}
+				log.Warn().Str("op", op).Msg("401 and re-authentication failed")
+			}
+		}
+
+		retryable := isRetryableStatus(status)
+		var back time.Duration
+		if status == 429 {
+			if ra, ok := retryAfterDelay(resp); ok {
+				back = ra
+			}
+		}
+		if back == 0 {
+			back = jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
+		}
+
+		if retryable && attempt < attempts && backoffFits(ctx, back, op, attempt) {
+			log.Warn().Str("op", op).Int("attempt", attempt).Int("status", status).Dur("backoff", back).Msg("retryable status, retrying")
+			retryStats.record(req.URL.Hostname(), op, back)
+			select {
+			case <-ctx.Done():
+				return resp, body, ctx.Err()
+			case <-time.After(back):
+			}
+			continue
+		}
+
+		log.Error().Str("op", op).Int("status", status).Int("attempts", attempt).Msg("request failed, not retrying")
+		return resp, body, &HTTPError{Op: op, StatusCode: status, URL: req.URL.String()}
+	}
+
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+	return resp, body, fmt.Errorf("%s exhausted retries", op)
+}
 
+/************** NCC Client **************/
 
-<style>
-    .report-footer {
-        font-size: 0.8125rem;
-        color: #666; /* Better contrast than #aaa */
-        margin-bottom: 0;
-        padding: 10px; /* Adds breathing room */
-        bottom: 0;
-        left: 0;
-        width: 100%;
-    }
-</style>
-	</div>
-	</body>
-	</html>`
+type NCCClient struct {
+	baseURL   string
+	v3URL     string // Prism v3 API, for --task-api-version v3
+	user      string
+	pass      string
+	http      HTTPClient
+	cfg       Config
+	requestID string // sent as X-Request-ID on every request
+}
+
+func NewNCCClient(cluster, user, pass string, httpc HTTPClient, cfg Config) *NCCClient {
+	return &NCCClient{
+		baseURL:   fmt.Sprintf("https://%s/PrismGateway/services/rest", hostPort(cluster)),
+		v3URL:     fmt.Sprintf("https://%s/api/nutanix/v3", hostPort(cluster)),
+		user:      user,
+		pass:      pass,
+		http:      httpc,
+		cfg:       cfg,
+		requestID: clusterRequestID(cfg.RunID, cluster),
+	}
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// newRunID returns a random RFC 4122 version 4 UUID identifying one run.
+func newRunID() string {
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return fmt.Sprintf("run-%d", time.Now().UnixNano())
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// clusterRequestID derives the per-cluster ID sent to Prism, so server logs
+// can be matched to both the run and the cluster.
+func clusterRequestID(runID, cluster string) string {
+	if runID == "" {
+		return ""
+	}
+	return runID + "-" + sanitizeFilename(cluster)
+}
+
+func setRequestID(req *http.Request, id string) {
+	if id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+}
+
+// setUserAgent replaces Go's default User-Agent so Prism access logs show
+// ncc-orchestrator/<version> (or --user-agent).
+func setUserAgent(req *http.Request, ua string) {
+	if ua != "" {
+		req.Header.Set("User-Agent", ua)
+	}
+}
+
+// startChecksPayload builds the POST /v1/ncc/checks body. Keys sent:
+//
+//	sendEmail  --ncc-send-email; off by default as reports are produced here
+func startChecksPayload(cfg Config) []byte {
+	payload := map[string]any{"sendEmail": cfg.NCCSendEmail}
+	b, _ := json.Marshal(payload)
+	return b
+}
+
+func (c *NCCClient) StartChecks(ctx context.Context) (string, []byte, error) {
+	url := c.baseURL + "/v1/ncc/checks"
+	payload := startChecksPayload(c.cfg)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	resp, body, err := doWithRetry(ctx, c.http, req, c.cfg, "start checks")
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Str("method", "POST").Msg("http do error")
+		return "", body, err
+	}
+	_ = resp
+	log.Debug().Str("url", url).RawJSON("body", body).Msg("start checks response")
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", body, err
+	}
+	uuid, _ := data["taskUuid"].(string)
+	if uuid == "" {
+		if alt, ok := data["task_uuid"].(string); ok && alt != "" {
+			uuid = alt
+		}
+	}
+	if uuid == "" {
+		return "", body, errors.New("missing taskUuid in response")
+	}
+	return uuid, body, nil
+}
+
+func (c *NCCClient) GetTask(ctx context.Context, taskID string) (TaskStatus, []byte, error) {
+	url := c.baseURL + "/v2.0/tasks/" + taskID
+	if c.cfg.TaskAPIVersion == "v3" {
+		url = c.v3URL + "/tasks/" + taskID
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return TaskStatus{}, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	resp, body, err := doWithRetry(ctx, c.http, req, c.cfg, "get task")
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Msg("http do error")
+		return TaskStatus{}, body, err
+	}
+	_ = resp
+	log.Debug().Str("url", url).RawJSON("body", body).Msg("get task response")
+
+	status, err := decodeTaskStatus(body, c.cfg.TaskAPIVersion)
+	if err != nil {
+		return TaskStatus{}, body, err
+	}
+	return status, body, nil
+}
+
+func (c *NCCClient) GetRunSummary(ctx context.Context, taskID string) (NCCSummary, []byte, error) {
+	url := c.baseURL + "/v1/ncc/" + taskID
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return NCCSummary{}, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	resp, body, err := doWithRetryLimit(ctx, c.http, req, c.cfg, "get summary", c.cfg.MaxSummaryBytes)
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Msg("http do error")
+		return NCCSummary{}, body, err
+	}
+	_ = resp
+	log.Debug().Str("url", url).RawJSON("body", body).Msg("get summary response")
+
+	var summary NCCSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return NCCSummary{}, body, err
+	}
+	return summary, body, nil
+}
+
+// GetRunSummaryToFile fetches the run summary like GetRunSummary but streams
+// the raw response into path and reads runSummary back with
+// decodeRunSummary, so neither the HTTP body nor the JSON document is ever
+// held in memory; only the unescaped summary string is. The body is only
+// returned on error; the caller removes path.
+func (c *NCCClient) GetRunSummaryToFile(ctx context.Context, taskID string, fs FS, path string) (NCCSummary, []byte, error) {
+	url := c.baseURL + "/v1/ncc/" + taskID
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return NCCSummary{}, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return NCCSummary{}, nil, err
+	}
+	_, body, err := doWithRetryStream(ctx, c.http, req, c.cfg, "get summary", fs, path, c.cfg.MaxSummaryBytes)
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Msg("http do error")
+		return NCCSummary{}, body, err
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return NCCSummary{}, nil, err
+	}
+	defer f.Close()
+	var size int64
+	if st, err := fs.Stat(path); err == nil {
+		size = st.Size()
+	}
+	text, err := decodeRunSummary(f, size)
+	if err != nil {
+		return NCCSummary{}, nil, fmt.Errorf("decode summary %s: %w", path, err)
+	}
+	return NCCSummary{RunSummary: text}, nil, nil
+}
+
+// errBadSummaryJSON is returned by decodeRunSummary for malformed input.
+var errBadSummaryJSON = errors.New("malformed run summary JSON")
+
+// decodeRunSummary reads the runSummary member of a GET /v1/ncc/<task> body
+// from r. json.Decoder buffers a whole document before decoding it, which
+// for a summary of hundreds of MB means holding it two or three times; this
+// reads through a small buffer, skips other members and unescapes
+// runSummary straight into the result. size is a capacity hint. A missing or
+// null runSummary decodes as "".
+func decodeRunSummary(r io.Reader, size int64) (string, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	next := func() (byte, error) {
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return 0, errBadSummaryJSON
+			}
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				return c, nil
+			}
+		}
+	}
+	if c, err := next(); err != nil || c != '{' {
+		return "", errBadSummaryJSON
+	}
+	var summary strings.Builder
+	for first := true; ; first = false {
+		c, err := next()
+		if err != nil {
+			return "", err
+		}
+		if c == '}' && first {
+			return "", nil
+		}
+		if c != '"' {
+			return "", errBadSummaryJSON
+		}
+		var key strings.Builder
+		if err := unescapeJSONString(br, &key, 1<<10); err != nil {
+			return "", err
+		}
+		if c, err := next(); err != nil || c != ':' {
+			return "", errBadSummaryJSON
+		}
+		c, err = next()
+		if err != nil {
+			return "", err
+		}
+		if key.String() == "runSummary" && c == '"' {
+			summary.Reset()
+			summary.Grow(int(size))
+			if err := unescapeJSONString(br, &summary, -1); err != nil {
+				return "", err
+			}
+		} else if err := skipJSONValue(br, c); err != nil {
+			return "", err
+		}
+		if c, err = next(); err != nil {
+			return "", err
+		}
+		switch c {
+		case ',':
+		case '}':
+			return summary.String(), nil
+		default:
+			return "", errBadSummaryJSON
+		}
+	}
+}
+
+// unescapeJSONString copies a JSON string body from br (the opening quote
+// already read) into w and consumes the closing quote. Runs without escapes
+// are copied straight from br's buffer. max caps the unescaped length (-1 =
+// unlimited).
+func unescapeJSONString(br *bufio.Reader, w *strings.Builder, max int) error {
+	for {
+		if br.Buffered() == 0 {
+			if _, err := br.Peek(1); err != nil {
+				return errBadSummaryJSON
+			}
+		}
+		buf, _ := br.Peek(br.Buffered())
+		i := bytes.IndexAny(buf, `"\`)
+		if i < 0 {
+			w.Write(buf)
+			_, _ = br.Discard(len(buf))
+		} else {
+			w.Write(buf[:i])
+			c := buf[i]
+			_, _ = br.Discard(i + 1)
+			if c == '"' {
+				return nil
+			}
+			if err := writeJSONEscape(br, w); err != nil {
+				return err
+			}
+		}
+		if max >= 0 && w.Len() > max {
+			return errBadSummaryJSON
+		}
+	}
+}
+
+// writeJSONEscape reads the escape after a backslash from br and writes the
+// character it stands for. A UTF-16 surrogate pair (\uD83D\uDE00) becomes
+// one rune; a lone surrogate becomes U+FFFD, as with encoding/json.
+func writeJSONEscape(br *bufio.Reader, w *strings.Builder) error {
+	c, err := br.ReadByte()
+	if err != nil {
+		return errBadSummaryJSON
+	}
+	switch c {
+	case '"', '\\', '/':
+		w.WriteByte(c)
+	case 'b':
+		w.WriteByte('\b')
+	case 'f':
+		w.WriteByte('\f')
+	case 'n':
+		w.WriteByte('\n')
+	case 'r':
+		w.WriteByte('\r')
+	case 't':
+		w.WriteByte('\t')
+	case 'u':
+		var hex [4]byte
+		if _, err := io.ReadFull(br, hex[:]); err != nil {
+			return errBadSummaryJSON
+		}
+		v, err := strconv.ParseUint(string(hex[:]), 16, 16)
+		if err != nil {
+			return errBadSummaryJSON
+		}
+		r := rune(v)
+		if utf16.IsSurrogate(r) {
+			r = utf8.RuneError
+			if next, err := br.Peek(6); err == nil && next[0] == '\\' && next[1] == 'u' {
+				if v2, err := strconv.ParseUint(string(next[2:]), 16, 16); err == nil {
+					if d := utf16.DecodeRune(rune(v), rune(v2)); d != utf8.RuneError {
+						r = d
+						_, _ = br.Discard(6)
+					}
+				}
+			}
+		}
+		w.WriteRune(r)
+	default:
+		return errBadSummaryJSON
+	}
+	return nil
+}
+
+// skipJSONValue consumes the value starting with c, which was just read.
+func skipJSONValue(br *bufio.Reader, c byte) error {
+	depth := 0
+	for {
+		switch c {
+		case '"':
+			if err := skipJSONString(br); err != nil {
+				return err
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth < 0 {
+				return errBadSummaryJSON
+			}
+		}
+		if depth == 0 {
+			// A scalar ends at the next delimiter, which is left unread.
+			if c != '"' && c != '}' && c != ']' {
+				for {
+					b, err := br.Peek(1)
+					if err != nil {
+						return errBadSummaryJSON
+					}
+					if b[0] == ',' || b[0] == '}' || b[0] == ']' || b[0] == ' ' || b[0] == '\t' || b[0] == '\n' || b[0] == '\r' {
+						return nil
+					}
+					_, _ = br.ReadByte()
+				}
+			}
+			return nil
+		}
+		b, err := br.ReadByte()
+		if err != nil {
+			return errBadSummaryJSON
+		}
+		c = b
+	}
+}
+
+// skipJSONString consumes a string body up to its closing quote.
+func skipJSONString(br *bufio.Reader) error {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return errBadSummaryJSON
+		}
+		switch b {
+		case '\\':
+			if _, err := br.ReadByte(); err != nil {
+				return errBadSummaryJSON
+			}
+		case '"':
+			return nil
+		}
+	}
+}
+
+// fullLogsTimeout is the per-attempt timeout for FetchRunLogs when it is
+// longer than --request-timeout; the bundle is far bigger than any API reply.
+const fullLogsTimeout = 5 * time.Minute
+
+// errFullLogsUnsupported is returned by FetchRunLogs when the cluster does
+// not serve the full-log endpoint.
+var errFullLogsUnsupported = errors.New("full NCC log download not supported by this cluster")
+
+// FetchRunLogs streams the full NCC output of taskID into path on fs. It
+// can run to hundreds of MB, so it is never held in memory; a failed
+// download leaves no partial file behind.
+//
+// Experimental: GET /v1/ncc/<task>/logs is not in the published Prism v1
+// API reference and has not been verified against every AOS release. A 404
+// or 501 is reported as errFullLogsUnsupported rather than a failure.
+func (c *NCCClient) FetchRunLogs(ctx context.Context, taskID string, fs FS, path string) error {
+	url := c.baseURL + "/v1/ncc/" + taskID + "/logs"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain, application/octet-stream, */*")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	cfg := c.cfg
+	cfg.RequestTimeout = max(cfg.RequestTimeout, fullLogsTimeout)
+	// Streamed to disk only, so the memory caps do not apply.
+	if _, body, err := doWithRetryStream(ctx, c.http, req, cfg, "get full logs", fs, path, 0); err != nil {
+		_ = fs.Remove(path)
+		var he *HTTPError
+		if errors.As(err, &he) && (he.StatusCode == http.StatusNotFound || he.StatusCode == http.StatusNotImplemented) {
+			return fmt.Errorf("%w (GET %s returned %d)", errFullLogsUnsupported, url, he.StatusCode)
+		}
+		log.Error().Err(err).Str("url", url).Int("bodyBytes", len(body)).Msg("http do error")
+		return err
+	}
+	return nil
+}
+
+// host is the cluster host c talks to, without port or brackets.
+func (c *NCCClient) host() string {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// ResolveMembers records the CVM addresses of c's cluster from GET
+// /v1/hosts (serviceVMExternalIP) as redirect targets that may receive the
+// credentials; see checkRedirect.
+func (c *NCCClient) ResolveMembers(ctx context.Context) error {
+	url := c.baseURL + "/v1/hosts"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	_, body, err := doWithRetry(ctx, c.http, req, c.cfg, "list hosts")
+	if err != nil {
+		return err
+	}
+	var hosts struct {
+		Entities []struct {
+			ServiceVMExternalIP string `json:"serviceVMExternalIP"`
+		} `json:"entities"`
+	}
+	if err := json.Unmarshal(body, &hosts); err != nil {
+		return fmt.Errorf("decode hosts: %w", err)
+	}
+	var members []string
+	for _, h := range hosts.Entities {
+		members = append(members, h.ServiceVMExternalIP)
+	}
+	addClusterMembers(c.host(), members...)
+	return nil
+}
+
+// errNCCNotInstalled is returned by NCCAvailabilityCheck when Prism answers
+// but reports no NCC version.
+var errNCCNotInstalled = errors.New("NCC is not installed")
+
+// NCCAvailabilityCheck confirms NCC is installed before a run is started, so
+// a cluster without it fails pre-flight instead of minutes into the run. It
+// reads nccVersion from GET /v1/cluster and returns it.
+func (c *NCCClient) NCCAvailabilityCheck(ctx context.Context) (string, error) {
+	url := c.baseURL + "/v1/cluster"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
 
-	// Build data for template with embedded JSON
-	type tmplRow struct {
-		Cluster  string
-		Severity string
-		Check    string
-		Detail   string
+	_, body, err := doWithRetry(ctx, c.http, req, c.cfg, "ncc availability")
+	if err != nil {
+		log.Error().Err(err).Str("url", url).Msg("http do error")
+		return "", err
 	}
-	aggRows := make([]tmplRow, 0, len(rows))
-	for _, r := range rows {
-		aggRows = append(aggRows, tmplRow(r))
+	var info struct {
+		Version    string `json:"version"`
+		NCCVersion string `json:"nccVersion"`
+		ExternalIP string `json:"clusterExternalIPAddress"`
 	}
-	// Embed JSON safely
-	jsonBytes, err := json.Marshal(aggRows)
-	if err != nil {
-		return fmt.Errorf("marshal agg json: %w", err)
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("decode cluster info: %w", err)
 	}
-	data := struct {
-		JSON        template.JS
-		Clusters    []struct{ Cluster, HTML, CSV string }
-		GeneratedAt string
-	}{
-		JSON:        template.JS(jsonBytes), // trusted program output
-		Clusters:    perCluster,
-		GeneratedAt: time.Now().Format(time.RFC3339),
+	if info.ExternalIP != "" {
+		addClusterMembers(c.host(), info.ExternalIP)
+	}
+	if info.NCCVersion == "" {
+		return "", NewNCCError(ErrorTypeValidation, fmt.Sprintf("AOS %q reports no nccVersion", info.Version), errNCCNotInstalled)
 	}
+	return info.NCCVersion, nil
+}
 
-	f, err := fs.Create(path)
+// ListChecks returns the NCC checks known to the cluster. Older AOS releases
+// without /v1/health_checks get an error saying so rather than a bare 404.
+func (c *NCCClient) ListChecks(ctx context.Context) ([]HealthCheck, error) {
+	url := c.baseURL + "/v1/health_checks"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("create %s: %w", path, err)
+		return nil, err
 	}
-	defer f.Close()
-	t := template.Must(template.New("index").Parse(tmpl))
-	if err := t.Execute(f, data); err != nil {
-		return fmt.Errorf("template execute %s: %w", path, err)
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.user, c.pass)
+	setRequestID(req, c.requestID)
+	setUserAgent(req, c.cfg.UserAgent)
+
+	_, body, err := doWithRetry(ctx, c.http, req, c.cfg, "list checks")
+	if err != nil {
+		var he *HTTPError
+		if errors.As(err, &he) && (he.StatusCode == http.StatusNotFound || he.StatusCode == http.StatusNotImplemented) {
+			return nil, NewNCCError(ErrorTypeHTTP, fmt.Sprintf("listing checks is not supported by this cluster's AOS version (GET %s returned %d)", url, he.StatusCode), err)
+		}
+		log.Error().Err(err).Str("url", url).Msg("http do error")
+		return nil, err
 	}
-	log.Info().Str("file", abs).Int("rows", len(rows)).Int("clusters", len(perCluster)).Msg("aggregated HTML generated")
-	return nil
+
+	// Most releases return a bare array; accept the paged envelope as well.
+	var checks []HealthCheck
+	if err := json.Unmarshal(body, &checks); err != nil {
+		var paged struct {
+			Entities []HealthCheck `json:"entities"`
+		}
+		if err2 := json.Unmarshal(body, &paged); err2 != nil {
+			return nil, fmt.Errorf("decode health checks: %w", err)
+		}
+		checks = paged.Entities
+	}
+	return checks, nil
 }
 
-/************** Retryable HTTP wrappers **************/
+/************** Prism Central **************/
+
+// PCClient discovers the clusters registered with a Prism Central so they
+// can be checked without listing them by hand. NCC itself still runs against
+// each Prism Element.
+//
+// API paths used:
+//
+//	POST /api/nutanix/v3/clusters/list  {"kind":"cluster","offset":N,"length":M}
+type PCClient struct {
+	baseURL   string
+	user      string
+	pass      string
+	http      HTTPClient
+	cfg       Config
+	requestID string
+}
 
-func doWithRetry(ctx context.Context, client HTTPClient, req *http.Request, cfg Config, op string) (*http.Response, []byte, error) {
-	attempts := cfg.RetryMaxAttempts
-	if attempts < 1 {
-		attempts = 1
+func NewPCClient(host, user, pass string, httpc HTTPClient, cfg Config) *PCClient {
+	return &PCClient{
+		baseURL:   fmt.Sprintf("https://%s/api/nutanix/v3", hostPort(host)),
+		user:      user,
+		pass:      pass,
+		http:      httpc,
+		cfg:       cfg,
+		requestID: cfg.RunID,
 	}
-	var lastErr error
-	var resp *http.Response
-	var body []byte
+}
 
-	// Snapshot original body if present
-	var origBody []byte
-	var hasBody bool
-	if req.Body != nil {
-		b, err := io.ReadAll(req.Body)
-		if err != nil {
-			return nil, nil, err
-		}
-		_ = req.Body.Close()
-		origBody = b
-		hasBody = true
-		req.Body = io.NopCloser(bytes.NewReader(origBody))
-	}
+type pcClusterList struct {
+	Metadata struct {
+		TotalMatches int `json:"total_matches"`
+	} `json:"metadata"`
+	Entities []struct {
+		Spec struct {
+			Name string `json:"name"`
+		} `json:"spec"`
+		Status struct {
+			Resources struct {
+				Config struct {
+					ServiceList []string `json:"service_list"`
+				} `json:"config"`
+				Network struct {
+					ExternalIP string `json:"external_ip"`
+				} `json:"network"`
+			} `json:"resources"`
+		} `json:"status"`
+	} `json:"entities"`
+}
 
-	for attempt := 1; attempt <= attempts; attempt++ {
-		reqCtx, cancel := context.WithTimeout(ctx, cfg.RequestTimeout)
-		reqClone := req.Clone(reqCtx)
-		if hasBody {
-			reqClone.Body = io.NopCloser(bytes.NewReader(origBody))
+const pcPageSize = 100
+
+// pcOriginLabel is the cluster label naming the Prism Central a cluster
+// was discovered from.
+const pcOriginLabel = "prism_central"
+
+// discoverPCClusters lists the clusters of every --prism-central in order,
+// in the same canonical form as --clusters (e.g. compressed IPv6); names
+// that are not addresses are skipped. A cluster registered with several PCs
+// is kept once, under the first. origin maps each cluster to that PC.
+func discoverPCClusters(ctx context.Context, cfg Config, httpc HTTPClient) (clusters []string, origin map[string]string, err error) {
+	origin = map[string]string{}
+	for _, pc := range cfg.PrismCentrals {
+		pcCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		discovered, err := NewPCClient(pc, cfg.Username, cfg.Password, httpc, cfg).ListClusters(pcCtx)
+		cancel()
+		if err != nil {
+			return nil, nil, fmt.Errorf("list clusters from prism central %s: %w", pc, err)
 		}
-
-		resp, lastErr = client.Do(reqClone)
-		if lastErr != nil {
-			cancel()
-			if ctx.Err() != nil {
-				return nil, nil, ctx.Err()
+		log.Info().Str("prismCentral", pc).Strs("clusters", discovered).Msg("clusters discovered")
+		for _, d := range discovered {
+			host, port, err := normalizeCluster(d)
+			if err != nil {
+				log.Warn().Str("prismCentral", pc).Str("cluster", d).Err(err).Msg("skipping discovered cluster")
+				continue
 			}
-			if attempt < attempts {
-				back := jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
-				log.Warn().Str("op", op).Int("attempt", attempt).Err(lastErr).Dur("backoff", back).Msg("transport error, retrying")
-				select {
-				case <-ctx.Done():
-					return nil, nil, ctx.Err()
-				case <-time.After(back):
+			c := clusterKey(host, port)
+			if first, ok := origin[c]; ok {
+				if first != pc {
+					log.Info().Str("cluster", c).Str("prismCentral", pc).Str("keptUnder", first).Msg("cluster registered with several prism centrals, checking once")
 				}
 				continue
 			}
-			return nil, nil, lastErr
+			origin[c] = pc
+			clusters = append(clusters, c)
 		}
+	}
+	return clusters, origin, nil
+}
 
-		func() {
-			defer cancel()
-			defer resp.Body.Close()
-			var err error
-			body, err = io.ReadAll(resp.Body)
-			if err != nil {
-				lastErr = err
-			} else {
-				lastErr = nil
-			}
-		}()
-		if lastErr != nil {
-			if attempt < attempts {
-				back := jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
-				log.Warn().Str("op", op).Int("attempt", attempt).Err(lastErr).Dur("backoff", back).Msg("read body failed, retrying")
-				select {
-				case <-ctx.Done():
-					return nil, nil, ctx.Err()
-				case <-time.After(back):
-				}
-				continue
-			}
-			return resp, nil, lastErr
+// labelPCOrigin adds the prism_central label to each discovered cluster,
+// leaving any prism_central label set in cluster-labels alone.
+func labelPCOrigin(labels map[string]map[string]string, origin map[string]string) map[string]map[string]string {
+	if len(origin) == 0 {
+		return labels
+	}
+	if labels == nil {
+		labels = make(map[string]map[string]string, len(origin))
+	}
+	for c, pc := range origin {
+		if labels[c] == nil {
+			labels[c] = map[string]string{}
 		}
+		if _, ok := labels[c][pcOriginLabel]; !ok {
+			labels[c][pcOriginLabel] = pc
+		}
+	}
+	return labels
+}
 
-		status := resp.StatusCode
-		if status >= 200 && status < 300 {
-			log.Debug().Str("op", op).Int("status", status).Msg("request succeeded")
-			return resp, body, nil
+// ListClusters returns the external IP (or name, if no IP is reported) of
+// every Prism Element registered with PC, skipping PC's own entry.
+func (c *PCClient) ListClusters(ctx context.Context) ([]string, error) {
+	url := c.baseURL + "/clusters/list"
+	var out []string
+	for offset := 0; ; offset += pcPageSize {
+		payload, _ := json.Marshal(map[string]any{"kind": "cluster", "offset": offset, "length": pcPageSize})
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
 		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.SetBasicAuth(c.user, c.pass)
+		setRequestID(req, c.requestID)
+		setUserAgent(req, c.cfg.UserAgent)
 
-		retryable := isRetryableStatus(status)
-		var back time.Duration
-		if status == 429 {
-			if ra, ok := retryAfterDelay(resp); ok {
-				back = ra
-			}
+		_, body, err := doWithRetry(ctx, c.http, req, c.cfg, "list clusters")
+		if err != nil {
+			log.Error().Err(err).Str("url", url).Msg("http do error")
+			return nil, err
 		}
-		if back == 0 {
-			back = jitteredBackoff(cfg.RetryBaseDelay, cfg.RetryMaxDelay, attempt)
+		var page pcClusterList
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("decode cluster list: %w", err)
 		}
-
-		if retryable && attempt < attempts {
-			log.Warn().Str("op", op).Int("attempt", attempt).Int("status", status).Dur("backoff", back).Msg("retryable status, retrying")
-			select {
-			case <-ctx.Done():
-				return resp, body, ctx.Err()
-			case <-time.After(back):
+		for _, e := range page.Entities {
+			if slices.Contains(e.Status.Resources.Config.ServiceList, "PRISM_CENTRAL") {
+				continue
 			}
-			continue
+			addr := e.Status.Resources.Network.ExternalIP
+			if addr == "" {
+				addr = e.Spec.Name
+			}
+			if addr == "" {
+				continue
+			}
+			log.Debug().Str("name", e.Spec.Name).Str("address", addr).Msg("discovered cluster")
+			out = append(out, addr)
+		}
+		if len(page.Entities) == 0 || offset+len(page.Entities) >= page.Metadata.TotalMatches {
+			return out, nil
 		}
-
-		log.Error().Str("op", op).Int("status", status).Int("attempts", attempt).Msg("request failed, not retrying")
-		return resp, body, fmt.Errorf("%s HTTP %d", op, status)
-	}
-
-	if lastErr != nil {
-		return nil, nil, lastErr
 	}
-	return resp, body, fmt.Errorf("%s exhausted retries", op)
 }
 
-/************** NCC Client **************/
+/************** Cluster source **************/
 
-type NCCClient struct {
-	baseURL string
-	user    string
-	pass    string
-	http    HTTPClient
-	cfg     Config
-}
+// clusterSourceList accepts either a bare JSON array of clusters or an
+// object wrapping it as {"clusters": [...]}.
+type clusterSourceList []string
 
-func NewNCCClient(cluster, user, pass string, httpc HTTPClient, cfg Config) *NCCClient {
-	return &NCCClient{
-		baseURL: fmt.Sprintf("https://%s:9440/PrismGateway/services/rest", cluster),
-		user:    user,
-		pass:    pass,
-		http:    httpc,
-		cfg:     cfg,
+func (l *clusterSourceList) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var wrapped struct {
+		Clusters []string `json:"clusters"`
+	}
+	if err := json.Unmarshal(b, &wrapped); err != nil {
+		return err
 	}
+	*l = wrapped.Clusters
+	return nil
 }
 
-func (c *NCCClient) StartChecks(ctx context.Context) (string, []byte, error) {
-	url := c.baseURL + "/v1/ncc/checks"
-	payload := []byte(`{"sendEmail":false}`)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+// fetchClusterSource GETs the cluster list from --cluster-source-url and
+// normalizes every entry; entries that are not addresses are skipped.
+func fetchClusterSource(ctx context.Context, httpc HTTPClient, cfg Config) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.ClusterSourceTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.ClusterSourceURL, nil)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	req.SetBasicAuth(c.user, c.pass)
-
-	resp, body, err := doWithRetry(ctx, c.http, req, c.cfg, "start checks")
+	for k, vs := range cfg.ClusterSourceHeaders {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	setRequestID(req, cfg.RunID)
+	setUserAgent(req, cfg.UserAgent)
+	_, body, err := doWithRetry(ctx, httpc, req, cfg, "cluster source")
 	if err != nil {
-		log.Error().Err(err).Str("url", url).Str("method", "POST").Msg("http do error")
-		return "", body, err
+		return nil, err
 	}
-	_ = resp
-	log.Debug().Str("url", url).RawJSON("body", body).Msg("start checks response")
-
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		return "", body, err
+	var list clusterSourceList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, NewNCCError(ErrorTypeValidation, "decode cluster source (want a JSON array or {\"clusters\": [...]})", err)
 	}
-	uuid, _ := data["taskUuid"].(string)
-	if uuid == "" {
-		if alt, ok := data["task_uuid"].(string); ok && alt != "" {
-			uuid = alt
+	var out []string
+	for _, c := range list {
+		host, port, err := normalizeCluster(c)
+		if err != nil {
+			log.Warn().Str("cluster", c).Err(err).Msg("cluster source: skipping entry")
+			continue
 		}
+		out = append(out, clusterKey(host, port))
 	}
-	if uuid == "" {
-		return "", body, errors.New("missing taskUuid in response")
-	}
-	return uuid, body, nil
+	return out, nil
 }
 
-func (c *NCCClient) GetTask(ctx context.Context, taskID string) (TaskStatus, []byte, error) {
-	url := c.baseURL + "/v2.0/tasks/" + taskID
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return TaskStatus{}, nil, err
+// loadClusterSource fetches the cluster list and refreshes the cache file.
+// When the fetch fails, the cached list from an earlier run is used instead.
+func loadClusterSource(ctx context.Context, httpc HTTPClient, cfg Config) ([]string, error) {
+	clusters, err := fetchClusterSource(ctx, httpc, cfg)
+	if err == nil {
+		if cfg.ClusterSourceCache != "" {
+			b, _ := json.Marshal(clusters)
+			if d := filepath.Dir(cfg.ClusterSourceCache); d != "." {
+				_ = os.MkdirAll(d, 0755)
+			}
+			if werr := writeFileAtomic(OSFS{}, cfg.ClusterSourceCache, b); werr != nil {
+				log.Warn().Err(werr).Str("cache", cfg.ClusterSourceCache).Msg("cluster source: cache not written")
+			}
+		}
+		return clusters, nil
 	}
-	req.Header.Set("Accept", "application/json")
-	req.SetBasicAuth(c.user, c.pass)
-
-	resp, body, err := doWithRetry(ctx, c.http, req, c.cfg, "get task")
-	if err != nil {
-		log.Error().Err(err).Str("url", url).Msg("http do error")
-		return TaskStatus{}, body, err
+	if cfg.ClusterSourceCache == "" {
+		return nil, err
 	}
-	_ = resp
-	log.Debug().Str("url", url).RawJSON("body", body).Msg("get task response")
+	b, rerr := os.ReadFile(cfg.ClusterSourceCache)
+	if rerr != nil {
+		return nil, err
+	}
+	var cached []string
+	if jerr := json.Unmarshal(b, &cached); jerr != nil {
+		log.Warn().Err(jerr).Str("cache", cfg.ClusterSourceCache).Msg("cluster source: cache unreadable")
+		return nil, err
+	}
+	age := time.Duration(0)
+	if st, serr := os.Stat(cfg.ClusterSourceCache); serr == nil {
+		age = time.Since(st.ModTime()).Round(time.Second)
+	}
+	log.Warn().Err(err).Str("cache", cfg.ClusterSourceCache).Dur("age", age).Int("clusters", len(cached)).Msg("cluster source: fetch failed, using cached list")
+	return cached, nil
+}
 
-	var status TaskStatus
-	if err := json.Unmarshal(body, &status); err != nil {
-		return TaskStatus{}, body, err
+// scopeClusters applies the source list: it becomes the cluster list when
+// none was configured, and otherwise keeps only configured clusters that
+// are also in the source.
+func scopeClusters(configured, source []string) (kept, dropped []string) {
+	if len(configured) == 0 {
+		return source, nil
 	}
-	return status, body, nil
+	for _, c := range configured {
+		if slices.Contains(source, c) {
+			kept = append(kept, c)
+		} else {
+			dropped = append(dropped, c)
+		}
+	}
+	return kept, dropped
 }
 
-func (c *NCCClient) GetRunSummary(ctx context.Context, taskID string) (NCCSummary, []byte, error) {
-	url := c.baseURL + "/v1/ncc/" + taskID
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return NCCSummary{}, nil, err
+/************** Elasticsearch **************/
+
+// elasticBatchSize caps the documents sent in one _bulk request.
+const elasticBatchSize = 500
+
+// ElasticNotifier bulk-indexes a run's results into an Elasticsearch or
+// OpenSearch index. Failures are the caller's to log; they never fail a run.
+type ElasticNotifier struct {
+	url    string // _bulk endpoint
+	index  string
+	user   string
+	pass   string
+	apiKey string
+	http   HTTPClient
+	cfg    Config
+}
+
+func NewElasticNotifier(cfg Config, httpc HTTPClient) *ElasticNotifier {
+	return &ElasticNotifier{
+		url:    strings.TrimRight(cfg.ElasticURL, "/") + "/_bulk",
+		index:  cfg.ElasticIndex,
+		user:   cfg.ElasticUsername,
+		pass:   cfg.ElasticPassword,
+		apiKey: cfg.ElasticAPIKey,
+		http:   httpc,
+		cfg:    cfg,
 	}
-	req.Header.Set("Accept", "application/json")
-	req.SetBasicAuth(c.user, c.pass)
+}
 
-	resp, body, err := doWithRetry(ctx, c.http, req, c.cfg, "get summary")
-	if err != nil {
-		log.Error().Err(err).Str("url", url).Msg("http do error")
-		return NCCSummary{}, body, err
+// elasticDoc is one indexed result: the aggregated row plus run metadata.
+type elasticDoc struct {
+	AggBlock
+	RunID     string            `json:"run_id"`
+	Timestamp string            `json:"@timestamp"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// elasticDocID derives a stable document ID from the run, cluster and row,
+// so re-sending a run overwrites its documents instead of duplicating them.
+// It hashes the raw check header and detail rather than CheckID, which
+// drops the address that tells per-node and per-host rows apart.
+func elasticDocID(runID string, r AggBlock) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{runID, r.Cluster, r.Check, r.Node, r.Detail}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// elasticBulkResponse is the part of the _bulk reply needed to spot
+// per-document failures, which come back with HTTP 200.
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Notify indexes rows in batches of elasticBatchSize through doWithRetry.
+func (n *ElasticNotifier) Notify(ctx context.Context, rows []AggBlock, now time.Time) error {
+	ts := now.UTC().Format(time.RFC3339)
+	var indexed, failed int
+	for start := 0; start < len(rows); start += elasticBatchSize {
+		batch := rows[start:min(start+elasticBatchSize, len(rows))]
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, r := range batch {
+			action := map[string]any{"index": map[string]string{"_index": n.index, "_id": elasticDocID(n.cfg.RunID, r)}}
+			if err := enc.Encode(action); err != nil {
+				return err
+			}
+			if err := enc.Encode(elasticDoc{AggBlock: r, RunID: n.cfg.RunID, Timestamp: ts, Metadata: n.cfg.TemplateVars}); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", n.url, &buf)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("Accept", "application/json")
+		switch {
+		case n.apiKey != "":
+			req.Header.Set("Authorization", "ApiKey "+n.apiKey)
+		case n.user != "":
+			req.SetBasicAuth(n.user, n.pass)
+		}
+		setRequestID(req, n.cfg.RunID)
+		setUserAgent(req, n.cfg.UserAgent)
+
+		_, body, err := doWithRetry(ctx, n.http, req, n.cfg, "elastic bulk")
+		if err != nil {
+			return err
+		}
+		var resp elasticBulkResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("decode bulk response: %w", err)
+		}
+		for _, item := range resp.Items {
+			for _, res := range item {
+				if res.Error == nil {
+					indexed++
+					continue
+				}
+				failed++
+				if failed <= 5 {
+					log.Warn().Int("status", res.Status).Str("type", res.Error.Type).Str("reason", res.Error.Reason).Msg("elastic: document rejected")
+				}
+			}
+		}
 	}
-	_ = resp
-	log.Debug().Str("url", url).RawJSON("body", body).Msg("get summary response")
+	log.Info().Str("index", n.index).Int("indexed", indexed).Int("failed", failed).Msg("elastic: results indexed")
+	if failed > 0 {
+		return fmt.Errorf("elastic: %d of %d documents rejected", failed, len(rows))
+	}
+	return nil
+}
 
-	var summary NCCSummary
-	if err := json.Unmarshal(body, &summary); err != nil {
-		return NCCSummary{}, body, err
+/************** Orchestration with bars **************/
+
+var reUnsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// sanitizeFilename turns a cluster identifier into a safe file name: path
+// separators, colons and other unsafe characters become '_', and ".." cannot
+// survive, so the result never escapes the output directory.
+func sanitizeFilename(cluster string) string {
+	s := reUnsafeFilename.ReplaceAllString(cluster, "_")
+	s = strings.ReplaceAll(s, "..", "__")
+	if s == "" || s == "." {
+		return "_"
 	}
-	return summary, body, nil
+	return s
 }
 
-/************** Orchestration with bars **************/
+// summaryUnescaper is the fallback for summaries that are escaped but not a
+// valid JSON string body (e.g. a stray raw quote or newline).
+var summaryUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t", `\"`, `"`, `\r`, "")
 
+// sanitizeSummary undoes escaping that some AOS releases leave in
+// runSummary (\n, \t, \" and doubled backslashes). The text is first decoded
+// as a JSON string body; if that fails the known escapes are replaced one by
+// one. Summaries without escape artifacts are returned unchanged.
 func sanitizeSummary(s string) string {
-	return strings.ReplaceAll(s, "\\n", "\n")
+	if !strings.Contains(s, `\n`) && !strings.Contains(s, `\t`) && !strings.Contains(s, `\"`) {
+		return s
+	}
+	var out string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &out); err == nil {
+		return out
+	}
+	return summaryUnescaper.Replace(s)
 }
 
-func writeSummary(fs FS, folder, cluster, summary string) (string, error) {
+// writeSummary writes the (already sanitized) summary to <cluster>.log, or gzipped to
+// <cluster>.log.gz when compress is set.
+func writeSummary(fs FS, folder, cluster, summary string, compress bool) (string, error) {
 	if err := fs.MkdirAll(folder, 0755); err != nil {
 		return "", err
 	}
-	outPath := filepath.Join(folder, fmt.Sprintf("%s.log", cluster))
+	outPath := filepath.Join(folder, sanitizeFilename(cluster)+".log")
+	if compress {
+		outPath += ".gz"
+	}
 	log.Debug().Str("path", outPath).Int("bytes", len(summary)).Msg("writing summary")
-	if err := fs.WriteFile(outPath, []byte(sanitizeSummary(summary)), 0644); err != nil {
+	if !compress {
+		if err := fs.WriteFile(outPath, []byte(summary), 0644); err != nil {
+			return "", err
+		}
+		return outPath, nil
+	}
+	f, err := fs.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(f)
+	_, err = io.WriteString(zw, summary)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return "", err
 	}
 	return outPath, nil
 }
 
+// readLog reads a raw or filtered log, gunzipping paths ending in .gz.
+func readLog(fs FS, path string) ([]byte, error) {
+	if !strings.HasSuffix(path, ".gz") {
+		return fs.ReadFile(path)
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("gunzip %s: %w", path, err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// findRawLog returns the raw summary for cluster in dir, plain or gzipped.
+func findRawLog(fs FS, dir, cluster string) (string, bool) {
+	base := filepath.Join(dir, sanitizeFilename(cluster)+".log")
+	for _, p := range []string{base, base + ".gz"} {
+		if _, err := fs.Stat(p); err == nil {
+			return p, true
+		}
+	}
+	return "", false
+}
+
 func filterBlocksToFile(fs FS, inputPath, outputPath string) error {
-	data, err := fs.ReadFile(inputPath)
+	data, err := readLog(fs, inputPath)
 	if err != nil {
 		return err
 	}
@@ -1678,7 +6627,14 @@ func filterBlocksToFile(fs FS, inputPath, outputPath string) error {
 		return err
 	}
 	var b strings.Builder
+	category := ""
 	for _, pb := range blocks {
+		// Reports are built by re-parsing this file, so keep the header
+		// that gives the following blocks their category.
+		if pb.Category != category && pb.Category != "" {
+			category = pb.Category
+			b.WriteString("Plugin: " + category + "\n")
+		}
 		b.WriteString(pb.CheckName)
 		b.WriteString("\n")
 		b.WriteString(pb.DetailRaw)
@@ -1700,9 +6656,26 @@ func runClusterWithBars(
 	onPct func(int),
 	setPhase func(string),
 ) ([]ParsedBlock, error) {
-	l := log.With().Str("cluster", cluster).Logger()
+	l := log.With().Str("cluster", cluster).Str("requestID", clusterRequestID(cfg.RunID, cluster)).Logger()
 	client := NewNCCClient(cluster, cfg.Username, cfg.Password, httpc, cfg)
 
+	if !cfg.SkipNCCCheck {
+		setPhase("preflight")
+		ver, err := client.NCCAvailabilityCheck(ctx)
+		if err != nil {
+			l.Error().Err(err).Msg("ncc availability check failed")
+			return nil, fmt.Errorf("ncc pre-flight failed: %w", err)
+		}
+		l.Info().Str("nccVersion", ver).Msg("ncc available")
+	}
+
+	// After pre-flight, so an unreachable cluster fails only once. Without
+	// the CVM list, redirects to the leader lose their credentials and fail
+	// with 401, which the call that hits it reports.
+	if err := client.ResolveMembers(ctx); err != nil {
+		l.Warn().Err(err).Msg("could not list cluster CVMs; redirects to other CVMs will not be authenticated")
+	}
+
 	setPhase("starting")
 	l.Info().Msg("starting NCC checks")
 	taskID, body, err := client.StartChecks(ctx)
@@ -1714,27 +6687,63 @@ func runClusterWithBars(
 	onPct(1)
 
 	last := 1
+	// --poll-timeout bounds only the NCC execution; ctx still caps the run.
+	pollCtx := ctx
+	if cfg.PollTimeout > 0 {
+		var cancelPoll context.CancelFunc
+		pollCtx, cancelPoll = context.WithTimeout(ctx, cfg.PollTimeout)
+		defer cancelPoll()
+	}
+	pollTimedOut := func() error {
+		if pollCtx.Err() == nil || ctx.Err() != nil {
+			return nil
+		}
+		l.Error().Dur("pollTimeout", cfg.PollTimeout).Int("pct", last).Msg("ncc checks did not finish before poll timeout")
+		return NewNCCError(ErrorTypeTimeout, fmt.Sprintf("poll timeout: ncc checks not finished after %s (last %d%%)", cfg.PollTimeout, last), pollCtx.Err())
+	}
+
 	setPhase("polling")
+	polls := 0
 	for {
 		select {
-		case <-ctx.Done():
+		case <-pollCtx.Done():
+			if err := pollTimedOut(); err != nil {
+				return nil, err
+			}
 			l.Error().Err(ctx.Err()).Msg("context done during polling")
 			return nil, ctx.Err()
 		case <-func() <-chan time.Time {
-			jitter := time.Duration(rand.Int63n(int64(cfg.PollJitter)))
-			return time.After(cfg.PollInterval + jitter)
+			wait := pollDelay(cfg.PollBackoff, cfg.PollInterval, cfg.PollMaxInterval, polls, last)
+			if cfg.PollJitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(cfg.PollJitter)))
+			}
+			if cfg.PollBackoff != "none" {
+				l.Debug().Dur("wait", wait).Int("polls", polls).Msg("next poll")
+			}
+			return time.After(wait)
 		}():
+			polls++
 			if dl, ok := ctx.Deadline(); ok {
 				rem := time.Until(dl)
 				if rem < 10*time.Second {
 					l.Warn().Dur("remaining", rem).Msg("cluster deadline near")
 				}
 			}
-			status, body, err := client.GetTask(ctx, taskID)
+			status, body, err := client.GetTask(pollCtx, taskID)
 			if err != nil {
+				if perr := pollTimedOut(); perr != nil {
+					return nil, perr
+				}
 				l.Error().Err(err).RawJSON("response_body", body).Msg("poll failed")
 				return nil, fmt.Errorf("poll failed: %w", err)
 			}
+			succeeded, failed := taskState(status.ProgressStatus)
+			if failed {
+				return nil, fmt.Errorf("ncc task %s", strings.ToLower(status.ProgressStatus))
+			}
+			// Older responses may omit progress_status; trust the percentage then.
+			done := succeeded || (status.ProgressStatus == "" && status.PercentageComplete >= 100)
+
 			pct := status.PercentageComplete
 			if pct < last {
 				pct = last
@@ -1742,14 +6751,17 @@ func runClusterWithBars(
 			if pct > 100 {
 				pct = 100
 			}
+			// Prism can report 100% while the task is still Running; hold the bar
+			// below 100 until the task is terminal.
+			if pct >= 100 && !done {
+				pct = 99
+				l.Debug().Str("progress", status.ProgressStatus).Msg("task at 100% but not terminal, continuing to poll")
+			}
 			onPct(pct)
 			l.Debug().Int("pct", pct).Str("progress", status.ProgressStatus).Msg("task status")
 			last = pct
 
-			if status.ProgressStatus == "Failed" {
-				return nil, fmt.Errorf("ncc task failed")
-			}
-			if pct >= 100 {
+			if done {
 				goto SUMMARY
 			}
 		}
@@ -1757,21 +6769,46 @@ func runClusterWithBars(
 
 SUMMARY:
 	setPhase("summary")
-	summary, body, err := client.GetRunSummary(ctx, taskID)
-	if err != nil {
-		l.Error().Err(err).RawJSON("response_body", body).Msg("get summary failed")
-		return nil, fmt.Errorf("get summary failed: %w", err)
+	rawPath := filepath.Join(cfg.OutputDirLogs, sanitizeFilename(cluster)+".summary.json")
+	defer func() { _ = fs.Remove(rawPath) }()
+	var summary NCCSummary
+	// Prism can answer 200 with an empty runSummary right after the task
+	// hits 100% while the report is still being finalized.
+	for attempt := 0; ; attempt++ {
+		var body []byte
+		summary, body, err = client.GetRunSummaryToFile(ctx, taskID, fs, rawPath)
+		if err != nil {
+			l.Error().Err(err).RawJSON("response_body", body).Msg("get summary failed")
+			return nil, fmt.Errorf("get summary failed: %w", err)
+		}
+		if strings.TrimSpace(summary.RunSummary) != "" {
+			break
+		}
+		if attempt >= cfg.SummaryRetries {
+			l.Error().Int("attempts", attempt+1).Msg("run summary still empty, giving up")
+			return nil, fmt.Errorf("get summary failed: empty run summary after %d attempts", attempt+1)
+		}
+		l.Warn().Int("attempt", attempt+1).Dur("delay", cfg.SummaryRetryDelay).Msg("run summary empty, retrying")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(cfg.SummaryRetryDelay):
+		}
 	}
 
 	setPhase("writing")
-	logPath, err := writeSummary(fs, cfg.OutputDirLogs, cluster, summary.RunSummary)
+	text := summary.RunSummary
+	if !cfg.NoSanitize {
+		text = sanitizeSummary(text)
+	}
+	logPath, err := writeSummary(fs, cfg.OutputDirLogs, cluster, text, cfg.CompressLogs)
 	if err != nil {
 		l.Error().Err(err).Msg("write summary failed")
 		return nil, err
 	}
 	l.Info().Str("logPath", logPath).Msg("summary written")
 
-	filteredPath := filepath.Join(cfg.OutputDirFiltered, fmt.Sprintf("%s.log", cluster))
+	filteredPath := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(cluster)+".log")
 	if err := filterBlocksToFile(fs, logPath, filteredPath); err != nil {
 		l.Error().Err(err).Msg("filter blocks failed")
 		return nil, err
@@ -1790,41 +6827,183 @@ SUMMARY:
 		return nil, err
 	}
 	if len(blocks) == 0 {
-		l.Warn().Str("path", filteredPath).Msg("no blocks parsed from summary")
+		if !looksLikeNCCSummary(text) {
+			l.Error().Str("path", logPath).Int("bytes", len(text)).Msg("run summary is not recognisable NCC output")
+			return nil, NewNCCError(ErrorTypeValidation, fmt.Sprintf("run summary in %s is not recognisable NCC output", logPath), nil)
+		}
+		if cfg.FailOnEmpty {
+			l.Error().Str("path", logPath).Msg("no blocks parsed (--fail-on-empty)")
+			return nil, NewNCCError(ErrorTypeValidation, fmt.Sprintf("no check blocks parsed from %s (--fail-on-empty)", logPath), nil)
+		}
+		l.Info().Str("path", filteredPath).Msg("no findings: all checks passed")
 	}
+	blocks = applyFilters(blocks, cfg)
+	blocks = overrideSeverities(blocks, cfg.SeverityOverrides, cluster)
+	blocks = redactBlocks(blocks, cfg.Redactions)
 
-	base := filteredPath
+	if err := renderOutputs(ctx, reportFS(cfg, fs), cfg, cluster, filteredPath, blocks); err != nil {
+		return nil, err
+	}
+
+	// The full output only helps with FAILs; a failed download does not fail
+	// the cluster, whose reports are already written.
+	if cfg.FetchFullLogs && slices.ContainsFunc(blocks, func(b ParsedBlock) bool { return b.Severity == "FAIL" }) {
+		setPhase("full logs")
+		fullPath := filepath.Join(cfg.OutputDirLogs, sanitizeFilename(cluster)+".ncc-full.log")
+		if err := client.FetchRunLogs(ctx, taskID, fs, fullPath); errors.Is(err, errFullLogsUnsupported) {
+			l.Info().Err(err).Msg("full NCC logs skipped")
+		} else if err != nil {
+			l.Warn().Err(err).Msg("fetch full NCC logs failed")
+		} else {
+			l.Info().Str("path", fullPath).Msg("full NCC logs written")
+		}
+	}
+
+	setPhase("done")
+	return blocks, nil
+}
+
+// renderOutputs writes the per-cluster report formats concurrently; each
+// goes to its own file. The first failure cancels formats not yet started,
+// and results are logged afterwards in --outputs order.
+func renderOutputs(ctx context.Context, out FS, cfg Config, cluster, base string, blocks []ParsedBlock) error {
+	l := log.With().Str("cluster", cluster).Logger()
+	sortBlocks(blocks, cfg.SortOrder)
+	type job struct {
+		format, file string
+		run          func() error
+	}
+	var jobs []job
 	for _, f := range cfg.OutputFormats {
-		switch strings.ToLower(strings.TrimSpace(f)) {
+		switch f {
 		case "html":
-			htmlFile := base + ".html"
-			if err := generateHTML(fs, rowsFromBlocks(blocks), htmlFile); err != nil {
-				l.Error().Err(err).Str("file", htmlFile).Msg("write HTML failed")
-				return nil, err
-			}
-			l.Info().Str("file", htmlFile).Msg("HTML generated")
+			file := base + ".html"
+			jobs = append(jobs, job{f, file, func() error {
+				if cfg.SummaryOnly {
+					return generateSummaryHTML(out, blocks, cluster, file, cfg.TemplateVars)
+				}
+				return generateHTML(out, rowsFromBlocks(blocks), file, cfg.MaxRowsPerSeverity, cfg.TemplateVars, cfg.GroupByCategory, cfg.Columns)
+			}})
 		case "csv":
-			csvFile := base + ".csv"
-			if err := generateCSV(fs, blocks, csvFile); err != nil {
-				l.Error().Err(err).Str("file", csvFile).Msg("write CSV failed")
-				return nil, err
-			}
-			l.Info().Str("file", csvFile).Msg("CSV generated")
+			file := base + ".csv"
+			jobs = append(jobs, job{f, file, func() error {
+				if cfg.SummaryOnly {
+					return generateSummaryCSV(out, blocks, file)
+				}
+				return generateCSV(out, blocks, file, cfg.Columns)
+			}})
+		case "jsonl":
+			file := base + ".jsonl"
+			jobs = append(jobs, job{f, file, func() error {
+				if cfg.SummaryOnly {
+					return generateSummaryJSONL(out, blocks, cluster, file)
+				}
+				return generateJSONL(out, blocks, cluster, file)
+			}})
 		default:
 			l.Warn().Str("format", f).Msg("unknown output format")
 		}
 	}
 
-	setPhase("done")
-	return blocks, nil
+	errs := make([]error, len(jobs))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, j := range jobs {
+		g.Go(func() (err error) {
+			// errgroup goroutines are outside the cluster goroutine's recover
+			defer func() {
+				if r := recover(); r != nil {
+					l.Error().Interface("panic", r).Stack().Str("format", j.format).Msg("render panic")
+					err = fmt.Errorf("panic rendering %s: %v", j.format, r)
+					errs[i] = err
+				}
+			}()
+			if err := gctx.Err(); err != nil {
+				errs[i] = err
+				return err
+			}
+			errs[i] = j.run()
+			return errs[i]
+		})
+	}
+	err := g.Wait()
+	for i, j := range jobs {
+		switch {
+		case errs[i] == nil:
+			l.Info().Str("format", j.format).Str("file", j.file).Msg("output generated")
+		case errors.Is(errs[i], context.Canceled):
+			l.Warn().Str("format", j.format).Str("file", j.file).Msg("output skipped after earlier failure")
+		default:
+			l.Error().Err(errs[i]).Str("format", j.format).Str("file", j.file).Msg("write output failed")
+		}
+	}
+	return err
 }
 
 /************** CLI **************/
 
 type ClusterResult struct {
-	Cluster string
-	Blocks  []ParsedBlock
-	Err     error
+	Cluster   string
+	Blocks    []ParsedBlock
+	Err       error
+	Phases    map[string]time.Duration // wall-clock time spent per phase
+	Aborted   bool                     // cut off by --max-total-runtime or --fail-fast
+	Reachable bool                     // Prism answered: pre-flight passed or a request got an HTTP status
+	Started   bool                     // the NCC task was created
+	Duration  time.Duration            // wall-clock time from dispatch to result
+	Cached    bool                     // reused from --min-recheck-interval, NCC not run
+}
+
+// abortedError is the error recorded for clusters cut off (or never
+// started) once the run context is done; its type and message come from
+// the cancel cause (--max-total-runtime or --fail-fast).
+func abortedError(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	return NewNCCError(errorType(cause), "aborted: "+cause.Error(), err)
+}
+
+// phaseClock records the wall-clock time spent in each cluster phase.
+type phaseClock struct {
+	current string
+	started time.Time
+	spent   map[string]time.Duration
+}
+
+func newPhaseClock() *phaseClock {
+	return &phaseClock{spent: map[string]time.Duration{}}
+}
+
+// enter closes the running phase and starts the named one; "" just stops.
+func (c *phaseClock) enter(phase string) {
+	now := time.Now()
+	if c.current != "" {
+		c.spent[c.current] += now.Sub(c.started)
+	}
+	c.current = phase
+	c.started = now
+}
+
+func (c *phaseClock) stop() map[string]time.Duration {
+	c.enter("")
+	return c.spent
+}
+
+func phaseSeconds(phases map[string]time.Duration) map[string]float64 {
+	if len(phases) == 0 {
+		return nil
+	}
+	out := make(map[string]float64, len(phases))
+	for k, v := range phases {
+		out[k] = v.Seconds()
+	}
+	return out
+}
+
+func phaseDict(phases map[string]time.Duration) *zerolog.Event {
+	d := zerolog.Dict()
+	for k, v := range phases {
+		d = d.Dur(k, v)
+	}
+	return d
 }
 
 type proxyDecorator struct{ text string }
@@ -1835,16 +7014,468 @@ func (p *proxyDecorator) GetConf() decor.WC                 { return decor.WC{}
 func (p *proxyDecorator) SetConf(wc decor.WC)               {}
 func (p *proxyDecorator) SetText(s string)                  { p.text = s }
 
-func promptPasswordIfEmpty(p string, Username string) (string, error) {
-	if p != "" {
+/************** Progress **************/
+
+// clusterProgress receives progress updates for one cluster.
+type clusterProgress interface {
+	SetPct(pct int)
+	SetPhase(phase string)
+	Finish(ok bool)
+}
+
+// progressSink hands out a clusterProgress per cluster. Wait flushes the
+// final frame and stops any renderer once every cluster has finished; runBatch
+// calls it so a --watch iteration never leaves a live renderer behind for the
+// next one to draw over.
+type progressSink interface {
+	Add(cluster string) clusterProgress
+	Wait()
+}
+
+// newProgressSink resolves --progress; "auto" picks bars on a terminal and
+// JSON lines otherwise.
+func newProgressSink(mode string, w io.Writer) progressSink {
+	if mode == "" || mode == "auto" {
+		mode = "json"
+		if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			mode = "bars"
+		}
+	}
+	switch mode {
+	case "bars":
+		return barSink{p: mpb.New(mpb.WithWidth(80), mpb.WithOutput(w))}
+	case "json":
+		return &jsonSink{w: w}
+	default:
+		return noneSink{}
+	}
+}
+
+type barSink struct{ p *mpb.Progress }
+
+// Wait returns once every bar is complete; all runBatch paths call Finish.
+func (s barSink) Wait() { s.p.Wait() }
+
+func (s barSink) Add(cluster string) clusterProgress {
+	mainBar := s.p.New(
+		100,
+		mpb.BarStyle().Rbound("|"),
+		mpb.PrependDecorators(
+			decor.Name(fmt.Sprintf("%-18s", cluster), decor.WC{W: 20, C: decor.DidentRight}),
+		),
+		mpb.AppendDecorators(
+			decor.Percentage(decor.WC{W: 4}),
+			decor.Name(" • "),
+			decor.Elapsed(decor.ET_STYLE_GO, decor.WC{W: 4}),
+		),
+	)
+
+	phaseProxy := &proxyDecorator{text: "starting"}
+
+	phaseBar := s.p.New(
+		1,
+		mpb.NopStyle(),
+		mpb.PrependDecorators(decor.Name(strings.Repeat(" ", 20))),
+		mpb.AppendDecorators(phaseProxy),
+	)
+	return &barProgress{main: mainBar, phase: phaseProxy, phaseBar: phaseBar}
+}
+
+type barProgress struct {
+	main     *mpb.Bar
+	phase    *proxyDecorator
+	phaseBar *mpb.Bar
+}
+
+func (b *barProgress) SetPct(pct int)        { b.main.SetCurrent(int64(pct)) }
+func (b *barProgress) SetPhase(phase string) { b.phase.SetText(phase) }
+func (b *barProgress) Finish(ok bool) {
+	if ok {
+		b.main.SetCurrent(100)
+		b.main.SetTotal(100, true)
+	} else {
+		b.main.Abort(false)
+		b.main.SetTotal(b.main.Current(), true)
+	}
+	b.phaseBar.SetCurrent(1)     // Set current to match total
+	b.phaseBar.SetTotal(1, true) // Complete phaseBar
+}
+
+// jsonSink writes one JSON object per progress change, for CI logs.
+type jsonSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *jsonSink) Wait() {}
+
+func (s *jsonSink) Add(cluster string) clusterProgress {
+	return &jsonProgress{sink: s, cluster: cluster, phase: "starting"}
+}
+
+func (s *jsonSink) emit(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(s.w, string(b))
+}
+
+type jsonProgress struct {
+	sink    *jsonSink
+	cluster string
+	phase   string
+	pct     int
+}
+
+type progressLine struct {
+	Time    string `json:"time"`
+	Cluster string `json:"cluster"`
+	Phase   string `json:"phase"`
+	Pct     int    `json:"pct"`
+}
+
+func (j *jsonProgress) line() progressLine {
+	return progressLine{Time: time.Now().Format(time.RFC3339), Cluster: j.cluster, Phase: j.phase, Pct: j.pct}
+}
+
+func (j *jsonProgress) SetPct(pct int) {
+	if pct == j.pct {
+		return
+	}
+	j.pct = pct
+	j.sink.emit(j.line())
+}
+
+func (j *jsonProgress) SetPhase(phase string) {
+	if phase == j.phase {
+		return
+	}
+	j.phase = phase
+	j.sink.emit(j.line())
+}
+
+func (j *jsonProgress) Finish(ok bool) {
+	if ok {
+		j.SetPct(100)
+	}
+}
+
+type noneSink struct{}
+
+func (noneSink) Add(string) clusterProgress { return noneProgress{} }
+func (noneSink) Wait()                      {}
+
+type noneProgress struct{}
+
+func (noneProgress) SetPct(int)      {}
+func (noneProgress) SetPhase(string) {}
+func (noneProgress) Finish(bool)     {}
+
+/************** Keyring **************/
+
+// The OS keychain is reached through go-keyring: the macOS Keychain, the
+// Secret Service (GNOME Keyring, KWallet) over D-Bus on Linux and the
+// Windows Credential Manager. Anywhere else keyring calls return
+// errKeyringUnavailable.
+const keyringService = "ncc-orchestrator"
+
+var (
+	errKeyringUnavailable = errors.New("keyring unavailable")
+	errKeyringNotFound    = errors.New("password not found in keyring")
+)
+
+// keyringAccount keys the stored secret by username and cluster group
+// (the sorted Prism Central hosts, or the sorted cluster list).
+func keyringAccount(cfg Config) string {
+	pcs := slices.Clone(cfg.PrismCentrals)
+	sort.Strings(pcs)
+	group := strings.Join(pcs, ",")
+	if group == "" {
+		cl := slices.Clone(cfg.Clusters)
+		sort.Strings(cl)
+		group = strings.Join(cl, ",")
+	}
+	return cfg.Username + "@" + group
+}
+
+// keyringErr maps go-keyring errors onto errKeyringUnavailable and
+// errKeyringNotFound, keeping the backend's message.
+func keyringErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, keyring.ErrNotFound):
+		return errKeyringNotFound
+	case errors.Is(err, keyring.ErrUnsupportedPlatform):
+		return errKeyringUnavailable
+	default:
+		return fmt.Errorf("%w: %v", errKeyringUnavailable, err)
+	}
+}
+
+func keyringGet(account string) (string, error) {
+	secret, err := keyring.Get(keyringService, account)
+	if err != nil {
+		return "", keyringErr(err)
+	}
+	if secret == "" {
+		return "", errKeyringNotFound
+	}
+	return secret, nil
+}
+
+func keyringSet(account, secret string) error {
+	return keyringErr(keyring.Set(keyringService, account, secret))
+}
+
+func keyringDelete(account string) error {
+	return keyringErr(keyring.Delete(keyringService, account))
+}
+
+// promptPasswordIfEmpty resolves the password with precedence: explicit
+// --password > --password-file > NCC_PASSWORD (or config) > --password-stdin
+// > OS keyring (--use-keyring) > interactive prompt. explicit reports whether
+// --password was passed. With --use-keyring a prompted password is saved.
+func promptPasswordIfEmpty(cfg Config, explicit bool) (string, error) {
+	if explicit && cfg.Password != "" {
+		return cfg.Password, nil
+	}
+	if cfg.PasswordFile != "" {
+		b, err := os.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return "", NewNCCError(ErrorTypeConfig, "read --password-file", err)
+		}
+		p := strings.TrimSpace(string(b))
+		if p == "" {
+			return "", NewNCCError(ErrorTypeConfig, fmt.Sprintf("password file %s is empty", cfg.PasswordFile), nil)
+		}
+		log.Debug().Str("source", "password-file").Msg("password resolved")
+		return p, nil
+	}
+	if cfg.Password != "" {
+		return cfg.Password, nil
+	}
+	if cfg.PasswordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("read password from stdin: %w", err)
+		}
+		p := strings.TrimSpace(line)
+		if p == "" {
+			return "", NewNCCError(ErrorTypeConfig, "no password on stdin", nil)
+		}
+		log.Debug().Str("source", "stdin").Msg("password resolved")
 		return p, nil
 	}
-	fmt.Printf("Prism Password (%s): ", Username)
-	bytePw, err := term.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", err
+	account := keyringAccount(cfg)
+	if cfg.UseKeyring {
+		p, err := keyringGet(account)
+		if err == nil {
+			log.Debug().Str("source", "keyring").Str("account", account).Msg("password resolved")
+			return p, nil
+		}
+		log.Info().Err(err).Str("account", account).Msg("keyring lookup failed, prompting")
+	}
+	fmt.Fprintf(os.Stderr, "Prism Password (%s): ", cfg.Username)
+	bytePw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSpace(string(bytePw))
+	if cfg.UseKeyring && p != "" {
+		if err := keyringSet(account, p); err != nil {
+			log.Warn().Err(err).Str("account", account).Msg("could not save password to keyring")
+		} else {
+			log.Info().Str("account", account).Msg("password saved to keyring")
+		}
+	}
+	return p, nil
+}
+
+// dedupeClusters drops repeated cluster entries and rejects distinct entries
+// that would write to the same output file (compared case-insensitively, as
+// on macOS/Windows filesystems).
+func dedupeClusters(clusters []string) ([]string, error) {
+	seen := map[string]bool{}
+	byFile := map[string][]string{}
+	var order []string
+	out := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		if seen[c] {
+			log.Warn().Str("cluster", c).Msg("duplicate cluster entry ignored")
+			continue
+		}
+		seen[c] = true
+		out = append(out, c)
+		key := strings.ToLower(sanitizeFilename(c) + ".log")
+		if _, ok := byFile[key]; !ok {
+			order = append(order, key)
+		}
+		byFile[key] = append(byFile[key], c)
+	}
+	var collisions []string
+	for _, key := range order {
+		if names := byFile[key]; len(names) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s <- %s", key, strings.Join(names, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		return nil, NewNCCError(ErrorTypeValidation, "clusters map to the same output file: "+strings.Join(collisions, "; "), nil)
+	}
+	return out, nil
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// errSelectAborted is returned when the operator quits the --select list.
+var errSelectAborted = errors.New("cluster selection aborted")
+
+// selectClusters shows a checkbox list of clusters on the terminal and
+// returns the ones picked: arrows or j/k move, space toggles, a toggles all,
+// enter confirms, q/Esc/Ctrl-C abort. The terminal is in raw mode meanwhile,
+// so Ctrl-C arrives as a key rather than SIGINT.
+func selectClusters(clusters []string, in *os.File, out io.Writer) ([]string, error) {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, NewNCCError(ErrorTypeConfig, "--select needs an interactive terminal", nil)
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	view := len(clusters)
+	if _, h, err := term.GetSize(fd); err == nil && h > 4 && h-3 < view {
+		view = h - 3
+	}
+	picked := make([]bool, len(clusters))
+	cursor, top, drawn := 0, 0, 0
+	draw := func() {
+		if drawn > 0 {
+			fmt.Fprintf(out, "\x1b[%dA", drawn)
+		}
+		if cursor < top {
+			top = cursor
+		} else if cursor >= top+view {
+			top = cursor - view + 1
+		}
+		n := 0
+		for _, p := range picked {
+			if p {
+				n++
+			}
+		}
+		fmt.Fprintf(out, "\r\x1b[KSelect clusters (%d/%d): space toggle, a all, enter run, q abort\r\n", n, len(clusters))
+		for i := top; i < top+view; i++ {
+			mark, box := " ", "[ ]"
+			if i == cursor {
+				mark = ">"
+			}
+			if picked[i] {
+				box = "[x]"
+			}
+			fmt.Fprintf(out, "\r\x1b[K%s %s %s\r\n", mark, box, clusters[i])
+		}
+		drawn = view + 1
+	}
+
+	r := bufio.NewReader(in)
+	for {
+		draw()
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case 3, 'q': // Ctrl-C
+			return nil, errSelectAborted
+		case 27: // Esc, or the start of an arrow key sequence
+			if r.Buffered() == 0 {
+				return nil, errSelectAborted
+			}
+			if next, _ := r.ReadByte(); next != '[' {
+				continue
+			}
+			switch key, _ := r.ReadByte(); key {
+			case 'A':
+				cursor = max(cursor-1, 0)
+			case 'B':
+				cursor = min(cursor+1, len(clusters)-1)
+			}
+		case 'k':
+			cursor = max(cursor-1, 0)
+		case 'j':
+			cursor = min(cursor+1, len(clusters)-1)
+		case ' ':
+			picked[cursor] = !picked[cursor]
+		case 'a':
+			all := !slices.Contains(picked, false)
+			for i := range picked {
+				picked[i] = !all
+			}
+		case '\r', '\n':
+			var sel []string
+			for i, c := range clusters {
+				if picked[i] {
+					sel = append(sel, c)
+				}
+			}
+			if len(sel) == 0 {
+				continue
+			}
+			return sel, nil
+		}
+	}
+}
+
+// checkOutputStdout repeats the --output-stdout single-cluster check once the
+// final cluster list is known. bindConfig only sees --clusters; Prism Central
+// discovery, --cluster-source and --select can all change the count after it.
+func checkOutputStdout(cfg Config) error {
+	if cfg.OutputStdout && len(cfg.Clusters) > 1 {
+		return NewNCCError(ErrorTypeConfig, fmt.Sprintf("--output-stdout requires a single cluster, %d resolved", len(cfg.Clusters)), nil)
+	}
+	return nil
+}
+
+// checkClusterCap enforces --max-clusters unless --yes is given or the user
+// confirms interactively.
+func checkClusterCap(cfg Config) error {
+	n := len(cfg.Clusters)
+	if cfg.MaxClusters <= 0 || n <= cfg.MaxClusters {
+		return nil
+	}
+	log.Warn().Int("clusters", n).Int("maxClusters", cfg.MaxClusters).Bool("assumeYes", cfg.AssumeYes).Msg("cluster count exceeds cap")
+	if cfg.AssumeYes {
+		return nil
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		ok, err := confirm(fmt.Sprintf("About to run NCC on %d clusters (cap %d). Continue?", n, cfg.MaxClusters))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
 	}
-	return strings.TrimSpace(string(bytePw)), nil
+	return NewNCCError(ErrorTypeValidation, fmt.Sprintf("%d clusters exceeds --max-clusters %d; pass --yes to proceed", n, cfg.MaxClusters), nil)
 }
 
 var (
@@ -1882,6 +7513,617 @@ func init() {
 	}
 }
 
+/************** Completion hook **************/
+
+// onCompleteOutputMax caps how much of the --on-complete output is logged;
+// the tail is kept.
+const onCompleteOutputMax = 16 << 10
+
+// onCompleteEnv is what --on-complete sees on top of the process
+// environment: cluster and per-severity row counts, the report directory
+// and the run ID.
+func onCompleteEnv(cfg Config, clusters, failed int, agg []AggBlock) []string {
+	counts := countSeverities(agg, func(r AggBlock) string { return r.Severity })
+	env := []string{
+		"NCC_RUN_ID=" + cfg.RunID,
+		"NCC_OUTPUT_DIR=" + cfg.OutputDirFiltered,
+		"NCC_TOTAL=" + strconv.Itoa(clusters),
+		"NCC_SUCCEEDED_COUNT=" + strconv.Itoa(clusters-failed),
+		"NCC_FAILED_COUNT=" + strconv.Itoa(failed),
+	}
+	for _, sev := range severityOrder {
+		env = append(env, "NCC_"+sev+"_COUNT="+strconv.Itoa(counts[sev]))
+	}
+	return env
+}
+
+// runOnComplete runs the --on-complete command through the shell and logs
+// its combined output. ctx is the run's notify context, so an interrupt
+// kills the command. A non-zero exit is returned only with
+// --on-complete-strict.
+func runOnComplete(ctx context.Context, cfg Config, clusters, failed int, agg []AggBlock) error {
+	if cfg.OnComplete == "" {
+		return nil
+	}
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	c := exec.CommandContext(ctx, shell, flag, cfg.OnComplete)
+	c.Env = append(os.Environ(), onCompleteEnv(cfg, clusters, failed, agg)...)
+	start := time.Now()
+	out, err := c.CombinedOutput()
+	if len(out) > onCompleteOutputMax {
+		out = out[len(out)-onCompleteOutputMax:]
+	}
+	ev := log.Info()
+	if err != nil {
+		ev = log.Warn().Err(err)
+	}
+	ev.Str("command", cfg.OnComplete).Str("output", strings.TrimSpace(string(out))).Dur("elapsed", time.Since(start)).Msg("on-complete command finished")
+	if err != nil && cfg.OnCompleteStrict {
+		return NewNCCError(ErrorTypeUnknown, "on-complete command failed", err)
+	}
+	return nil
+}
+
+/************** Recheck cache **************/
+
+// recheckEntry is a cluster's last successful run for --min-recheck-interval.
+type recheckEntry struct {
+	Time     time.Time `json:"time"`
+	Filtered string    `json:"filtered"` // filtered log the reports were built from
+}
+
+// recheckCache maps cluster to its last successful run.
+type recheckCache map[string]recheckEntry
+
+// loadRecheckCache reads the cache at path, dropping entries older than ttl.
+// A missing or unreadable cache is empty: every cluster is run.
+func loadRecheckCache(path string, ttl time.Duration, now time.Time) recheckCache {
+	c := recheckCache{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("cache", path).Msg("recheck cache: not readable, running every cluster")
+		}
+		return c
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		log.Warn().Err(err).Str("cache", path).Msg("recheck cache: not valid JSON, running every cluster")
+		return recheckCache{}
+	}
+	for cl, e := range c {
+		if now.Sub(e.Time) > ttl {
+			delete(c, cl)
+		}
+	}
+	return c
+}
+
+// fresh returns cluster's entry when its run is younger than interval and
+// its filtered log still exists on fs.
+func (c recheckCache) fresh(fs FS, cluster string, interval time.Duration, now time.Time) (recheckEntry, bool) {
+	e, ok := c[cluster]
+	if !ok || now.Sub(e.Time) >= interval {
+		return recheckEntry{}, false
+	}
+	if _, err := fs.Stat(e.Filtered); err != nil {
+		return recheckEntry{}, false
+	}
+	return e, true
+}
+
+func saveRecheckCache(path string, c recheckCache) error {
+	if d := filepath.Dir(path); d != "." {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(OSFS{}, path, b)
+}
+
+// reuseCachedRun rebuilds a cluster's reports from the filtered log of its
+// cached run, copying the log into this run's directory when it differs.
+// Filters, severity overrides and redaction are applied as for a live run.
+func reuseCachedRun(ctx context.Context, cfg Config, fs FS, cluster string, e recheckEntry) (ClusterResult, error) {
+	data, err := fs.ReadFile(e.Filtered)
+	if err != nil {
+		return ClusterResult{}, err
+	}
+	filteredPath := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(cluster)+".log")
+	if abs, _ := filepath.Abs(filteredPath); abs != e.Filtered {
+		if err := fs.MkdirAll(cfg.OutputDirFiltered, 0755); err != nil {
+			return ClusterResult{}, err
+		}
+		if err := writeFileAtomic(fs, filteredPath, data); err != nil {
+			return ClusterResult{}, err
+		}
+	}
+	blocks, err := ParseSummary(string(data))
+	if err != nil {
+		return ClusterResult{}, err
+	}
+	blocks = applyFilters(blocks, cfg)
+	blocks = overrideSeverities(blocks, cfg.SeverityOverrides, cluster)
+	blocks = redactBlocks(blocks, cfg.Redactions)
+	if err := renderOutputs(ctx, reportFS(cfg, fs), cfg, cluster, filteredPath, blocks); err != nil {
+		return ClusterResult{}, err
+	}
+	return ClusterResult{Cluster: cluster, Blocks: blocks, Reachable: true, Started: true, Cached: true}, nil
+}
+
+// logRunStats emits the end-of-run totals as one structured line, so log
+// alerting can key on failed or fail_count without parsing the reports.
+func logRunStats(console Console, clusters, failed int, agg []AggBlock, elapsed time.Duration) {
+	counts := make(map[string]int, len(severityOrder))
+	for _, r := range agg {
+		counts[r.Severity]++
+	}
+	ev := log.Info().
+		Int("total_clusters", clusters).
+		Int("succeeded", clusters-failed).
+		Int("failed", failed)
+	for _, sev := range severityOrder {
+		ev = ev.Int(strings.ToLower(sev)+"_count", counts[sev])
+	}
+	ev.Float64("duration_seconds", elapsed.Seconds()).Msg("run summary")
+	console.Printf("Clusters: %d total, %d succeeded, %d failed | FAIL %d, ERR %d, WARN %d, INFO %d | %s\n",
+		clusters, clusters-failed, failed, counts["FAIL"], counts["ERR"], counts["WARN"], counts["INFO"],
+		elapsed.Round(time.Second))
+}
+
+// rampSchedule spreads the launches that would otherwise all start at t=0
+// (the first min(maxParallel, clusters)) evenly over rampUp: 4 over 20s start
+// at 0s, 5s, 10s and 15s. Later clusters are paced by the semaphore anyway.
+func rampSchedule(rampUp time.Duration, maxParallel, clusters int) (slots int, step time.Duration) {
+	slots = min(maxParallel, clusters)
+	if rampUp <= 0 || slots < 2 {
+		return 0, 0
+	}
+	return slots, rampUp / time.Duration(slots)
+}
+
+// RunResult is the --json-output document printed to stdout after a run.
+type RunResult struct {
+	RunID           string           `json:"run_id"`
+	Status          string           `json:"status"` // ok or failed
+	StartedAt       string           `json:"started_at"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Clusters        []ClusterSummary `json:"clusters"`
+	FailedClusters  []string         `json:"failed_clusters"`
+	Summary         map[string]int   `json:"summary"`
+	Files           []string         `json:"files"`
+}
+
+// runResult assembles the --json-output document. files are the report
+// paths of this run; manifest and archive are added when written.
+func runResult(fs FS, cfg Config, start time.Time, summaries []ClusterSummary, agg []AggBlock) RunResult {
+	res := RunResult{
+		RunID:           cfg.RunID,
+		Status:          "ok",
+		StartedAt:       start.Format(time.RFC3339),
+		DurationSeconds: time.Since(start).Seconds(),
+		Clusters:        summaries,
+		FailedClusters:  []string{},
+		Summary:         countSeverities(agg, func(r AggBlock) string { return r.Severity }),
+	}
+	var done []string
+	for _, s := range summaries {
+		if s.Status == "ok" {
+			done = append(done, s.Cluster)
+		} else {
+			res.Status = "failed"
+			res.FailedClusters = append(res.FailedClusters, s.Cluster)
+		}
+	}
+	res.Files = reportFiles(fs, cfg, done)
+	var extra []string
+	if cfg.WriteManifest {
+		extra = append(extra, filepath.Join(cfg.OutputDirFiltered, "manifest.json"))
+	}
+	if cfg.Archive != "" {
+		extra = append(extra, cfg.Archive)
+	}
+	for _, p := range extra {
+		if st, err := fs.Stat(p); err == nil && !st.IsDir() {
+			res.Files = append(res.Files, p)
+		}
+	}
+	if res.Clusters == nil {
+		res.Clusters = []ClusterSummary{}
+	}
+	if res.Files == nil {
+		res.Files = []string{}
+	}
+	return res
+}
+
+// printRunResult writes res to w as a single line of JSON.
+func printRunResult(w io.Writer, res RunResult) {
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Error().Err(err).Msg("write json output failed")
+	}
+}
+
+// runBatch runs NCC on every configured cluster once and writes the
+// per-cluster and aggregated reports. --watch calls it once per interval.
+//
+// Concurrency model:
+//   - One goroutine per cluster, at most --max-parallel at a time. It only
+//     writes files derived from its own cluster name (<cluster>.log* in the
+//     filtered and logs directories); dedupeClusters rejects cluster lists
+//     where two names map to the same file.
+//   - Within a cluster, renderOutputs writes each format to its own file in
+//     parallel. Every report file goes through createAtomic, so a writer that
+//     fails or panics leaves the previous file in place, never a partial one.
+//   - A panic in a cluster or render goroutine is recovered and becomes that
+//     cluster's error; the batch goes on.
+//   - Results reach a single collector goroutine over a channel; only it
+//     appends to summaries, agg, clusterFiles and failed, and nothing reads
+//     them until wg.Wait and the collector have finished.
+//   - Shared files (index.html, index.json, combined.*, manifest, history,
+//     recheck cache, archive) are written once, by this goroutine, after
+//     all clusters are done.
+//   - cfg, the parser patterns and the report time settings are set before
+//     the batch starts and only read while it runs.
+func runBatch(ctx context.Context, cfg Config, fs FS, httpc HTTPClient) error {
+	start := time.Now()
+	// Keep stdout clean for the report or JSON result when either goes there
+	console := Console{W: os.Stdout, Quiet: cfg.Quiet}
+	if cfg.OutputStdout || cfg.JSONOutput {
+		console.W = os.Stderr
+	}
+	console.Println("You have accepted T&C, Check using --tc flag")
+
+	progressMode := cfg.Progress
+	if cfg.Quiet {
+		progressMode = "none"
+	}
+	progress := newProgressSink(progressMode, console.W)
+
+	// Notifiers still run after fail-fast or --max-total-runtime cancel the
+	// batch; only an interrupt stops them.
+	notifyCtx := ctx
+	if cfg.MaxTotalRuntime > 0 {
+		var cancelAll context.CancelFunc
+		ctx, cancelAll = context.WithTimeoutCause(ctx, cfg.MaxTotalRuntime,
+			NewNCCError(ErrorTypeTimeout, fmt.Sprintf("max total runtime %s reached", cfg.MaxTotalRuntime), nil))
+		defer cancelAll()
+	}
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	// failFast stops the remaining clusters after the first failure.
+	failFast := func(cl string, err error) {
+		if cfg.FailFast {
+			log.Warn().Str("cluster", cl).Msg("fail-fast: cancelling remaining clusters")
+			cancelRun(NewNCCError(errorType(err), fmt.Sprintf("fail-fast after %s failed", cl), nil))
+		}
+	}
+	sem := make(chan struct{}, cfg.MaxParallel)
+	var wg sync.WaitGroup
+	// Results are consumed as they arrive, so each finished cluster's blocks
+	// are folded into the aggregate while others are still running rather
+	// than every ClusterResult being held until the end of the batch.
+	results := make(chan ClusterResult, cfg.MaxParallel)
+	var failed []*ClusterError
+	var agg []AggBlock
+	var clusterFiles []struct{ Cluster, HTML, CSV string }
+	var summaries []ClusterSummary
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for r := range results {
+			summaries = append(summaries, summarizeResult(r, cfg.ClusterLabels[r.Cluster]))
+			if r.Err != nil {
+				failed = append(failed, &ClusterError{Cluster: r.Cluster, Err: r.Err})
+				continue
+			}
+			for _, b := range r.Blocks {
+				agg = append(agg, newAggBlock(r.Cluster, b, cfg.ClusterLabels[r.Cluster]))
+			}
+			basePath := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(r.Cluster)+".log")
+			htmlPath := basePath + ".html"
+			csvPath := basePath + ".csv"
+			clusterFiles = append(clusterFiles, struct{ Cluster, HTML, CSV string }{
+				Cluster: r.Cluster,
+				HTML:    filepath.Base(htmlPath),
+				CSV:     filepath.Base(csvPath),
+			})
+		}
+	}()
+
+	var recheck recheckCache
+	if cfg.MinRecheckInterval > 0 {
+		recheck = loadRecheckCache(cfg.RecheckCache, cfg.RecheckCacheTTL, start)
+	}
+
+	rampSlots, rampStep := rampSchedule(cfg.RampUp, cfg.MaxParallel, len(cfg.Clusters))
+	if rampSlots > 0 {
+		log.Info().Dur("rampUp", cfg.RampUp).Dur("step", rampStep).Int("clusters", rampSlots).Msg("staggering cluster starts")
+	}
+	for i, cluster := range cfg.Clusters {
+		if e, ok := recheck.fresh(fs, cluster, cfg.MinRecheckInterval, time.Now()); ok && ctx.Err() == nil {
+			r, err := reuseCachedRun(ctx, cfg, fs, cluster, e)
+			if err == nil {
+				log.Info().Str("cluster", cluster).Time("lastRun", e.Time).Msg("recheck cache: reusing last run")
+				results <- r
+				continue
+			}
+			log.Warn().Str("cluster", cluster).Err(err).Msg("recheck cache: reuse failed, running cluster")
+		}
+		if i > 0 && i < rampSlots && ctx.Err() == nil {
+			t := time.NewTimer(time.Until(start.Add(time.Duration(i) * rampStep)))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+			}
+		}
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			log.Warn().Str("cluster", cluster).Err(context.Cause(ctx)).Msg("run stopped before cluster started")
+			results <- ClusterResult{Cluster: cluster, Err: abortedError(ctx, ctx.Err()), Aborted: true}
+			continue
+		}
+		wg.Add(1)
+
+		prog := progress.Add(cluster)
+
+		go func(cl string, prog clusterProgress) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			clock := newPhaseClock()
+			dispatched := time.Now()
+			var reachable, started bool
+			send := func(r ClusterResult) {
+				var he *HTTPError
+				r.Reachable = reachable || started || errors.As(r.Err, &he) || errors.Is(r.Err, errNCCNotInstalled)
+				r.Started = started
+				r.Duration = time.Since(dispatched)
+				results <- r
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					prog.Finish(false)
+					log.Error().Interface("panic", r).Stack().Str("cluster", cl).Msg("cluster goroutine panic")
+					err := fmt.Errorf("panic: %v", r)
+					failFast(cl, err)
+					send(ClusterResult{Cluster: cl, Blocks: nil, Err: err, Phases: clock.stop()})
+				}
+			}()
+
+			timeout := clusterTimeout(cfg, cl)
+			_, override := cfg.ClusterTimeouts[cl]
+			log.Info().Str("cluster", cl).Dur("timeout", timeout).Bool("override", override).Msg("cluster timeout")
+			reqCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			onPct := prog.SetPct
+			setPhase := func(text string) {
+				prog.SetPhase(text)
+				log.Info().Str("cluster", cl).Str("phase", text).Msg("phase change")
+			}
+			trackPhase := func(text string) {
+				switch text {
+				case "starting":
+					reachable = !cfg.SkipNCCCheck // pre-flight passed
+				case "polling":
+					started = true
+				}
+				if text == "done" {
+					clock.enter("")
+				} else {
+					clock.enter(text)
+				}
+				setPhase(text)
+			}
+
+			blocks, err := runClusterWithBars(reqCtx, cfg, fs, httpc, cl, onPct, trackPhase)
+			active := clock.current
+			phases := clock.stop()
+			if err != nil {
+				if ctx.Err() != nil {
+					setPhase("aborted")
+					prog.Finish(false)
+					log.Warn().Str("cluster", cl).Str("phase", active).Err(context.Cause(ctx)).Dict("phases", phaseDict(phases)).Msg("cluster aborted")
+					send(ClusterResult{Cluster: cl, Err: abortedError(ctx, err), Phases: phases, Aborted: true})
+					return
+				}
+				if errors.Is(err, context.DeadlineExceeded) && active != "" && reqCtx.Err() != nil {
+					err = NewNCCError(ErrorTypeTimeout, fmt.Sprintf("timed out after %s during %s phase", timeout, active), err)
+				}
+				setPhase("failed")
+				prog.Finish(false)
+				log.Error().Str("cluster", cl).Err(err).Dict("phases", phaseDict(phases)).Msg("cluster run failed")
+				failFast(cl, err)
+				send(ClusterResult{Cluster: cl, Blocks: nil, Err: err, Phases: phases})
+				return
+			}
+
+			setPhase("done")
+			prog.Finish(true)
+			log.Info().Str("cluster", cl).Dict("phases", phaseDict(phases)).Msg("cluster run completed")
+			send(ClusterResult{Cluster: cl, Blocks: blocks, Err: nil, Phases: phases})
+		}(cluster, prog)
+	}
+
+	// Wait for workers, then for the collector to drain results
+	wg.Wait()
+	close(results)
+	<-collected
+
+	// Remember clusters that ran live and succeeded, for --min-recheck-interval
+	if recheck != nil {
+		now := time.Now()
+		for _, sum := range summaries {
+			if sum.Status != "ok" || sum.Cached {
+				continue
+			}
+			filtered, _ := filepath.Abs(filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(sum.Cluster)+".log"))
+			recheck[sum.Cluster] = recheckEntry{Time: now, Filtered: filtered}
+		}
+		if err := saveRecheckCache(cfg.RecheckCache, recheck); err != nil {
+			log.Warn().Err(err).Str("cache", cfg.RecheckCache).Msg("recheck cache: not written")
+		}
+	}
+
+	// Results arrive in completion order; put everything back in configured
+	// order so unchanged results give byte-identical reports. Each cluster's
+	// rows keep their parse order, which "original" relies on.
+	rank := clusterRank(cfg.Clusters)
+	slices.SortStableFunc(summaries, func(a, b ClusterSummary) int { return rank[a.Cluster] - rank[b.Cluster] })
+	slices.SortStableFunc(clusterFiles, func(a, b struct{ Cluster, HTML, CSV string }) int { return rank[a.Cluster] - rank[b.Cluster] })
+	slices.SortStableFunc(agg, func(a, b AggBlock) int { return rank[a.Cluster] - rank[b.Cluster] })
+
+	// Write aggregated page
+	sortAggRows(agg, cfg.SortOrder)
+	if err := writeAggregatedHTMLSingle(fs, cfg.OutputDirFiltered, cfg.AggregatedFilename, agg, clusterFiles, summaries, cfg.MaxRowsPerSeverity, cfg.TemplateVars, cfg.SummaryOnly, cfg.Columns); err != nil {
+		log.Error().Err(err).Msg("write aggregated HTML failed")
+	}
+	if err := writeAggregatedJSON(fs, cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename), summaries, agg, cfg.TemplateVars, cfg.SummaryOnly); err != nil {
+		log.Error().Err(err).Msg("write aggregated JSON failed")
+	}
+	if cfg.CombinedOutput {
+		if err := writeCombined(fs, cfg.OutputDirFiltered, agg, cfg.Columns); err != nil {
+			log.Error().Err(err).Msg("write combined outputs failed")
+		}
+	}
+	if cfg.WriteManifest {
+		var done []string
+		for _, cf := range clusterFiles {
+			done = append(done, cf.Cluster)
+		}
+		if err := writeManifest(fs, cfg.OutputDirFiltered, reportFiles(fs, cfg, done), cfg); err != nil {
+			log.Error().Err(err).Msg("write manifest failed")
+		}
+	}
+	if cfg.HistoryFile != "" {
+		if err := appendHistory(cfg.HistoryFile, historyRecord(cfg.RunID, time.Now(), summaries, agg), cfg.HistoryMaxSize); err != nil {
+			log.Error().Err(err).Msg("append history failed")
+		}
+	}
+	if cfg.ElasticEnabled && len(agg) > 0 {
+		if err := NewElasticNotifier(cfg, httpc).Notify(notifyCtx, agg, time.Now()); err != nil {
+			log.Error().Err(err).Str("url", cfg.ElasticURL).Msg("elastic: indexing failed")
+		}
+	}
+	if cfg.CleanStale {
+		if _, err := cleanStaleOutputs(fs, cfg.OutputDirFiltered, staleKeep(cfg)); err != nil {
+			log.Warn().Err(err).Msg("clean stale outputs failed")
+		}
+	}
+	if cfg.Archive != "" {
+		var done []string
+		for _, cf := range clusterFiles {
+			done = append(done, cf.Cluster)
+		}
+		if err := writeArchive(fs, cfg.OutputDirFiltered, cfg.Archive, reportFiles(fs, cfg, done), cfg); err != nil {
+			log.Error().Err(err).Msg("write archive failed")
+		}
+	}
+	hookErr := runOnComplete(notifyCtx, cfg, len(summaries), len(failed), agg)
+
+	// Flush progress rendering; each batch, and so each --watch iteration,
+	// starts on a fresh renderer with its bars at 0.
+	progress.Wait()
+
+	logRunStats(console, len(summaries), len(failed), agg, time.Since(start))
+	logRetryStats(retryStats.take())
+	if cfg.JSONOutput {
+		printRunResult(os.Stdout, runResult(fs, cfg, start, summaries, agg))
+	}
+
+	if len(failed) > 0 {
+		merr := &MultiError{Errors: failed}
+		types := zerolog.Dict()
+		for _, ce := range failed {
+			types = types.Str(ce.Cluster, string(errorType(ce.Err)))
+		}
+		log.Error().Strs("failedClusters", merr.Clusters()).Dict("errorTypes", types).Msg("some clusters failed")
+		return merr
+	}
+	if hookErr != nil {
+		return hookErr
+	}
+	// Only a fully successful run may push older ones out.
+	if cfg.KeepLastRuns > 0 {
+		if _, err := pruneRunDirs(fs, cfg.OutputDir, cfg.KeepLastRuns); err != nil {
+			log.Warn().Err(err).Msg("prune old runs failed")
+		}
+	}
+
+	log.Info().Msg("all clusters processed successfully")
+	console.Printf("All clusters processed successfully\n")
+	return nil
+}
+
+// watchLoop repeats runBatch every cfg.Watch until SIGINT/SIGTERM. An
+// iteration that overruns the interval is not overlapped: the ticks it
+// missed are skipped and logged. Each iteration gets its own request-ID
+// prefix and, with --timestamped-output-dir, its own output directory.
+func watchLoop(cfg Config, fs FS, httpc HTTPClient) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	base := log.Logger
+	defer func() { log.Logger = base }()
+	watchID := cfg.RunID
+	ticker := time.NewTicker(cfg.Watch)
+	defer ticker.Stop()
+
+	for i := 1; ; i++ {
+		iter := cfg
+		iter.RunID = fmt.Sprintf("%s-%d", watchID, i)
+		log.Logger = base.With().Int("iteration", i).Logger()
+		start := time.Now()
+		if i > 1 && viper.GetBool("timestamped-output-dir") {
+			if err := resolveOutputDir(&iter, start); err != nil {
+				return err
+			}
+			for _, dir := range []string{iter.OutputDirLogs, iter.OutputDirFiltered} {
+				if err := fs.MkdirAll(dir, 0755); err != nil {
+					return err
+				}
+			}
+		}
+
+		log.Info().Str("outputDir", iter.OutputDirFiltered).Msg("watch: iteration started")
+		if err := runBatch(ctx, iter, fs, httpc); err != nil {
+			log.Error().Err(err).Msg("watch: iteration finished with errors")
+		} else {
+			log.Info().Dur("elapsed", time.Since(start)).Msg("watch: iteration finished")
+		}
+		if ctx.Err() != nil {
+			log.Info().Msg("watch: interrupted, exiting")
+			return nil
+		}
+		if elapsed := time.Since(start); elapsed > cfg.Watch {
+			select {
+			case <-ticker.C: // drop the tick that fired while we were busy
+			default:
+			}
+			log.Warn().Dur("elapsed", elapsed).Dur("interval", cfg.Watch).Int("skipped", int(elapsed/cfg.Watch)).Msg("watch: iteration overran the interval, skipping missed runs")
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("watch: interrupted, exiting")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
 func newRootCmd() *cobra.Command {
 
 	cmd := &cobra.Command{
@@ -1916,9 +8158,33 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 			}
 
 			lvl := parseLogLevel(cfg.LogLevel)
-			if err := setupFileLogger(cfg.LogFile, lvl); err != nil {
+			var mirror, stdout io.Writer
+			if cfg.Verbose {
+				mirror = os.Stderr
+			}
+			if cfg.LogStdout {
+				stdout = os.Stdout
+			}
+			setColorMode(cfg.NoColor, cfg.ForceColor)
+			if err := setupFileLogger(cfg.LogFile, cfg.LogRotation, lvl, mirror, stdout); err != nil {
 				return fmt.Errorf("setup logger: %w", err)
 			}
+			cfg.RunID = newRunID()
+			log.Logger = log.With().Str("runID", cfg.RunID).Logger()
+			warnInsecure(cfg, os.Stderr)
+			if err := setBlockPatterns(cfg.BlockStartRegex, cfg.BlockEndRegex); err != nil {
+				log.Error().Err(err).Msg("invalid parser patterns")
+				return err
+			}
+			if err := setReportTime(cfg.ReportTimeFormat, cfg.ReportTimezone); err != nil {
+				log.Error().Err(err).Msg("invalid report time settings")
+				return err
+			}
+			if err := setSeverityColors(cfg.SeverityColors); err != nil {
+				log.Error().Err(err).Msg("invalid severity colors")
+				return err
+			}
+			cfg.CheckExclude = compileCheckFilter("--filter-check-exclude", cfg.FilterCheckExclude)
 			log.Info().
 				Strs("clusters", cfg.Clusters).
 				Str("username", cfg.Username).
@@ -1932,19 +8198,22 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 				Str("logsDir", cfg.OutputDirLogs).
 				Str("filteredDir", cfg.OutputDirFiltered).
 				Str("logFile", cfg.LogFile).
+				Strs("configFiles", cfg.ConfigFiles).
 				Str("logLevel", lvl.String()).
 				Bool("logHTTP", cfg.LogHTTP || os.Getenv("LOG_HTTP") == "1").
 				Int("retryMaxAttempts", cfg.RetryMaxAttempts).
 				Dur("retryBaseDelay", cfg.RetryBaseDelay).
 				Dur("retryMaxDelay", cfg.RetryMaxDelay).
+				Str("blockStartRegex", reBlockStart.String()).
+				Str("blockEndRegex", reBlockEnd.String()).
 				Msg("starting NCC orchestrator")
 
 			if tc, _ := cmd.Flags().GetBool("tc"); tc {
-				fmt.Println(termsText)
+				fmt.Print(termsText)
 				return nil
 			}
-			if len(cfg.Clusters) == 0 {
-				return errors.New("no clusters provided (--clusters, env, or config)")
+			if len(cfg.Clusters) == 0 && len(cfg.PrismCentrals) == 0 && cfg.ClusterSourceURL == "" {
+				return errors.New("no clusters provided (--clusters, --prism-central, --cluster-source-url, env, or config)")
 			}
 			if cfg.Username == "" {
 				return errors.New("missing --username or config username")
@@ -1954,23 +8223,110 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 				fmt.Println("Possible Environment Variables (prefix: NCC_) and Current Values:")
 				envKeys := []string{
 					"CLUSTERS",
+					"PRISM_CENTRAL",
+					"CLUSTER_SOURCE_URL",
+					"CLUSTER_SOURCE_HEADER",
+					"CLUSTER_SOURCE_TIMEOUT",
+					"CLUSTER_SOURCE_CACHE",
+					"MIN_RECHECK_INTERVAL",
+					"RECHECK_CACHE",
+					"RECHECK_CACHE_TTL",
 					"USERNAME",
 					"PASSWORD",
+					"PASSWORD_FILE",
+					"PASSWORD_STDIN",
+					"USE_KEYRING",
+					"KEYRING_CLEAR",
 					"INSECURE_SKIP_VERIFY",
+					"REQUIRE_SECURE",
+					"MAX_IDLE_CONNS_PER_HOST",
+					"MAX_RESPONSE_BYTES",
+					"MAX_SUMMARY_BYTES",
+					"DISABLE_HTTP2",
+					"USER_AGENT",
+					"SKIP_NCC_CHECK",
+					"FAIL_ON_EMPTY",
+					"FETCH_FULL_LOGS",
+					"NO_SANITIZE",
+					"NCC_SEND_EMAIL",
+					"TASK_API_VERSION",
 					"TIMEOUT",
 					"REQUEST_TIMEOUT",
 					"POLL_INTERVAL",
+					"POLL_TIMEOUT",
+					"MAX_TOTAL_RUNTIME",
+					"WATCH",
+					"FAIL_FAST",
+					"CONTINUE_ON_ERROR",
 					"POLL_JITTER",
+					"POLL_BACKOFF",
+					"POLL_MAX_INTERVAL",
 					"MAX_PARALLEL",
+					"RAMP_UP",
 					"OUTPUTS",
+					"COMBINED_OUTPUT",
+					"AGGREGATED_FILENAME",
+					"SUMMARY_ONLY",
+					"SORT_ORDER",
+					"WRITE_MANIFEST",
+					"ARCHIVE",
+					"HISTORY_FILE",
+					"HISTORY_MAX_SIZE",
+					"ELASTIC_ENABLED",
+					"ELASTIC_URL",
+					"ELASTIC_INDEX",
+					"ELASTIC_USERNAME",
+					"ELASTIC_PASSWORD",
+					"ELASTIC_API_KEY",
 					"OUTPUT_DIR_LOGS",
+					"COMPRESS_LOGS",
 					"OUTPUT_DIR_FILTERED",
+					"OUTPUT_DIR",
+					"TIMESTAMPED_OUTPUT_DIR",
 					"LOG_FILE",
 					"LOG_LEVEL",
 					"LOG_HTTP",
+					"LOG_STDOUT",
+					"NO_COLOR",
+					"FORCE_COLOR",
+					"LOG_MAX_SIZE",
+					"LOG_MAX_BACKUPS",
+					"LOG_MAX_AGE",
+					"LOG_COMPRESS",
 					"RETRY_MAX_ATTEMPTS",
 					"RETRY_BASE_DELAY",
 					"RETRY_MAX_DELAY",
+					"NO_RETRY_ON",
+					"SUMMARY_RETRIES",
+					"SUMMARY_RETRY_DELAY",
+					"BLOCK_START_REGEX",
+					"BLOCK_END_REGEX",
+					"REPORT_TIME_FORMAT",
+					"REPORT_TIMEZONE",
+					"OUTPUT_STDOUT",
+					"JSON_OUTPUT",
+					"CLEAN_STALE",
+					"KEEP_LAST_RUNS",
+					"ON_COMPLETE",
+					"ON_COMPLETE_STRICT",
+					"ERROR_FORMAT",
+					"MAX_CLUSTERS",
+					"MAX_ROWS_PER_SEVERITY",
+					"GROUP_BY_CATEGORY",
+					"COLUMNS",
+					"REDACT_PATTERN",
+					"TEMPLATE_VAR",
+					"SEVERITY_COLOR",
+					"CONFIG",
+					"CONFIG_DIR",
+					"PROGRESS",
+					"QUIET",
+					"VERBOSE",
+					"SINCE",
+					"SINCE_KEEP_UNDATED",
+					"FILTER_CHECK_EXCLUDE",
+					"YES",
+					"SELECT",
 				}
 				for _, key := range envKeys {
 					envVar := "NCC_" + key
@@ -1984,13 +8340,64 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 				return nil // Exit after printing
 			}
 
-			cfg.Password, err = promptPasswordIfEmpty(cfg.Password, cfg.Username)
+			if cfg.KeyringClear {
+				if err := keyringDelete(keyringAccount(cfg)); errors.Is(err, errKeyringNotFound) {
+					log.Info().Str("account", keyringAccount(cfg)).Msg("keyring clear: no entry stored")
+				} else if err != nil {
+					log.Warn().Err(err).Str("account", keyringAccount(cfg)).Msg("keyring clear failed")
+				} else {
+					log.Info().Str("account", keyringAccount(cfg)).Msg("keyring entry cleared")
+				}
+			}
+			cfg.Password, err = promptPasswordIfEmpty(cfg, cmd.Flags().Changed("password"))
 			if err != nil {
 				return err
 			}
 
-			fs := OSFS{}
 			httpc := NewHTTPClient(cfg)
+			if len(cfg.PrismCentrals) > 0 {
+				discovered, origin, err := discoverPCClusters(context.Background(), cfg, httpc)
+				if err != nil {
+					return err
+				}
+				cfg.Clusters = append(cfg.Clusters, discovered...)
+				cfg.ClusterLabels = labelPCOrigin(cfg.ClusterLabels, origin)
+				if len(cfg.Clusters) == 0 {
+					return fmt.Errorf("prism central %s reported no clusters", strings.Join(cfg.PrismCentrals, ", "))
+				}
+			}
+
+			if cfg.ClusterSourceURL != "" {
+				source, err := loadClusterSource(context.Background(), httpc, cfg)
+				if err != nil {
+					return fmt.Errorf("cluster source %s: %w", cfg.ClusterSourceURL, err)
+				}
+				var dropped []string
+				cfg.Clusters, dropped = scopeClusters(cfg.Clusters, source)
+				log.Info().Str("url", cfg.ClusterSourceURL).Int("source", len(source)).Strs("clusters", cfg.Clusters).Strs("outOfScope", dropped).Msg("cluster source applied")
+				if len(cfg.Clusters) == 0 {
+					return fmt.Errorf("no clusters left after applying cluster source %s", cfg.ClusterSourceURL)
+				}
+			}
+
+			if cfg.Clusters, err = dedupeClusters(cfg.Clusters); err != nil {
+				return err
+			}
+			if cfg.Select {
+				cfg.ConfiguredClusters = cfg.Clusters
+				if cfg.Clusters, err = selectClusters(cfg.Clusters, os.Stdin, os.Stderr); err != nil {
+					return err
+				}
+				log.Info().Strs("clusters", cfg.Clusters).Msg("clusters selected")
+			}
+			if err := checkOutputStdout(cfg); err != nil {
+				return err
+			}
+			if err := checkClusterCap(cfg); err != nil {
+				return err
+			}
+
+			fs := OSFS{}
 			if err := fs.MkdirAll(cfg.OutputDirLogs, 0755); err != nil {
 				return err
 			}
@@ -2000,16 +8407,20 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 
 			// Fast replay mode: skip API, parse existing logs and render everything
 			if cmd.Flags().Changed("replay") && viper.GetBool("replay") {
+				replayStart := time.Now()
+				if cfg.NCCSendEmail {
+					log.Warn().Msg("replay: --ncc-send-email has no effect, no NCC run is started")
+				}
 				var agg []AggBlock
 				var clusterFiles []struct{ Cluster, HTML, CSV string }
+				var summaries []ClusterSummary
 
 				for _, cluster := range cfg.Clusters {
 					// Ensure filtered log exists
-					filtered := filepath.Join(cfg.OutputDirFiltered, fmt.Sprintf("%s.log", cluster))
-					if _, err := os.Stat(filtered); err != nil {
+					filtered := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(cluster)+".log")
+					if _, err := fs.Stat(filtered); err != nil {
 						// Try to build it from raw ncc log
-						raw := filepath.Join(cfg.OutputDirLogs, fmt.Sprintf("%s.log", cluster))
-						if _, err2 := os.Stat(raw); err2 == nil {
+						if raw, ok := findRawLog(fs, cfg.OutputDirLogs, cluster); ok {
 							if err3 := filterBlocksToFile(OSFS{}, raw, filtered); err3 != nil {
 								log.Error().Str("cluster", cluster).Err(err3).Msg("replay: build filtered failed")
 								continue
@@ -2031,226 +8442,318 @@ Go Version: %s`, Version, Stream, BuildDate, GoVersion),
 						log.Error().Str("cluster", cluster).Err(err).Msg("replay: parse filtered failed")
 						continue
 					}
+					if len(blocks) == 0 && cfg.FailOnEmpty {
+						log.Error().Str("cluster", cluster).Str("filtered", filtered).Msg("replay: no blocks parsed (--fail-on-empty), skipping")
+						continue
+					}
+					blocks = applyFilters(blocks, cfg)
+					blocks = overrideSeverities(blocks, cfg.SeverityOverrides, cluster)
+					blocks = redactBlocks(blocks, cfg.Redactions)
 					// Per-cluster outputs
 					base := filtered
-					for _, f := range cfg.OutputFormats {
-						switch strings.ToLower(strings.TrimSpace(f)) {
-						case "html":
-							_ = generateHTML(OSFS{}, rowsFromBlocks(blocks), base+".html")
-						case "csv":
-							_ = generateCSV(OSFS{}, blocks, base+".csv")
-						}
-					}
+					_ = renderOutputs(context.Background(), reportFS(cfg, OSFS{}), cfg, cluster, base, blocks)
 
 					clusterFiles = append(clusterFiles, struct{ Cluster, HTML, CSV string }{
 						Cluster: cluster,
 						HTML:    filepath.Base(base + ".html"),
 						CSV:     filepath.Base(base + ".csv"),
 					})
+					// Replayed logs come from runs that completed
+					summaries = append(summaries, summarizeResult(ClusterResult{Cluster: cluster, Blocks: blocks, Reachable: true, Started: true}, cfg.ClusterLabels[cluster]))
 					for _, b := range blocks {
-						agg = append(agg, AggBlock{
-							Cluster:  cluster,
-							Severity: b.Severity,
-							Check:    b.CheckName,
-							Detail:   b.DetailRaw,
-						})
+						agg = append(agg, newAggBlock(cluster, b, cfg.ClusterLabels[cluster]))
 					}
 				}
 
-				if err := writeAggregatedHTMLSingle(OSFS{}, cfg.OutputDirFiltered, agg, clusterFiles); err != nil {
+				sortAggRows(agg, cfg.SortOrder)
+				if err := writeAggregatedHTMLSingle(OSFS{}, cfg.OutputDirFiltered, cfg.AggregatedFilename, agg, clusterFiles, summaries, cfg.MaxRowsPerSeverity, cfg.TemplateVars, cfg.SummaryOnly, cfg.Columns); err != nil {
 					log.Error().Err(err).Msg("replay: write aggregated HTML failed")
 					return err
 				}
-				log.Info().Int("clusters", len(clusterFiles)).Int("rows", len(agg)).Msg("replay: aggregated page generated")
-				return nil
-			}
-
-			// Inside RunE, after setting up cfg, fs, httpc...
-			fmt.Println("You have accepted T&C, Check using --tc flag")
-
-			p := mpb.New(mpb.WithWidth(80)) // Removed invalid WithDebug
-
-			ctx := context.Background()
-			sem := make(chan struct{}, cfg.MaxParallel)
-			var wg sync.WaitGroup
-			results := make(chan ClusterResult, len(cfg.Clusters))
-
-			for _, cluster := range cfg.Clusters {
-				wg.Add(1)
-				sem <- struct{}{}
-
-				mainBar := p.New(
-					100,
-					mpb.BarStyle().Rbound("|"),
-					mpb.PrependDecorators(
-						decor.Name(fmt.Sprintf("%-18s", cluster), decor.WC{W: 20, C: decor.DidentRight}),
-					),
-					mpb.AppendDecorators(
-						decor.Percentage(decor.WC{W: 4}),
-						decor.Name(" • "),
-						decor.Elapsed(decor.ET_STYLE_GO, decor.WC{W: 4}),
-					),
-				)
-
-				phaseProxy := &proxyDecorator{text: "starting"}
-
-				phaseBar := p.New(
-					1,
-					mpb.NopStyle(),
-					mpb.PrependDecorators(decor.Name(strings.Repeat(" ", 20))),
-					mpb.AppendDecorators(phaseProxy),
-				)
-
-				go func(cl string, b *mpb.Bar, phase *proxyDecorator, phaseBar *mpb.Bar) {
-					defer wg.Done()
-					defer func() { <-sem }()
-					defer func() {
-						if r := recover(); r != nil {
-							b.Abort(false)
-							b.SetTotal(b.Current(), true)
-							phaseBar.SetCurrent(1)     // Set current to match total
-							phaseBar.SetTotal(1, true) // Complete phaseBar on panic
-							log.Error().Interface("panic", r).Stack().Str("cluster", cl).Msg("cluster goroutine panic")
-							results <- ClusterResult{Cluster: cl, Blocks: nil, Err: fmt.Errorf("panic: %v", r)}
-						}
-					}()
-
-					reqCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
-					defer cancel()
-
-					onPct := func(pct int) { b.SetCurrent(int64(pct)) }
-					setPhase := func(text string) {
-						phase.SetText(text)
-						log.Info().Str("cluster", cl).Str("phase", text).Msg("phase change")
+				if err := writeAggregatedJSON(OSFS{}, cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename), summaries, agg, cfg.TemplateVars, cfg.SummaryOnly); err != nil {
+					log.Error().Err(err).Msg("replay: write aggregated JSON failed")
+				}
+				if cfg.CombinedOutput {
+					if err := writeCombined(OSFS{}, cfg.OutputDirFiltered, agg, cfg.Columns); err != nil {
+						log.Error().Err(err).Msg("replay: write combined outputs failed")
 					}
-
-					blocks, err := runClusterWithBars(reqCtx, cfg, fs, httpc, cl, onPct, setPhase)
-					if err != nil {
-						b.Abort(false)
-						b.SetTotal(b.Current(), true)
-						setPhase("failed")
-						phaseBar.SetCurrent(1)     // Set current to match total
-						phaseBar.SetTotal(1, true) // Complete phaseBar on error
-						log.Error().Str("cluster", cl).Err(err).Msg("cluster run failed")
-						results <- ClusterResult{Cluster: cl, Blocks: nil, Err: err}
-						return
+				}
+				if cfg.WriteManifest {
+					var done []string
+					for _, cf := range clusterFiles {
+						done = append(done, cf.Cluster)
+					}
+					if err := writeManifest(fs, cfg.OutputDirFiltered, reportFiles(fs, cfg, done), cfg); err != nil {
+						log.Error().Err(err).Msg("replay: write manifest failed")
 					}
-
-					b.SetCurrent(100)
-					b.SetTotal(100, true)
-					setPhase("done")
-					phaseBar.SetCurrent(1)     // Set current to match total
-					phaseBar.SetTotal(1, true) // Complete phaseBar on success
-					log.Info().Str("cluster", cl).Msg("cluster run completed")
-					results <- ClusterResult{Cluster: cl, Blocks: blocks, Err: nil}
-				}(cluster, mainBar, phaseProxy, phaseBar) // Pass phaseBar
-			}
-
-			// Wait for workers, close and drain results
-			wg.Wait()
-			close(results)
-
-			var failed []string
-			var agg []AggBlock
-			var clusterFiles []struct{ Cluster, HTML, CSV string }
-
-			for r := range results {
-				if r.Err != nil {
-					failed = append(failed, r.Cluster)
-					continue
 				}
-				for _, b := range r.Blocks {
-					agg = append(agg, AggBlock{
-						Cluster:  r.Cluster,
-						Severity: b.Severity,
-						Check:    b.CheckName,
-						Detail:   b.DetailRaw,
-					})
+				if cfg.CleanStale {
+					if _, err := cleanStaleOutputs(fs, cfg.OutputDirFiltered, staleKeep(cfg)); err != nil {
+						log.Warn().Err(err).Msg("replay: clean stale outputs failed")
+					}
 				}
-				basePath := filepath.Join(cfg.OutputDirFiltered, fmt.Sprintf("%s.log", r.Cluster))
-				htmlPath := basePath + ".html"
-				csvPath := basePath + ".csv"
-				clusterFiles = append(clusterFiles, struct{ Cluster, HTML, CSV string }{
-					Cluster: r.Cluster,
-					HTML:    filepath.Base(htmlPath),
-					CSV:     filepath.Base(csvPath),
-				})
-			}
-
-			// Write aggregated page
-			if err := writeAggregatedHTMLSingle(fs, cfg.OutputDirFiltered, agg, clusterFiles); err != nil {
-				log.Error().Err(err).Msg("write aggregated HTML failed")
+				if cfg.Archive != "" {
+					var done []string
+					for _, cf := range clusterFiles {
+						done = append(done, cf.Cluster)
+					}
+					if err := writeArchive(fs, cfg.OutputDirFiltered, cfg.Archive, reportFiles(fs, cfg, done), cfg); err != nil {
+						log.Error().Err(err).Msg("replay: write archive failed")
+					}
+				}
+				log.Info().Int("clusters", len(clusterFiles)).Int("rows", len(agg)).Msg("replay: aggregated page generated")
+				hookErr := runOnComplete(context.Background(), cfg, len(summaries), 0, agg)
+				if cfg.JSONOutput {
+					printRunResult(os.Stdout, runResult(fs, cfg, replayStart, summaries, agg))
+				}
+				return hookErr
 			}
 
-			// // Flush progress rendering
-			// log.Info().Msg("Before p.Wait()") // Temporary debug log
-			// p.Wait()
-			// log.Info().Msg("After p.Wait()") // Temporary debug log
-
-			if len(failed) > 0 {
-				log.Error().Strs("failedClusters", failed).Msg("some clusters failed")
-				return fmt.Errorf("some clusters failed: %v", failed) // Use this for the message; remove fmt.Printf
+			if cfg.Watch > 0 {
+				return watchLoop(cfg, fs, httpc)
 			}
-
-			log.Info().Msg("all clusters processed successfully")
-			fmt.Printf("All clusters processed successfully\n")
-			return nil
+			return runBatch(context.Background(), cfg, fs, httpc)
 		},
 	}
 
 	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true // main prints the error in the requested format
+	cmd.AddCommand(newVerifyCmd())
+	cmd.AddCommand(newTrendCmd())
+	cmd.AddCommand(newListChecksCmd())
+	cmd.AddCommand(newParseCmd())
 
 	// flags
 	cmd.Flags().Bool("env-info", false, "Display possible environment variables and their current values")
 	cmd.Flags().Bool("tc", false, "Display terms and conditions")
-	cmd.Flags().String("config", "", "Config file path (yaml/json)")
+	// Config, auth, TLS, retry and logging flags are persistent so subcommands
+	// that talk to Prism (list-checks) honour them too.
+	cmd.PersistentFlags().StringArray("config", nil, "Config file path (yaml/json); repeat to merge files, later ones win; if unset, searches $NCC_CONFIG, ./config.yaml, then --config-dir")
+	cmd.PersistentFlags().String("config-dir", "", "Directory searched for config.yaml (default $XDG_CONFIG_HOME/ncc-orchestrator)")
 	cmd.Flags().String("clusters", "", "Comma-separated cluster IPs or FQDNs")
-	cmd.Flags().String("username", "admin", "Username for Prism Gateway")
-	cmd.Flags().String("password", "", "Password (omit to be prompted)")
-	cmd.Flags().Bool("insecure-skip-verify", false, "Skip TLS verify (only for trusted labs)")
+	cmd.Flags().String("prism-central", "", "Comma-separated Prism Central hosts to discover registered clusters from (makes --clusters optional)")
+	cmd.Flags().String("cluster-source-url", "", "Fetch in-scope clusters (JSON array) from this URL; narrows --clusters, or replaces it when empty")
+	cmd.Flags().StringArray("cluster-source-header", nil, "Header sent to --cluster-source-url as 'Name: value' (repeatable), e.g. 'Authorization: Bearer ...'")
+	cmd.Flags().String("cluster-source-timeout", "30s", "Timeout for the --cluster-source-url fetch, retries included")
+	cmd.Flags().String("cluster-source-cache", "", "Last fetched cluster source list, used when the fetch fails (default <config-dir>/cluster-source.json)")
+	cmd.Flags().String("min-recheck-interval", "", "Skip clusters whose last successful run is younger than this (e.g. 30m) and reuse its results; empty = always run")
+	cmd.Flags().String("recheck-cache", "", "Last successful run per cluster for --min-recheck-interval (default <config-dir>/recheck-cache.json)")
+	cmd.Flags().String("recheck-cache-ttl", "24h", "Drop --recheck-cache entries older than this")
+	cmd.PersistentFlags().String("username", "admin", "Username for Prism Gateway")
+	cmd.PersistentFlags().String("password", "", "Password (omit to be prompted)")
+	cmd.PersistentFlags().String("password-file", "", "Read the password from this file (trimmed)")
+	cmd.PersistentFlags().Bool("password-stdin", false, "Read the password from a single line on stdin")
+	cmd.PersistentFlags().Bool("use-keyring", false, "Load the password from the OS keychain; save it there after prompting")
+	cmd.PersistentFlags().Bool("keyring-clear", false, "Delete the stored keychain password for this username and cluster group")
+	cmd.PersistentFlags().Bool("insecure-skip-verify", false, "Skip TLS verify (only for trusted labs)")
+	cmd.PersistentFlags().Bool("require-secure", false, "Refuse to run if insecure-skip-verify is enabled (for CI)")
+	cmd.PersistentFlags().Int("max-idle-conns-per-host", 0, "Idle HTTP connections kept per cluster (0 = Go default of 2)")
+	cmd.PersistentFlags().Int64("max-response-bytes", 32<<20, "Largest API response body read into memory (task status, cluster info, ...); 0 = unlimited")
+	cmd.PersistentFlags().Int64("max-summary-bytes", 512<<20, "Largest NCC run summary downloaded; 0 = unlimited")
+	cmd.PersistentFlags().Bool("disable-http2", false, "Use HTTP/1.1 only when talking to Prism")
+	cmd.PersistentFlags().String("user-agent", "", "User-Agent sent to Prism (default ncc-orchestrator/<version>)")
+	cmd.Flags().String("task-api-version", "auto", "Task polling API: auto (v2.0 endpoint, v2.0 or v3 body), v2 or v3 (/api/nutanix/v3/tasks)")
+	cmd.Flags().Bool("ncc-send-email", false, "Set sendEmail=true when starting NCC so Prism also mails its own NCC report")
+	cmd.Flags().Bool("skip-ncc-check", false, "Skip the pre-flight check that NCC is installed on each cluster")
+	cmd.Flags().Bool("fail-on-empty", false, "Fail a cluster whose run summary parses to zero check blocks instead of reporting it clean")
+	cmd.Flags().Bool("fetch-full-logs", false, "Experimental: for clusters with FAIL results, also download the full NCC output to <logs dir>/<cluster>.ncc-full.log (can be large)")
+	cmd.Flags().Bool("no-sanitize", false, "Write the run summary raw, without unescaping \\n, \\t, \\\" (debugging)")
 	cmd.Flags().String("timeout", "15m", "Overall per-cluster timeout")
-	cmd.Flags().String("request-timeout", "20s", "Per-request timeout")
+	cmd.PersistentFlags().String("request-timeout", "20s", "Per-request timeout")
 	cmd.Flags().String("poll-interval", "15s", "Polling interval for task status")
+	cmd.Flags().Bool("fail-fast", false, "Cancel remaining clusters after the first cluster fails (same as --continue-on-error=false)")
+	cmd.Flags().Bool("continue-on-error", true, "Keep running other clusters when one fails")
+	cmd.Flags().String("max-total-runtime", "", "Wall-clock cap for the whole run; unfinished clusters are reported as aborted (empty = unlimited)")
+	cmd.Flags().String("watch", "", "Re-run every interval (e.g. 30m) until interrupted; overrunning iterations skip the next tick")
+	cmd.Flags().String("poll-timeout", "", "Max time for the NCC checks to finish (polling phase only); empty = bounded by --timeout")
 	cmd.Flags().String("poll-jitter", "2s", "Additive jitter to polling interval")
+	cmd.Flags().String("poll-backoff", "none", "Grow the polling interval on long runs: none, exponential (double per poll) or stepped (+poll-interval every 4 polls)")
+	cmd.Flags().String("poll-max-interval", "2m", "Cap for --poll-backoff")
 	cmd.Flags().Int("max-parallel", 4, "Max concurrent clusters")
-	cmd.Flags().String("outputs", "html,csv", "Comma-separated outputs: html,csv for per-cluster files")
+	cmd.Flags().String("ramp-up", "", "Stagger the first max-parallel cluster starts evenly over this window (e.g. 20s); empty = start at once")
+	cmd.Flags().String("outputs", "html,csv", "Comma-separated outputs: html,csv,jsonl for per-cluster files")
+	cmd.Flags().String("sort-order", "severity", "Report row order: severity (FAIL, ERR, WARN, INFO, then check), cluster (aggregated: cluster then severity) or original")
+	cmd.Flags().Bool("combined-output", false, "Also write combined.csv and combined.json across all clusters")
+	cmd.Flags().String("aggregated-filename", "index.html", "File name of the aggregated HTML report; the aggregated JSON takes the same base name (index.html -> index.json)")
+	cmd.Flags().Bool("summary-only", false, "Render only per-severity and per-cluster counts, without detail rows")
+	cmd.Flags().String("archive", "", "After the run, package the reports and a manifest into this .zip or .tar.gz (originals are kept)")
+	cmd.Flags().Bool("write-manifest", false, "Write manifest.json with SHA-256 hashes of all outputs (check with the verify subcommand)")
+	cmd.Flags().String("history-file", "", "Append each run's per-cluster severity counts to this JSON Lines file (see the trend subcommand)")
+	cmd.Flags().Int("history-max-size", 10, "Rotate --history-file to <file>.1 past this size in MB (0 = never)")
+	cmd.Flags().Bool("elastic-enabled", false, "Bulk-index each run's results into Elasticsearch/OpenSearch")
+	cmd.Flags().String("elastic-url", "", "Elasticsearch/OpenSearch base URL, e.g. https://search.example.com:9200")
+	cmd.Flags().String("elastic-index", "ncc-results", "Index the results are written to")
+	cmd.Flags().String("elastic-username", "", "Basic auth username for --elastic-url")
+	cmd.Flags().String("elastic-password", "", "Basic auth password for --elastic-url (prefer NCC_ELASTIC_PASSWORD)")
+	cmd.Flags().String("elastic-api-key", "", "API key for --elastic-url, sent as 'Authorization: ApiKey <key>' (overrides basic auth)")
 	cmd.Flags().String("output-dir-logs", "nccfiles", "Directory for raw logs")
+	cmd.Flags().Bool("compress-logs", false, "Write raw logs gzipped as <cluster>.log.gz")
 	cmd.Flags().String("output-dir-filtered", "outputfiles", "Directory for filtered and aggregated results")
-	cmd.Flags().String("log-file", "logs/ncc-runner.log", "Path to log file (rotated)")
-	cmd.Flags().String("log-level", "", "Log level (trace/debug/info/warn/error or 0..5)")
-	cmd.Flags().Bool("log-http", false, "Enable HTTP request/response dump logs")
-	cmd.Flags().Int("retry-max-attempts", 6, "Max retry attempts for HTTP calls")
-	cmd.Flags().String("retry-base-delay", "400ms", "Base retry delay (with jitter, exponential)")
-	cmd.Flags().String("retry-max-delay", "8s", "Max retry delay cap")
+	cmd.Flags().String("output-dir", "", "Run directory: raw logs in <dir>/raw, reports in <dir>/reports (unless the per-directory flags are set)")
+	cmd.Flags().Bool("timestamped-output-dir", false, "Append a UTC timestamp to --output-dir so runs don't overwrite each other")
+	cmd.PersistentFlags().String("log-file", "logs/ncc-runner.log", "Path to log file (rotated)")
+	cmd.PersistentFlags().String("log-level", "", "Log level (trace/debug/info/warn/error or 0..5)")
+	cmd.PersistentFlags().Bool("log-http", false, "Enable HTTP request/response dump logs")
+	cmd.Flags().Bool("log-stdout", false, "Also write log entries to stdout as JSON (console format on a TTY), for container log collectors")
+	cmd.Flags().Bool("no-color", false, "Never color console output (also set by a non-empty NO_COLOR)")
+	cmd.Flags().Bool("force-color", false, "Color console output even when it is not a terminal")
+	cmd.PersistentFlags().Int("log-max-size", 20, "Rotate the log file past this size in MB")
+	cmd.PersistentFlags().Int("log-max-backups", 5, "Rotated log files to keep (0 = all)")
+	cmd.PersistentFlags().Int("log-max-age", 30, "Days to keep rotated log files (0 = no age limit)")
+	cmd.PersistentFlags().Bool("log-compress", true, "Gzip rotated log files")
+	cmd.PersistentFlags().Int("retry-max-attempts", 6, "Max retry attempts for HTTP calls")
+	cmd.PersistentFlags().String("retry-base-delay", "400ms", "Base retry delay (with jitter, exponential)")
+	cmd.PersistentFlags().String("retry-max-delay", "8s", "Max retry delay cap")
+	cmd.PersistentFlags().String("no-retry-on", "", "Transport error classes to fail without retrying: dns,refused,reset,timeout,other (default: retry all)")
+	cmd.Flags().Int("summary-retries", 3, "Extra fetches when the run summary comes back empty")
+	cmd.Flags().String("summary-retry-delay", "5s", "Delay between empty-summary re-fetches")
 	cmd.Flags().Bool("replay", false, "Replay from existing logs without running NCC")
+	cmd.Flags().String("block-start-regex", "", "Override regex matching the start of a summary block (default: ^Detailed information for .*)")
+	cmd.Flags().String("block-end-regex", "", "Override regex matching the end of a summary block (default: ^Refer to.*)")
+	cmd.Flags().String("report-time-format", "RFC3339", "Timestamp layout in reports: a Go layout (e.g. \"2006-01-02 15:04 MST\") or RFC3339, RFC1123, RFC1123Z, RFC822Z, DateTime")
+	cmd.Flags().String("report-timezone", "", "Time zone for report timestamps, e.g. UTC or Europe/Berlin (default: local)")
+	cmd.Flags().Bool("output-stdout", false, "Write the per-cluster report to stdout (single cluster, single output format)")
+	cmd.Flags().Bool("json-output", false, "Print one JSON document with per-cluster status, counts, failures, duration and file paths to stdout after the run (console output moves to stderr)")
+	cmd.Flags().String("error-format", "text", "Error output on failure: text or json")
+	cmd.Flags().Bool("clean-stale", false, "Remove <cluster>.log.* outputs of clusters no longer in the list")
+	cmd.Flags().Int("keep-last-runs", 0, "With --timestamped-output-dir, delete all but the newest N run directories after a successful run (0 = keep all)")
+	cmd.Flags().String("on-complete", "", "Shell command to run after the reports are written; sees NCC_TOTAL, NCC_FAILED_COUNT, NCC_FAIL_COUNT, NCC_OUTPUT_DIR, ... (see README)")
+	cmd.Flags().Bool("on-complete-strict", false, "Fail the run when the --on-complete command exits non-zero (default: warn only)")
+	cmd.Flags().Int("max-clusters", 200, "Ask for confirmation (or require --yes) above this many clusters; 0 disables")
+	cmd.Flags().BoolP("yes", "y", false, "Assume yes for confirmation prompts (non-interactive)")
+	cmd.Flags().Bool("select", false, "Pick which of the configured clusters to run from a checkbox list (interactive terminal only)")
+	cmd.Flags().BoolP("quiet", "q", false, "Suppress non-error console output (implies --progress none)")
+	cmd.Flags().BoolP("verbose", "v", false, "Mirror log entries (at --log-level) to stderr")
+	cmd.Flags().String("progress", "auto", "Progress display: auto (bars on a TTY, else json), bars, json or none")
+	cmd.Flags().String("since", "", "Only report checks detected since a duration ago (24h) or a timestamp")
+	cmd.Flags().Bool("since-keep-undated", true, "With --since, keep checks that carry no timestamp")
+	cmd.Flags().String("filter-check-exclude", "", "Drop checks whose name matches this regex (e.g. a known-noisy NTP check)")
+	cmd.Flags().StringArray("template-var", nil, "Report metadata as key=value (repeatable), e.g. ticket=CHG0012345; shown in HTML headers and index.json")
+	cmd.Flags().StringArray("severity-color", nil, "Override a severity's HTML report color as SEV=#hex (repeatable), e.g. FAIL=#b91c1c; FAIL, ERR, WARN, INFO")
+	cmd.Flags().StringArray("redact-pattern", nil, "Replace regex matches in check names and details in every report, as <regex>=<replacement> (repeatable; the last '=' separates)")
+	cmd.Flags().Int("max-rows-per-severity", 0, "Limit HTML reports to N rows per severity (0 = unlimited; CSV/JSON stay complete)")
+	cmd.Flags().Bool("group-by-category", false, "Group per-cluster HTML rows into collapsible sections by NCC plugin category")
+	cmd.Flags().String("columns", "", "Comma-separated columns, in order, for the HTML/CSV reports and the aggregated HTML: cluster, severity, check, detail, node, category, remediation (default: every report's usual columns)")
 
 	// viper bindings
-	_ = viper.BindPFlag("config", cmd.Flags().Lookup("config"))
+	_ = viper.BindPFlag("config", cmd.PersistentFlags().Lookup("config"))
+	_ = viper.BindPFlag("config-dir", cmd.PersistentFlags().Lookup("config-dir"))
 	_ = viper.BindPFlag("clusters", cmd.Flags().Lookup("clusters"))
-	_ = viper.BindPFlag("username", cmd.Flags().Lookup("username"))
-	_ = viper.BindPFlag("password", cmd.Flags().Lookup("password"))
-	_ = viper.BindPFlag("insecure-skip-verify", cmd.Flags().Lookup("insecure-skip-verify"))
+	_ = viper.BindPFlag("prism-central", cmd.Flags().Lookup("prism-central"))
+	_ = viper.BindPFlag("cluster-source-url", cmd.Flags().Lookup("cluster-source-url"))
+	_ = viper.BindPFlag("cluster-source-header", cmd.Flags().Lookup("cluster-source-header"))
+	_ = viper.BindPFlag("cluster-source-timeout", cmd.Flags().Lookup("cluster-source-timeout"))
+	_ = viper.BindPFlag("cluster-source-cache", cmd.Flags().Lookup("cluster-source-cache"))
+	_ = viper.BindPFlag("min-recheck-interval", cmd.Flags().Lookup("min-recheck-interval"))
+	_ = viper.BindPFlag("recheck-cache", cmd.Flags().Lookup("recheck-cache"))
+	_ = viper.BindPFlag("recheck-cache-ttl", cmd.Flags().Lookup("recheck-cache-ttl"))
+	_ = viper.BindPFlag("username", cmd.PersistentFlags().Lookup("username"))
+	_ = viper.BindPFlag("password", cmd.PersistentFlags().Lookup("password"))
+	_ = viper.BindPFlag("password-file", cmd.PersistentFlags().Lookup("password-file"))
+	_ = viper.BindPFlag("password-stdin", cmd.PersistentFlags().Lookup("password-stdin"))
+	_ = viper.BindPFlag("use-keyring", cmd.PersistentFlags().Lookup("use-keyring"))
+	_ = viper.BindPFlag("keyring-clear", cmd.PersistentFlags().Lookup("keyring-clear"))
+	_ = viper.BindPFlag("insecure-skip-verify", cmd.PersistentFlags().Lookup("insecure-skip-verify"))
+	_ = viper.BindPFlag("require-secure", cmd.PersistentFlags().Lookup("require-secure"))
+	_ = viper.BindPFlag("max-idle-conns-per-host", cmd.PersistentFlags().Lookup("max-idle-conns-per-host"))
+	_ = viper.BindPFlag("max-response-bytes", cmd.PersistentFlags().Lookup("max-response-bytes"))
+	_ = viper.BindPFlag("max-summary-bytes", cmd.PersistentFlags().Lookup("max-summary-bytes"))
+	_ = viper.BindPFlag("disable-http2", cmd.PersistentFlags().Lookup("disable-http2"))
+	_ = viper.BindPFlag("user-agent", cmd.PersistentFlags().Lookup("user-agent"))
+	_ = viper.BindPFlag("skip-ncc-check", cmd.Flags().Lookup("skip-ncc-check"))
+	_ = viper.BindPFlag("fail-on-empty", cmd.Flags().Lookup("fail-on-empty"))
+	_ = viper.BindPFlag("fetch-full-logs", cmd.Flags().Lookup("fetch-full-logs"))
+	_ = viper.BindPFlag("no-sanitize", cmd.Flags().Lookup("no-sanitize"))
+	_ = viper.BindPFlag("ncc-send-email", cmd.Flags().Lookup("ncc-send-email"))
+	_ = viper.BindPFlag("task-api-version", cmd.Flags().Lookup("task-api-version"))
 	_ = viper.BindPFlag("timeout", cmd.Flags().Lookup("timeout"))
-	_ = viper.BindPFlag("request-timeout", cmd.Flags().Lookup("request-timeout"))
+	_ = viper.BindPFlag("request-timeout", cmd.PersistentFlags().Lookup("request-timeout"))
 	_ = viper.BindPFlag("poll-interval", cmd.Flags().Lookup("poll-interval"))
+	_ = viper.BindPFlag("poll-timeout", cmd.Flags().Lookup("poll-timeout"))
+	_ = viper.BindPFlag("max-total-runtime", cmd.Flags().Lookup("max-total-runtime"))
+	_ = viper.BindPFlag("watch", cmd.Flags().Lookup("watch"))
+	_ = viper.BindPFlag("fail-fast", cmd.Flags().Lookup("fail-fast"))
+	_ = viper.BindPFlag("continue-on-error", cmd.Flags().Lookup("continue-on-error"))
 	_ = viper.BindPFlag("poll-jitter", cmd.Flags().Lookup("poll-jitter"))
+	_ = viper.BindPFlag("poll-backoff", cmd.Flags().Lookup("poll-backoff"))
+	_ = viper.BindPFlag("poll-max-interval", cmd.Flags().Lookup("poll-max-interval"))
 	_ = viper.BindPFlag("max-parallel", cmd.Flags().Lookup("max-parallel"))
+	_ = viper.BindPFlag("ramp-up", cmd.Flags().Lookup("ramp-up"))
 	_ = viper.BindPFlag("outputs", cmd.Flags().Lookup("outputs"))
+	_ = viper.BindPFlag("combined-output", cmd.Flags().Lookup("combined-output"))
+	_ = viper.BindPFlag("aggregated-filename", cmd.Flags().Lookup("aggregated-filename"))
+	_ = viper.BindPFlag("summary-only", cmd.Flags().Lookup("summary-only"))
+	_ = viper.BindPFlag("sort-order", cmd.Flags().Lookup("sort-order"))
+	_ = viper.BindPFlag("write-manifest", cmd.Flags().Lookup("write-manifest"))
+	_ = viper.BindPFlag("archive", cmd.Flags().Lookup("archive"))
+	_ = viper.BindPFlag("history-file", cmd.Flags().Lookup("history-file"))
+	_ = viper.BindPFlag("history-max-size", cmd.Flags().Lookup("history-max-size"))
+	_ = viper.BindPFlag("elastic-enabled", cmd.Flags().Lookup("elastic-enabled"))
+	_ = viper.BindPFlag("elastic-url", cmd.Flags().Lookup("elastic-url"))
+	_ = viper.BindPFlag("elastic-index", cmd.Flags().Lookup("elastic-index"))
+	_ = viper.BindPFlag("elastic-username", cmd.Flags().Lookup("elastic-username"))
+	_ = viper.BindPFlag("elastic-password", cmd.Flags().Lookup("elastic-password"))
+	_ = viper.BindPFlag("elastic-api-key", cmd.Flags().Lookup("elastic-api-key"))
 	_ = viper.BindPFlag("output-dir-logs", cmd.Flags().Lookup("output-dir-logs"))
+	_ = viper.BindPFlag("compress-logs", cmd.Flags().Lookup("compress-logs"))
 	_ = viper.BindPFlag("output-dir-filtered", cmd.Flags().Lookup("output-dir-filtered"))
-	_ = viper.BindPFlag("log-file", cmd.Flags().Lookup("log-file"))
-	_ = viper.BindPFlag("log-level", cmd.Flags().Lookup("log-level"))
-	_ = viper.BindPFlag("log-http", cmd.Flags().Lookup("log-http"))
-	_ = viper.BindPFlag("retry-max-attempts", cmd.Flags().Lookup("retry-max-attempts"))
-	_ = viper.BindPFlag("retry-base-delay", cmd.Flags().Lookup("retry-base-delay"))
-	_ = viper.BindPFlag("retry-max-delay", cmd.Flags().Lookup("retry-max-delay"))
+	_ = viper.BindPFlag("output-dir", cmd.Flags().Lookup("output-dir"))
+	_ = viper.BindPFlag("timestamped-output-dir", cmd.Flags().Lookup("timestamped-output-dir"))
+	_ = viper.BindPFlag("log-file", cmd.PersistentFlags().Lookup("log-file"))
+	_ = viper.BindPFlag("log-level", cmd.PersistentFlags().Lookup("log-level"))
+	_ = viper.BindPFlag("log-http", cmd.PersistentFlags().Lookup("log-http"))
+	_ = viper.BindPFlag("log-stdout", cmd.Flags().Lookup("log-stdout"))
+	_ = viper.BindPFlag("no-color", cmd.Flags().Lookup("no-color"))
+	_ = viper.BindPFlag("force-color", cmd.Flags().Lookup("force-color"))
+	_ = viper.BindPFlag("log-max-size", cmd.PersistentFlags().Lookup("log-max-size"))
+	_ = viper.BindPFlag("log-max-backups", cmd.PersistentFlags().Lookup("log-max-backups"))
+	_ = viper.BindPFlag("log-max-age", cmd.PersistentFlags().Lookup("log-max-age"))
+	_ = viper.BindPFlag("log-compress", cmd.PersistentFlags().Lookup("log-compress"))
+	_ = viper.BindPFlag("retry-max-attempts", cmd.PersistentFlags().Lookup("retry-max-attempts"))
+	_ = viper.BindPFlag("retry-base-delay", cmd.PersistentFlags().Lookup("retry-base-delay"))
+	_ = viper.BindPFlag("retry-max-delay", cmd.PersistentFlags().Lookup("retry-max-delay"))
+	_ = viper.BindPFlag("no-retry-on", cmd.PersistentFlags().Lookup("no-retry-on"))
+	_ = viper.BindPFlag("summary-retries", cmd.Flags().Lookup("summary-retries"))
+	_ = viper.BindPFlag("summary-retry-delay", cmd.Flags().Lookup("summary-retry-delay"))
 	_ = viper.BindPFlag("replay", cmd.Flags().Lookup("replay"))
+	_ = viper.BindPFlag("block-start-regex", cmd.Flags().Lookup("block-start-regex"))
+	_ = viper.BindPFlag("block-end-regex", cmd.Flags().Lookup("block-end-regex"))
+	_ = viper.BindPFlag("report-time-format", cmd.Flags().Lookup("report-time-format"))
+	_ = viper.BindPFlag("report-timezone", cmd.Flags().Lookup("report-timezone"))
+	_ = viper.BindPFlag("output-stdout", cmd.Flags().Lookup("output-stdout"))
+	_ = viper.BindPFlag("json-output", cmd.Flags().Lookup("json-output"))
+	_ = viper.BindPFlag("clean-stale", cmd.Flags().Lookup("clean-stale"))
+	_ = viper.BindPFlag("keep-last-runs", cmd.Flags().Lookup("keep-last-runs"))
+	_ = viper.BindPFlag("on-complete", cmd.Flags().Lookup("on-complete"))
+	_ = viper.BindPFlag("on-complete-strict", cmd.Flags().Lookup("on-complete-strict"))
+	_ = viper.BindPFlag("error-format", cmd.Flags().Lookup("error-format"))
+	_ = viper.BindPFlag("max-clusters", cmd.Flags().Lookup("max-clusters"))
+	_ = viper.BindPFlag("max-rows-per-severity", cmd.Flags().Lookup("max-rows-per-severity"))
+	_ = viper.BindPFlag("group-by-category", cmd.Flags().Lookup("group-by-category"))
+	_ = viper.BindPFlag("columns", cmd.Flags().Lookup("columns"))
+	_ = viper.BindPFlag("redact-pattern", cmd.Flags().Lookup("redact-pattern"))
+	_ = viper.BindPFlag("template-var", cmd.Flags().Lookup("template-var"))
+	_ = viper.BindPFlag("severity-color", cmd.Flags().Lookup("severity-color"))
+	_ = viper.BindPFlag("since", cmd.Flags().Lookup("since"))
+	_ = viper.BindPFlag("progress", cmd.Flags().Lookup("progress"))
+	_ = viper.BindPFlag("quiet", cmd.Flags().Lookup("quiet"))
+	_ = viper.BindPFlag("verbose", cmd.Flags().Lookup("verbose"))
+	_ = viper.BindPFlag("since-keep-undated", cmd.Flags().Lookup("since-keep-undated"))
+	_ = viper.BindPFlag("filter-check-exclude", cmd.Flags().Lookup("filter-check-exclude"))
+	_ = viper.BindPFlag("yes", cmd.Flags().Lookup("yes"))
+	_ = viper.BindPFlag("select", cmd.Flags().Lookup("select"))
 
 	return cmd
 }
 
 func main() {
 	if err := newRootCmd().Execute(); err != nil {
+		if strings.EqualFold(viper.GetString("error-format"), "json") {
+			if b, jerr := errorJSON(err); jerr == nil {
+				fmt.Fprintln(os.Stderr, string(b))
+				os.Exit(1)
+			}
+		}
 		fmt.Fprintln(os.Stderr, err.Error()) // Prints just the message without extra prefix
 		os.Exit(1)
 	}
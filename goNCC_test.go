@@ -0,0 +1,3660 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"github.com/zalando/go-keyring"
+)
+
+/************** Errors **************/
+
+func TestMultiErrorMatching(t *testing.T) {
+	authErr := &HTTPError{Op: "start checks", StatusCode: http.StatusUnauthorized, URL: "https://c1/x"}
+	m := &MultiError{Errors: []*ClusterError{
+		{Cluster: "c1", Err: fmt.Errorf("start checks failed: %w", authErr)},
+		{Cluster: "c2", Err: NewNCCError(ErrorTypeTimeout, "poll timeout", context.DeadlineExceeded)},
+	}}
+	var err error = fmt.Errorf("run: %w", m)
+
+	for _, typ := range []ErrorType{ErrorTypeAuth, ErrorTypeTimeout} {
+		if !errors.Is(err, &NCCError{Type: typ}) {
+			t.Errorf("errors.Is(%s) = false", typ)
+		}
+	}
+	if errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+		t.Error("errors.Is(config) = true for a run without config errors")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("cause of a cluster error not reachable with errors.Is")
+	}
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusUnauthorized {
+		t.Errorf("errors.As(*HTTPError) = %v", he)
+	}
+	var ce *ClusterError
+	if !errors.As(err, &ce) || ce.Cluster != "c1" {
+		t.Errorf("errors.As(*ClusterError) = %v", ce)
+	}
+	if got := errorType(err); got != ErrorTypeMultiple {
+		t.Errorf("errorType = %s, want %s", got, ErrorTypeMultiple)
+	}
+	m.Errors = m.Errors[:1]
+	if got := errorType(err); got != ErrorTypeAuth {
+		t.Errorf("errorType with one cluster = %s, want %s", got, ErrorTypeAuth)
+	}
+
+	b, err := errorJSON(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Type   ErrorType
+		Errors []struct {
+			Cluster string
+			Type    ErrorType
+		}
+	}
+	if err := json.Unmarshal(b, &doc); err != nil || doc.Type != ErrorTypeAuth || len(doc.Errors) != 1 || doc.Errors[0].Cluster != "c1" {
+		t.Errorf("errorJSON = %s", b)
+	}
+}
+
+/************** FS **************/
+
+// sampleBlocks are two parsed results as the renderers receive them.
+var sampleBlocks = []ParsedBlock{
+	{Severity: "FAIL", CheckName: "Detailed information for disk_usage_check:", CheckID: "disk_usage_check", DetailRaw: "FAIL: Disk usage above 90%", Node: "10.0.0.1", Category: "hardware"},
+	{Severity: "WARN", CheckName: "Detailed information for ntp_check:", CheckID: "ntp_check", DetailRaw: "WARN: NTP server unreachable"},
+}
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+	_ = fs.WriteFile("/a/b/one.txt", []byte("1"), 0644)
+	_ = fs.WriteFile("/a/two.txt", []byte("22"), 0644)
+	entries, err := fs.ReadDir("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"b", "two.txt"}) || !entries[0].IsDir() {
+		t.Fatalf("ReadDir(/a) = %v", names)
+	}
+	if st, err := fs.Stat("/a/b"); err != nil || !st.IsDir() {
+		t.Fatalf("Stat(/a/b) = %v, %v", st, err)
+	}
+	if err := fs.Rename("/a/two.txt", "/c/two.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fs.ReadFile("/c/two.txt"); err != nil || string(b) != "22" {
+		t.Fatalf("after rename: %q, %v", b, err)
+	}
+	_ = fs.RemoveAll("/a")
+	if _, err := fs.ReadFile("/a/b/one.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("RemoveAll left /a/b/one.txt: %v", err)
+	}
+}
+
+func TestGenerateCSVInMemory(t *testing.T) {
+	fs := NewMemFS()
+	if err := generateCSV(fs, sampleBlocks, "/out/c1.log.csv", nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile("/out/c1.log.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 || recs[1][0] != "FAIL" || recs[2][0] != "WARN" {
+		t.Fatalf("csv = %q", recs)
+	}
+	entries, _ := fs.ReadDir("/out")
+	if len(entries) != 1 {
+		t.Fatalf("temp files left next to the report: %d entries", len(entries))
+	}
+}
+
+// failingFS is a MemFS whose temp files fail after limit bytes, like a full
+// disk, and whose renames fail when failRename is set.
+type failingFS struct {
+	*MemFS
+	limit      int
+	failRename bool
+}
+
+type failingWriter struct {
+	io.WriteCloser
+	left int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.left {
+		n, _ := w.WriteCloser.Write(p[:w.left])
+		w.left = 0
+		return n, errors.New("no space left on device")
+	}
+	w.left -= len(p)
+	return w.WriteCloser.Write(p)
+}
+
+func (f failingFS) CreateTemp(dir, pattern string) (io.WriteCloser, string, error) {
+	w, name, err := f.MemFS.CreateTemp(dir, pattern)
+	return &failingWriter{WriteCloser: w, left: f.limit}, name, err
+}
+
+func (f failingFS) Rename(oldpath, newpath string) error {
+	if f.failRename {
+		return errors.New("rename failed")
+	}
+	return f.MemFS.Rename(oldpath, newpath)
+}
+
+func TestFailedWriteLeavesTargetIntact(t *testing.T) {
+	render := map[string]func(FS, string) error{
+		"html": func(fs FS, p string) error {
+			return generateHTML(fs, rowsFromBlocks(sampleBlocks), p, 0, nil, false, nil)
+		},
+		"csv":   func(fs FS, p string) error { return generateCSV(fs, sampleBlocks, p, nil) },
+		"jsonl": func(fs FS, p string) error { return generateJSONL(fs, sampleBlocks, "c1", p) },
+		"json": func(fs FS, p string) error {
+			return writeAggregatedJSON(fs, filepath.Dir(p), filepath.Base(p), nil, nil, nil, false)
+		},
+	}
+	for format, gen := range render {
+		for _, failRename := range []bool{false, true} {
+			mem := NewMemFS()
+			path := "/out/c1." + format
+			_ = mem.WriteFile(path, []byte("previous report"), 0644)
+			fs := failingFS{MemFS: mem, limit: 10, failRename: failRename}
+			if failRename {
+				fs.limit = 1 << 20
+			}
+			if err := gen(fs, path); err == nil {
+				t.Errorf("%s (failRename=%v): no error from a failed write", format, failRename)
+			}
+			if b, _ := mem.ReadFile(path); string(b) != "previous report" {
+				t.Errorf("%s (failRename=%v): target now %q", format, failRename, b)
+			}
+			if entries, _ := mem.ReadDir("/out"); len(entries) != 1 {
+				t.Errorf("%s (failRename=%v): %d entries in /out, temp file left behind", format, failRename, len(entries))
+			}
+		}
+	}
+}
+
+func TestWriterFSStreamsReport(t *testing.T) {
+	base := NewMemFS()
+	var out bytes.Buffer
+	if err := generateJSONL(WriterFS{Base: base, W: &out}, sampleBlocks, "c1", "/out/c1.log.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d jsonl lines on the writer, want 2", len(lines))
+	}
+	var rec jsonlRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil || rec.Cluster != "c1" || rec.CheckID != "disk_usage_check" {
+		t.Fatalf("first line %s: %+v, %v", lines[0], rec, err)
+	}
+	if _, err := base.Stat("/out/c1.log.jsonl"); err == nil {
+		t.Fatal("report written to the base FS as well as the writer")
+	}
+}
+
+/************** Renderers **************/
+
+func TestMaxRowsPerSeverity(t *testing.T) {
+	var blocks []ParsedBlock
+	for i := range 5 {
+		blocks = append(blocks, ParsedBlock{Severity: "FAIL", CheckName: fmt.Sprintf("fail_check_%d", i), DetailRaw: "FAIL: x"})
+	}
+	blocks = append(blocks, ParsedBlock{Severity: "WARN", CheckName: "warn_check", DetailRaw: "WARN: y"})
+
+	shown, omitted := limitPerSeverity(blocks, 2, func(b ParsedBlock) string { return b.Severity })
+	if len(shown) != 3 || omitted["FAIL"] != 3 || omitted["WARN"] != 0 {
+		t.Fatalf("limitPerSeverity: %d shown, omitted %v", len(shown), omitted)
+	}
+	if all, none := limitPerSeverity(blocks, 0, func(b ParsedBlock) string { return b.Severity }); len(all) != 6 || none != nil {
+		t.Fatalf("limit 0 should keep every row: %d shown, omitted %v", len(all), none)
+	}
+
+	fs := NewMemFS()
+	if err := generateHTML(fs, rowsFromBlocks(blocks), "/out/c1.log.html", 2, nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := fs.ReadFile("/out/c1.log.html")
+	html := string(data)
+	if n := strings.Count(html, "fail_check_"); n != 2 {
+		t.Errorf("%d FAIL rows rendered, want 2", n)
+	}
+	if !strings.Contains(html, "warn_check") {
+		t.Error("WARN row dropped by the FAIL limit")
+	}
+	if !strings.Contains(html, "and 3 more FAIL rows") {
+		t.Error("truncation note missing")
+	}
+	if !strings.Contains(html, `<span class="sev FAIL">FAIL 5</span>`) {
+		t.Error("severity band should count every FAIL, not just the rendered ones")
+	}
+}
+
+func TestHTMLSeverityBand(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		sevs   []string
+		badge  string
+		counts []string
+	}{
+		{"fail", []string{"FAIL", "WARN", "WARN", "INFO", "ERR"}, `<span class="status fail">FAIL</span>`,
+			[]string{"FAIL 1", "ERR 1", "WARN 2", "INFO 1"}},
+		{"warn", []string{"WARN", "INFO", "INFO"}, `<span class="status warn">WARN</span>`,
+			[]string{"FAIL 0", "ERR 0", "WARN 1", "INFO 2"}},
+		{"ok", []string{"INFO"}, `<span class="status ok">PASS</span>`,
+			[]string{"FAIL 0", "ERR 0", "WARN 0", "INFO 1"}},
+		{"empty", nil, `<span class="status ok">PASS</span>`,
+			[]string{"FAIL 0", "ERR 0", "WARN 0", "INFO 0"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var blocks []ParsedBlock
+			for i, s := range tc.sevs {
+				blocks = append(blocks, ParsedBlock{Severity: s, CheckName: fmt.Sprintf("check_%d", i), DetailRaw: s + ": x"})
+			}
+			fs := NewMemFS()
+			if err := generateHTML(fs, rowsFromBlocks(blocks), "/out/c1.log.html", 0, nil, false, nil); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := fs.ReadFile("/out/c1.log.html")
+			html := string(data)
+			if !strings.Contains(html, tc.badge) {
+				t.Errorf("badge %s missing", tc.badge)
+			}
+			for _, c := range tc.counts {
+				sev, _, _ := strings.Cut(c, " ")
+				if want := fmt.Sprintf(`<span class="sev %s">%s</span>`, sev, c); !strings.Contains(html, want) {
+					t.Errorf("count %q missing from the band", c)
+				}
+			}
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	readCSV := func(fs FS, path string) [][]string {
+		t.Helper()
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recs
+	}
+	blocks := []ParsedBlock{
+		{Severity: "FAIL", CheckName: "disk_usage_check", DetailRaw: "FAIL: Disk usage above 90%", Node: "10.0.0.1"},
+		{Severity: "WARN", CheckName: "pd_snapshot_check", DetailRaw: "WARN: Snapshot older than schedule"},
+	}
+
+	cols, err := parseColumns("Check, severity,cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewMemFS()
+	if err := generateCSV(fs, blocks, "/out/c1.csv", cols); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"CheckName", "Severity"},
+		{"disk_usage_check", "FAIL"},
+		{"pd_snapshot_check", "WARN"},
+	}
+	if got := readCSV(fs, "/out/c1.csv"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("per-cluster CSV = %q, want %q", got, want)
+	}
+
+	agg := []AggBlock{{Cluster: "c1", Severity: "FAIL", Check: "disk_usage_check", Node: "10.0.0.1", Labels: map[string]string{"site": "dc1"}}}
+	if err := writeCombined(fs, "/out", agg, cols); err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{
+		{"CheckName", "Severity", "Cluster", "site"},
+		{"disk_usage_check", "FAIL", "c1", "dc1"},
+	}
+	if got := readCSV(fs, "/out/combined.csv"); !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("combined.csv = %q, want %q", got, want)
+	}
+
+	// index.html takes the same order, with the label columns after cluster.
+	headers := func(cols []string) []string {
+		t.Helper()
+		if err := writeAggregatedHTMLSingle(fs, "/out", "index.html", agg, nil, nil, 0, nil, false, cols); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := fs.ReadFile("/out/index.html")
+		var got []string
+		for _, m := range regexp.MustCompile(`<th class="col-[a-z]+"[^>]*>([^<]*)</th>`).FindAllSubmatch(data, -1) {
+			got = append(got, string(m[1]))
+		}
+		return got
+	}
+	if got, want := headers(cols), []string{"NCC Alert Title", "Severity", "Cluster", "site", "Actions"}; !slices.Equal(got, want) {
+		t.Errorf("index.html headers = %q, want %q", got, want)
+	}
+	if got, want := headers([]string{"node", "severity"}), []string{"site", "Node", "Severity", "Actions"}; !slices.Equal(got, want) {
+		t.Errorf("index.html headers without cluster = %q, want %q", got, want)
+	}
+	if got, want := headers(nil), []string{"Cluster", "site", "Severity", "NCC Alert Title", "Remediation", "Detail", "Actions"}; !slices.Equal(got, want) {
+		t.Errorf("default index.html headers = %q, want %q", got, want)
+	}
+
+	if err := generateCSV(fs, blocks, "/out/c1.csv", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCSV(fs, "/out/c1.csv")[0]; !slices.Equal(got, []string{"Severity", "CheckName", "Detail", "Node", "Category"}) {
+		t.Errorf("default header = %q", got)
+	}
+
+	for _, bad := range []string{"severity,owner", "check,check", "cluster"} {
+		if _, err := parseColumns(bad); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("--columns %q: err = %v, want a config error", bad, err)
+		}
+	}
+}
+
+func TestSeverityColors(t *testing.T) {
+	orig := severityColors
+	t.Cleanup(func() { severityColors = orig })
+	render := func() map[string]string {
+		t.Helper()
+		fs := NewMemFS()
+		blocks := []ParsedBlock{{Severity: "WARN", CheckName: "ntp_check", DetailRaw: "WARN: x"}}
+		if err := generateHTML(fs, rowsFromBlocks(blocks), "/out/c1.log.html", 0, nil, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := generateSummaryHTML(fs, blocks, "c1", "/out/c1.summary.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeAggregatedHTMLSingle(fs, "/out", "index.html", nil, nil, nil, 0, nil, false, nil); err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]string{}
+		for _, name := range []string{"c1.log.html", "c1.summary.html", "index.html"} {
+			data, _ := fs.ReadFile("/out/" + name)
+			out[name] = string(data)
+		}
+		return out
+	}
+
+	if err := setSeverityColors([]string{"fail=#c00", " WARN = #112233 "}); err != nil {
+		t.Fatal(err)
+	}
+	for name, html := range render() {
+		for _, want := range []string{"--fail: #c00;", "--warn: #112233;", "--info: #3b82f6;"} {
+			if !strings.Contains(html, want) {
+				t.Errorf("%s: %q missing", name, want)
+			}
+		}
+	}
+	if html := render()["c1.log.html"]; !strings.Contains(html, "background: #112233;") {
+		t.Error("per-cluster WARN badge ignores the override")
+	}
+
+	for _, bad := range []string{"PASS=#fff", "FAIL=red", "FAIL=#fff;}body{", "FAIL"} {
+		if err := setSeverityColors([]string{bad}); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("--severity-color %q: err = %v, want a config error", bad, err)
+		}
+	}
+	if severityColors["FAIL"] != "#c00" {
+		t.Error("a rejected spec changed the current colors")
+	}
+
+	if err := setSeverityColors(nil); err != nil {
+		t.Fatal(err)
+	}
+	if html := render()["c1.log.html"]; !strings.Contains(html, "--fail: #ef4444;") || !strings.Contains(html, "background: #fde68a;") {
+		t.Error("defaults not restored without overrides")
+	}
+}
+
+func TestReportTime(t *testing.T) {
+	format, loc := reportTimeFormat, reportLocation
+	t.Cleanup(func() { reportTimeFormat, reportLocation = format, loc })
+
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("CEST", 2*3600))
+	if got, want := reportTime(ts), ts.Local().Format(time.RFC3339); got != want {
+		t.Errorf("default stamp %q, want %q", got, want)
+	}
+
+	if err := setReportTime("2006-01-02 15:04 MST", "UTC"); err != nil {
+		t.Fatal(err)
+	}
+	if got := reportTime(ts); got != "2024-05-06 05:08 UTC" {
+		t.Errorf("custom stamp %q", got)
+	}
+	if err := setReportTime("RFC1123", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := reportTime(ts); got != "Mon, 06 May 2024 05:08:09 UTC" {
+		t.Errorf("RFC1123 stamp %q; the zone should be kept", got)
+	}
+
+	if err := setReportTime("yesterday", ""); err == nil {
+		t.Error("layout without date or time fields accepted")
+	}
+	if err := setReportTime("", "Mars/Olympus_Mons"); err == nil {
+		t.Error("unknown zone accepted")
+	}
+	if reportTimeFormat != time.RFC1123 || reportLocation != time.UTC {
+		t.Error("a rejected flag changed the current settings")
+	}
+
+	if err := setReportTime("2006-01-02 15:04 MST", ""); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewMemFS()
+	if err := generateHTML(fs, nil, "/out/c1.log.html", 0, nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := fs.ReadFile("/out/c1.log.html")
+	if !regexp.MustCompile(`Generated at \d{4}-\d\d-\d\d \d\d:\d\d UTC<`).Match(data) {
+		t.Error("per-cluster HTML stamp ignores --report-time-format or --report-timezone")
+	}
+}
+
+/************** Ordering **************/
+
+func TestSortBlocks(t *testing.T) {
+	parsed := []ParsedBlock{
+		{Severity: "INFO", CheckName: "b"},
+		{Severity: "WARN", CheckName: "c"},
+		{Severity: "FAIL", CheckName: "z"},
+		{Severity: "ERR", CheckName: "d"},
+		{Severity: "FAIL", CheckName: "a"},
+	}
+	order := func(blocks []ParsedBlock) (out []string) {
+		for _, b := range blocks {
+			out = append(out, b.Severity+":"+b.CheckName)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		order string
+		want  []string
+	}{
+		{"severity", []string{"FAIL:a", "FAIL:z", "ERR:d", "WARN:c", "INFO:b"}},
+		{"cluster", []string{"FAIL:a", "FAIL:z", "ERR:d", "WARN:c", "INFO:b"}},
+		{"original", order(parsed)},
+	} {
+		blocks := slices.Clone(parsed)
+		sortBlocks(blocks, tc.order)
+		if got := order(blocks); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestSortAggRows(t *testing.T) {
+	parsed := []AggBlock{
+		{Cluster: "c2", Severity: "WARN", CheckID: "x"},
+		{Cluster: "c1", Severity: "INFO", CheckID: "x"},
+		{Cluster: "c2", Severity: "FAIL", CheckID: "y"},
+		{Cluster: "c1", Severity: "FAIL", CheckID: "y"},
+		{Cluster: "c1", Severity: "FAIL", CheckID: "x"},
+	}
+	order := func(rows []AggBlock) (out []string) {
+		for _, r := range rows {
+			out = append(out, r.Cluster+":"+r.Severity+":"+r.CheckID)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		order string
+		want  []string
+	}{
+		{"severity", []string{"c1:FAIL:x", "c1:FAIL:y", "c2:FAIL:y", "c2:WARN:x", "c1:INFO:x"}},
+		{"cluster", []string{"c1:FAIL:x", "c1:FAIL:y", "c1:INFO:x", "c2:FAIL:y", "c2:WARN:x"}},
+		{"original", order(parsed)},
+	} {
+		rows := slices.Clone(parsed)
+		sortAggRows(rows, tc.order)
+		if got := order(rows); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.order, got, tc.want)
+		}
+	}
+	if severityRank("UNKNOWN") <= severityRank("INFO") {
+		t.Error("unknown severities should sort after INFO")
+	}
+}
+
+/************** Redirects **************/
+
+// redirectPair starts one TLS server that redirects /start to
+// https://<target>:<same port>/landing and reports the Authorization header
+// the landing request carried.
+func redirectPair(t *testing.T, target string) (start string, gotAuth func() string) {
+	t.Helper()
+	var auth string
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			_, port, _ := splitHostPortURL(srv.URL)
+			http.Redirect(w, r, "https://"+target+":"+port+"/landing", http.StatusTemporaryRedirect)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	})
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.URL + "/start", func() string { return auth }
+}
+
+func splitHostPortURL(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Hostname(), u.Port(), nil
+}
+
+func TestCheckRedirectKeepsAuthWithinCluster(t *testing.T) {
+	// localhost and 127.0.0.1 are different hosts to net/http, which drops
+	// Authorization between them; registering localhost as a member of
+	// 127.0.0.1's cluster must carry the credentials over.
+	addClusterMembers("127.0.0.1", "localhost")
+	t.Cleanup(func() {
+		clusterMembers.Lock()
+		delete(clusterMembers.m, "127.0.0.1")
+		clusterMembers.Unlock()
+	})
+	start, gotAuth := redirectPair(t, "localhost")
+	client := NewHTTPClient(Config{InsecureSkipVerify: true, Timeout: 10 * time.Second})
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", start, nil)
+	req.SetBasicAuth("admin", "secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := gotAuth(); got == "" {
+		t.Fatal("Authorization dropped on redirect to a cluster member")
+	}
+}
+
+func TestCheckRedirectDropsAuthForOtherHosts(t *testing.T) {
+	start, gotAuth := redirectPair(t, "localhost")
+	client := NewHTTPClient(Config{InsecureSkipVerify: true, Timeout: 10 * time.Second})
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", start, nil)
+	req.SetBasicAuth("admin", "secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := gotAuth(); got != "" {
+		t.Fatalf("Authorization %q sent to a host outside the cluster", got)
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	addClusterMembers("10.0.0.1", "10.0.0.2", "FE80:0::5")
+	t.Cleanup(func() {
+		clusterMembers.Lock()
+		delete(clusterMembers.m, "10.0.0.1")
+		clusterMembers.Unlock()
+	})
+	orig, _ := http.NewRequest("GET", "https://10.0.0.1:9440/api", nil)
+	orig.SetBasicAuth("admin", "secret")
+	tests := []struct {
+		target string
+		auth   bool
+	}{
+		{"https://10.0.0.1:9440/leader", true},
+		{"https://10.0.0.2:9440/leader", true},
+		{"https://[fe80::5]:9440/leader", true},
+		{"https://attacker.example.com:9440/leader", false},
+		{"https://10.0.0.3:9440/leader", false},
+		{"https://10.0.0.2:8443/leader", false},
+		{"http://10.0.0.2:9440/leader", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.target, nil)
+		if err := checkRedirect(req, []*http.Request{orig}); err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		if got := req.Header.Get("Authorization") != ""; got != tt.auth {
+			t.Errorf("%s: Authorization re-applied = %v, want %v", tt.target, got, tt.auth)
+		}
+	}
+
+	via := make([]*http.Request, maxRedirects)
+	for i := range via {
+		via[i] = orig
+	}
+	req, _ := http.NewRequest("GET", "https://10.0.0.1:9440/again", nil)
+	if err := checkRedirect(req, via); err != errTooManyRedirects {
+		t.Fatalf("after %d hops: err = %v, want errTooManyRedirects", maxRedirects, err)
+	}
+}
+
+/************** Retries **************/
+
+// statusServer answers every request with status and counts the hits.
+func statusServer(t *testing.T, status int) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int { mu.Lock(); defer mu.Unlock(); return hits }
+}
+
+func TestRetriesStopAtDeadline(t *testing.T) {
+	srv, hits := statusServer(t, http.StatusServiceUnavailable)
+	// Any backoff drawn from [0, 1h) is longer than the 1s left, so the first
+	// 503 is returned as is instead of sleeping into the deadline.
+	cfg := Config{RetryMaxAttempts: 10, RetryBaseDelay: time.Hour, RetryMaxDelay: time.Hour, RequestTimeout: 5 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	start := time.Now()
+	_, _, err := doWithRetry(ctx, srv.Client(), req, cfg, "get task")
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want the 503 rather than a context error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %s; a backoff past the deadline should not be slept", elapsed)
+	}
+	if n := hits(); n != 1 {
+		t.Errorf("server hit %d times, want 1", n)
+	}
+}
+
+func TestRetriesUseAllAttempts(t *testing.T) {
+	srv, hits := statusServer(t, http.StatusServiceUnavailable)
+	cfg := Config{RetryMaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, RequestTimeout: 5 * time.Second}
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	_, _, err := doWithRetry(context.Background(), srv.Client(), req, cfg, "get task")
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want HTTP 503", err)
+	}
+	if n := hits(); n != 3 {
+		t.Errorf("server hit %d times, want 3", n)
+	}
+}
+
+// sessionClient sends a session token with each request and logs in again
+// through OnUnauthorized, like a session-based Prism client would.
+type sessionClient struct {
+	*http.Client
+	login func() (string, bool)
+
+	mu     sync.Mutex
+	token  string
+	logins int
+}
+
+func (c *sessionClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Session", token)
+	return c.Client.Do(req)
+}
+
+func (c *sessionClient) OnUnauthorized(context.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logins++
+	token, ok := c.login()
+	if ok {
+		c.token = token
+	}
+	return ok
+}
+
+func TestReauthOn401(t *testing.T) {
+	var mu sync.Mutex
+	valid, hits := "", 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		if valid == "" || r.Header.Get("X-Session") != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	// reset expires every session; issue, if true, has the next login succeed.
+	reset := func(issue bool) *sessionClient {
+		mu.Lock()
+		defer mu.Unlock()
+		valid, hits = "", 0
+		return &sessionClient{Client: srv.Client(), token: "expired", login: func() (string, bool) {
+			if !issue {
+				return "", false
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			valid = "fresh"
+			return valid, true
+		}}
+	}
+	served := func() int { mu.Lock(); defer mu.Unlock(); return hits }
+	cfg := Config{RetryMaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, RequestTimeout: 5 * time.Second}
+	get := func(c HTTPClient) error {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		_, body, err := doWithRetry(context.Background(), c, req, cfg, "get task")
+		if err == nil && string(body) != "ok" {
+			t.Errorf("body %q after re-login", body)
+		}
+		return err
+	}
+	is401 := func(err error) bool {
+		var he *HTTPError
+		return errors.As(err, &he) && he.StatusCode == http.StatusUnauthorized
+	}
+
+	// An expired session is renewed once and the request repeated.
+	c := reset(true)
+	if err := get(c); err != nil {
+		t.Fatalf("expired session: %v", err)
+	}
+	if n := served(); n != 2 || c.logins != 1 {
+		t.Errorf("expired session: %d requests, %d logins; want 2 and 1", n, c.logins)
+	}
+
+	// A failed login returns the 401 without repeating the request.
+	c = reset(false)
+	if err := get(c); !is401(err) || served() != 1 || c.logins != 1 {
+		t.Errorf("failed login: err %v, %d requests, %d logins", err, served(), c.logins)
+	}
+
+	// A session rejected again after logging in is not renewed a second time.
+	c = reset(true)
+	c.login = func() (string, bool) { return "still-bad", true }
+	if err := get(c); !is401(err) || served() != 2 || c.logins != 1 {
+		t.Errorf("second 401: err %v, %d requests, %d logins", err, served(), c.logins)
+	}
+
+	// A basic-auth client has no session to renew.
+	reset(false)
+	if err := get(srv.Client()); !is401(err) || served() != 1 {
+		t.Errorf("basic auth: err %v, %d requests", err, served())
+	}
+}
+
+func TestRetryStatsCount(t *testing.T) {
+	srv, _ := statusServer(t, http.StatusServiceUnavailable)
+	cfg := Config{RetryMaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, RequestTimeout: 5 * time.Second}
+	retryStats.take()
+	for _, op := range []string{"get task", "get task", "start checks"} {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		_, _, _ = doWithRetry(context.Background(), srv.Client(), req, cfg, op)
+	}
+	stats := retryStats.take()
+	ops := stats["127.0.0.1"]
+	// Every attempt but the last is followed by a retry.
+	if ops["get task"].Retries != 4 || ops["start checks"].Retries != 2 {
+		t.Fatalf("retry stats = %+v, want 4 get task and 2 start checks retries", stats)
+	}
+	if b := ops["get task"].Backoff; b <= 0 || b > 4*cfg.RetryMaxDelay {
+		t.Errorf("get task backoff = %s, want (0, %s]", b, 4*cfg.RetryMaxDelay)
+	}
+	if again := retryStats.take(); len(again) != 0 {
+		t.Errorf("take did not reset the counts: %+v", again)
+	}
+}
+
+func TestTransportErrorClass(t *testing.T) {
+	// A port with nothing listening.
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	refusedURL := "http://" + ln.Addr().String()
+	ln.Close()
+
+	// A server that resets every connection.
+	var mu sync.Mutex
+	resets := 0
+	rst, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { rst.Close() })
+	go func() {
+		for {
+			c, err := rst.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			resets++
+			mu.Unlock()
+			_ = c.(*net.TCPConn).SetLinger(0)
+			c.Close()
+		}
+	}()
+
+	// A server slower than the request timeout.
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	cfg := Config{RetryMaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, RequestTimeout: 5 * time.Second}
+	for _, tc := range []struct {
+		class string
+		url   string
+		httpc *http.Client
+	}{
+		{"refused", refusedURL, http.DefaultClient},
+		{"reset", "http://" + rst.Addr().String(), http.DefaultClient},
+		{"timeout", slow.URL, &http.Client{Timeout: 50 * time.Millisecond}},
+		{"other", "ftp://10.0.0.1/", http.DefaultClient},
+	} {
+		req, _ := http.NewRequest("GET", tc.url, nil)
+		_, err := tc.httpc.Do(req)
+		if got := transportErrorClass(err); got != tc.class {
+			t.Errorf("%s: classified %q as %s", tc.class, err, got)
+		}
+	}
+	for err, want := range map[error]string{
+		&url.Error{Op: "Get", Err: &net.DNSError{Err: "no such host", Name: "pe.invalid", IsNotFound: true}}: "dns",
+		&url.Error{Op: "Get", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.EPIPE)}}: "reset",
+		fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF):                                                       "reset",
+		context.DeadlineExceeded: "timeout",
+	} {
+		if got := transportErrorClass(err); got != want {
+			t.Errorf("transportErrorClass(%v) = %s, want %s", err, got, want)
+		}
+	}
+
+	// --no-retry-on fails a class on the first attempt; others use them all.
+	for _, noRetry := range [][]string{nil, {"reset"}} {
+		mu.Lock()
+		resets = 0
+		mu.Unlock()
+		c := cfg
+		c.NoRetryOn = noRetry
+		req, _ := http.NewRequest("POST", "http://"+rst.Addr().String(), nil)
+		_, _, err := doWithRetry(context.Background(), http.DefaultClient, req, c, "start checks")
+		if !errors.Is(err, &NCCError{Type: ErrorTypeNetwork}) || !strings.Contains(err.Error(), "(reset)") {
+			t.Errorf("noRetryOn=%v: err = %v, want a reset network error", noRetry, err)
+		}
+		want := 3
+		if noRetry != nil {
+			want = 1
+		}
+		mu.Lock()
+		if resets != want {
+			t.Errorf("noRetryOn=%v: %d connections, want %d", noRetry, resets, want)
+		}
+		mu.Unlock()
+	}
+}
+
+/************** HTTP client **************/
+
+// TestHTTP2Negotiated runs a retried POST through NewHTTPClient against an
+// h2 server: the replayed body must arrive intact over the negotiated
+// protocol, and --disable-http2 must fall back to HTTP/1.1.
+func TestHTTP2Negotiated(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		first := hits%2 == 1
+		mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.Proto, body)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	for _, tc := range []struct {
+		disable bool
+		want    string
+	}{
+		{false, "HTTP/2.0 payload"},
+		{true, "HTTP/1.1 payload"},
+	} {
+		cfg := Config{
+			InsecureSkipVerify: true,
+			DisableHTTP2:       tc.disable,
+			Timeout:            5 * time.Second,
+			RequestTimeout:     5 * time.Second,
+			RetryMaxAttempts:   2,
+			RetryBaseDelay:     time.Millisecond,
+			RetryMaxDelay:      time.Millisecond,
+		}
+		req, _ := http.NewRequest("POST", srv.URL, strings.NewReader("payload"))
+		_, body, err := doWithRetry(context.Background(), NewHTTPClient(cfg), req, cfg, "start checks")
+		if err != nil {
+			t.Fatalf("disableHTTP2=%v: %v", tc.disable, err)
+		}
+		if string(body) != tc.want {
+			t.Errorf("disableHTTP2=%v: got %q, want %q", tc.disable, body, tc.want)
+		}
+	}
+}
+
+func TestLoggingTransportInflatesDump(t *testing.T) {
+	const plain = `{"taskUuid":"plaintext-task"}`
+	compress := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+	}
+	for enc, newWriter := range compress {
+		var body bytes.Buffer
+		zw := newWriter(&body)
+		_, _ = zw.Write([]byte(plain))
+		zw.Close()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", enc)
+			_, _ = w.Write(body.Bytes())
+		}))
+		defer srv.Close()
+
+		buf := captureLog(t)
+		httpc := &http.Client{Transport: &LoggingTransport{Base: http.DefaultTransport, MaxBody: 64 * 1024}}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Accept-Encoding", enc) // so the transport leaves the body compressed
+		resp, err := httpc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.Equal(got, body.Bytes()) {
+			t.Errorf("%s: caller got %q, want the compressed bytes unchanged", enc, got)
+		}
+		if !strings.Contains(buf.String(), "plaintext-task") {
+			t.Errorf("%s: response dump not inflated: %s", enc, buf)
+		}
+	}
+
+	// The inflated dump is capped by MaxBody.
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	_, _ = zw.Write(bytes.Repeat([]byte("x"), 1<<20))
+	zw.Close()
+	resp := &http.Response{
+		StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{"Content-Encoding": {"gzip"}},
+		Body:   io.NopCloser(bytes.NewReader(body.Bytes())),
+	}
+	dump, err := (&LoggingTransport{MaxBody: 1024}).dumpResponse(resp)
+	if err != nil || len(dump) > 2048 {
+		t.Errorf("dump of a 1 MiB body: %d bytes, %v; want about MaxBody", len(dump), err)
+	}
+}
+
+/************** Mock Prism **************/
+
+// mockPrism is a TLS Prism Element that starts task "t1" and serves its
+// status and summary. GET /v2.0/tasks/t1 (or the v3 tasks/t1) walks
+// through tasks and then repeats the last entry; GET /v1/ncc/t1 does the
+// same with summaries. GET /v1/cluster answers with info, and GET
+// /v1/ncc/t1/logs with fullLog (404 while it is empty). POST bodies to
+// /v1/ncc/checks are kept in starts.
+type mockPrism struct {
+	srv     *httptest.Server
+	cluster string // host:port to pass as the cluster
+
+	mu         sync.Mutex
+	info       string
+	tasks      []string
+	summaries  []string
+	fullLog    string
+	starts     []string
+	polls      int
+	fetches    int
+	logFetches int
+}
+
+func newMockPrism(t *testing.T) *mockPrism {
+	t.Helper()
+	m := &mockPrism{
+		info:      `{"version":"6.5","nccVersion":"4.6.0"}`,
+		tasks:     []string{`{"percentage_complete":100,"progress_status":"Succeeded"}`},
+		summaries: []string{categorizedSummary},
+	}
+	m.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const base = "/PrismGateway/services/rest"
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		switch r.URL.Path {
+		case base + "/v1/ncc/checks":
+			b, _ := io.ReadAll(r.Body)
+			m.starts = append(m.starts, string(b))
+			_, _ = w.Write([]byte(`{"taskUuid":"t1"}`))
+		case base + "/v2.0/tasks/t1", "/api/nutanix/v3/tasks/t1":
+			_, _ = w.Write([]byte(m.tasks[min(m.polls, len(m.tasks)-1)]))
+			m.polls++
+		case base + "/v1/ncc/t1":
+			b, _ := json.Marshal(NCCSummary{RunSummary: m.summaries[min(m.fetches, len(m.summaries)-1)]})
+			_, _ = w.Write(b)
+			m.fetches++
+		case base + "/v1/ncc/t1/logs":
+			m.logFetches++
+			if m.fullLog == "" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(m.fullLog))
+		case base + "/v1/hosts":
+			_, _ = w.Write([]byte(`{"entities":[]}`))
+		case base + "/v1/cluster":
+			_, _ = w.Write([]byte(m.info))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(m.srv.Close)
+	u, _ := url.Parse(m.srv.URL)
+	m.cluster = u.Host
+	return m
+}
+
+// set replaces the task and summary sequences; nil keeps the current one.
+func (m *mockPrism) set(tasks, summaries []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tasks != nil {
+		m.tasks = tasks
+	}
+	if summaries != nil {
+		m.summaries = summaries
+	}
+	m.polls, m.fetches, m.logFetches = 0, 0, 0
+}
+
+// counts returns how many task polls and summary fetches were served.
+func (m *mockPrism) counts() (polls, fetches int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.polls, m.fetches
+}
+
+// runConfig is a minimal Config for runClusterWithBars against a mockPrism.
+func runConfig() Config {
+	return Config{
+		SkipNCCCheck:      true,
+		PollInterval:      time.Millisecond,
+		RequestTimeout:    5 * time.Second,
+		OutputDirLogs:     "/logs",
+		OutputDirFiltered: "/out",
+		OutputFormats:     []string{"html"},
+	}
+}
+
+func (m *mockPrism) run(cfg Config, fs FS) ([]ParsedBlock, error) {
+	return m.runCtx(context.Background(), cfg, fs, func(int) {})
+}
+
+func (m *mockPrism) runCtx(ctx context.Context, cfg Config, fs FS, onPct func(int)) ([]ParsedBlock, error) {
+	return runClusterWithBars(ctx, cfg, fs, m.srv.Client(), m.cluster, onPct, func(string) {})
+}
+
+// recordingClient passes requests to an HTTPClient and keeps a copy of
+// each one as sent. onDo, if set, is called with each request first.
+type recordingClient struct {
+	HTTPClient
+	onDo func(*http.Request)
+	mu   sync.Mutex
+	reqs []*http.Request
+}
+
+func (c *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	if c.onDo != nil {
+		c.onDo(req)
+	}
+	c.mu.Lock()
+	c.reqs = append(c.reqs, req.Clone(context.Background()))
+	c.mu.Unlock()
+	return c.HTTPClient.Do(req)
+}
+
+/************** Pre-flight **************/
+
+func TestNCCAvailabilityCheck(t *testing.T) {
+	m := newMockPrism(t)
+	cfg := runConfig()
+	cfg.SkipNCCCheck = false
+
+	c := NewNCCClient(m.cluster, "u", "p", m.srv.Client(), cfg)
+	if ver, err := c.NCCAvailabilityCheck(context.Background()); err != nil || ver != "4.6.0" {
+		t.Fatalf("NCCAvailabilityCheck = %q, %v", ver, err)
+	}
+
+	// The cluster API answers but reports no NCC: fail before starting checks.
+	m.mu.Lock()
+	m.info = `{"version":"5.10"}`
+	m.mu.Unlock()
+	rc := &recordingClient{HTTPClient: m.srv.Client()}
+	_, err := runClusterWithBars(context.Background(), cfg, NewMemFS(), rc, m.cluster, func(int) {}, func(string) {})
+	if !errors.Is(err, errNCCNotInstalled) {
+		t.Fatalf("run = %v, want errNCCNotInstalled", err)
+	}
+	for _, r := range rc.reqs {
+		if r.Method == "POST" {
+			t.Errorf("%s %s sent after a failed pre-flight", r.Method, r.URL.Path)
+		}
+	}
+}
+
+/************** Run summary **************/
+
+func TestRunSummaryFileRemoved(t *testing.T) {
+	m := newMockPrism(t)
+	rawPath := "/logs/" + sanitizeFilename(m.cluster) + ".summary.json"
+
+	fs := NewMemFS()
+	if _, err := m.run(runConfig(), fs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(rawPath); err == nil {
+		t.Fatalf("%s left behind after a successful run", rawPath)
+	}
+
+	// An empty runSummary fails the cluster once --summary-retries runs out.
+	m.set(nil, []string{""})
+	fs = NewMemFS()
+	if _, err := m.run(runConfig(), fs); err == nil {
+		t.Fatal("empty run summary accepted")
+	}
+	if _, err := fs.Stat(rawPath); err == nil {
+		t.Fatalf("%s left behind after a failed run", rawPath)
+	}
+}
+
+func TestResponseSizeLimits(t *testing.T) {
+	// The server streams a JSON body until the client hangs up, with a
+	// backstop so a missing cap fails rather than hangs.
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		_, _ = io.WriteString(w, `{"runSummary":"`)
+		chunk := strings.Repeat("x", 4<<10)
+		for range 16 << 10 / 4 { // 16 MiB
+			if _, err := io.WriteString(w, chunk); err != nil {
+				return
+			}
+		}
+		_, _ = io.WriteString(w, `"}`)
+	}))
+	defer srv.Close()
+	served := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := requests
+		requests = 0
+		return n
+	}
+	u, _ := url.Parse(srv.URL)
+	cfg := Config{RequestTimeout: 10 * time.Second, RetryMaxAttempts: 3, RetryBaseDelay: time.Millisecond, MaxResponseBytes: 32 << 10, MaxSummaryBytes: 64 << 10}
+	client := NewNCCClient(u.Host, "admin", "secret", srv.Client(), cfg)
+	ctx := context.Background()
+
+	if _, _, err := client.GetRunSummary(ctx, "t1"); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("GetRunSummary err = %v, want errResponseTooLarge", err)
+	}
+	if n := served(); n != 1 {
+		t.Errorf("oversized summary requested %d times, want 1 (no retries)", n)
+	}
+
+	fs := NewMemFS()
+	if _, _, err := client.GetRunSummaryToFile(ctx, "t1", fs, "/logs/c.summary.json"); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("GetRunSummaryToFile err = %v, want errResponseTooLarge", err)
+	}
+	if _, err := fs.Stat("/logs/c.summary.json"); err == nil {
+		t.Error("partial summary left behind")
+	}
+	served()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	if _, _, err := doWithRetry(ctx, srv.Client(), req, cfg, "get cluster"); !errors.Is(err, errResponseTooLarge) {
+		t.Errorf("API call err = %v, want errResponseTooLarge at --max-response-bytes", err)
+	}
+	served()
+
+	// 0 lifts the cap.
+	cfg.MaxSummaryBytes = 0
+	client = NewNCCClient(u.Host, "admin", "secret", srv.Client(), cfg)
+	if s, _, err := client.GetRunSummary(ctx, "t1"); err != nil || len(s.RunSummary) != 16<<20 {
+		t.Errorf("uncapped summary: %d bytes, err %v", len(s.RunSummary), err)
+	}
+}
+
+/************** Task polling **************/
+
+// Task bodies as returned by the v2.0 and v3 task APIs.
+const (
+	taskV2Running   = `{"uuid":"t1","percentage_complete":40,"progress_status":"Running","operation_type":"ncc_run"}`
+	taskV2Succeeded = `{"uuid":"t1","percentage_complete":100,"progress_status":"Succeeded","operation_type":"ncc_run"}`
+	taskV3Running   = `{"uuid":"t1","percentage_complete":40,"status":"RUNNING","operation_type":"kNccRun"}`
+	taskV3Succeeded = `{"uuid":"t1","percentage_complete":100,"status":"SUCCEEDED","operation_type":"kNccRun"}`
+)
+
+func TestTaskSchemas(t *testing.T) {
+	for _, tc := range []struct {
+		body, version string
+		pct           int
+		status        string
+		done          bool
+	}{
+		{taskV2Running, "auto", 40, "Running", false},
+		{taskV2Succeeded, "v2", 100, "Succeeded", true},
+		{taskV3Running, "auto", 40, "RUNNING", false},
+		{taskV3Succeeded, "auto", 100, "SUCCEEDED", true},
+		{taskV3Succeeded, "v3", 100, "SUCCEEDED", true},
+		{taskV3Succeeded, "v2", 100, "", false}, // forced v2 ignores v3's field
+	} {
+		st, err := decodeTaskStatus([]byte(tc.body), tc.version)
+		if err != nil || st.PercentageComplete != tc.pct || st.ProgressStatus != tc.status {
+			t.Errorf("%s as %s = %+v, %v", tc.body, tc.version, st, err)
+		}
+		if done, _ := taskState(st.ProgressStatus); done != tc.done {
+			t.Errorf("%s as %s: succeeded = %v, want %v", tc.body, tc.version, done, tc.done)
+		}
+	}
+
+	// Both shapes drive a run to completion, on the endpoint of the forced version.
+	for _, tc := range []struct {
+		version string
+		tasks   []string
+		path    string
+	}{
+		{"auto", []string{taskV2Running, taskV2Succeeded}, "/PrismGateway/services/rest/v2.0/tasks/t1"},
+		{"auto", []string{taskV3Running, taskV3Succeeded}, "/PrismGateway/services/rest/v2.0/tasks/t1"},
+		{"v3", []string{taskV3Running, taskV3Succeeded}, "/api/nutanix/v3/tasks/t1"},
+	} {
+		m := newMockPrism(t)
+		m.set(tc.tasks, nil)
+		cfg := runConfig()
+		cfg.TaskAPIVersion = tc.version
+		rc := &recordingClient{HTTPClient: m.srv.Client()}
+		blocks, err := runClusterWithBars(context.Background(), cfg, NewMemFS(), rc, m.cluster, func(int) {}, func(string) {})
+		if err != nil || len(blocks) != 2 {
+			t.Errorf("%s %s: %d blocks, %v", tc.version, tc.tasks[0], len(blocks), err)
+			continue
+		}
+		if !slices.ContainsFunc(rc.reqs, func(r *http.Request) bool { return r.URL.Path == tc.path }) {
+			t.Errorf("%s: task not polled at %s", tc.version, tc.path)
+		}
+	}
+}
+
+func TestProgressHeldBelow100UntilTerminal(t *testing.T) {
+	m := newMockPrism(t)
+	m.set([]string{
+		`{"percentage_complete":40,"progress_status":"Running"}`,
+		`{"percentage_complete":100,"progress_status":"Running"}`,
+		`{"percentage_complete":30,"progress_status":"Running"}`,
+		`{"percentage_complete":100,"progress_status":"Succeeded"}`,
+	}, nil)
+	var pcts []int
+	if _, err := m.runCtx(context.Background(), runConfig(), NewMemFS(), func(p int) { pcts = append(pcts, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if polls, _ := m.counts(); polls != 4 {
+		t.Fatalf("polled %d times, want 4: a Running task at 100%% must not end polling", polls)
+	}
+	if want := []int{1, 40, 99, 99, 100}; !slices.Equal(pcts, want) {
+		t.Fatalf("progress = %v, want %v", pcts, want)
+	}
+}
+
+func TestEmptySummaryRetried(t *testing.T) {
+	m := newMockPrism(t)
+	m.set(nil, []string{"", "  \n", categorizedSummary})
+	cfg := runConfig()
+	cfg.SummaryRetries = 2
+	cfg.SummaryRetryDelay = time.Millisecond
+	blocks, err := m.run(cfg, NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, fetches := m.counts(); fetches != 3 {
+		t.Errorf("summary fetched %d times, want 3", fetches)
+	}
+	if len(blocks) != 2 {
+		t.Errorf("got %d blocks from the populated summary, want 2", len(blocks))
+	}
+
+	m.set(nil, []string{"", "", "", categorizedSummary})
+	if _, err := m.run(cfg, NewMemFS()); err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("err = %v, want empty summary after 3 attempts", err)
+	}
+}
+
+func TestFailOnEmpty(t *testing.T) {
+	const clean = "Plugins run: 120\nPASS: 120\n"
+	m := newMockPrism(t)
+	m.set(nil, []string{clean})
+	cfg := runConfig()
+	blocks, err := m.run(cfg, NewMemFS())
+	if err != nil || len(blocks) != 0 {
+		t.Fatalf("clean run: %d blocks, err %v; want a pass without --fail-on-empty", len(blocks), err)
+	}
+
+	cfg.FailOnEmpty = true
+	_, err = m.run(cfg, NewMemFS())
+	var ne *NCCError
+	if !errors.As(err, &ne) || ne.Type != ErrorTypeValidation || !strings.Contains(ne.Message, "--fail-on-empty") {
+		t.Fatalf("err = %v, want a --fail-on-empty validation error", err)
+	}
+
+	m.set(nil, []string{categorizedSummary})
+	if blocks, err := m.run(cfg, NewMemFS()); err != nil || len(blocks) == 0 {
+		t.Errorf("summary with findings: %d blocks, err %v", len(blocks), err)
+	}
+}
+
+func TestDecodeRunSummary(t *testing.T) {
+	long := strings.Repeat("x", 70<<10) // longer than the 64 KiB read buffer
+	docs := []string{
+		`{"runSummary":"plain"}`,
+		` { "other" : 1 , "runSummary" : "a\nb\tc \"q\" \\ \/ \u00e9 \ud83d\ude00 \ud83d x" , "x": null } `,
+		`{"nested":{"a":[1,"]}\"",{"b":true}],"runSummary":"decoy"},"runSummary":"real"}`,
+		`{"runSummary":null}`,
+		`{}`,
+		`{"runSummary":"` + long + `\"` + long + `\u0041"}`,
+		`{"runSummary":"line\\nnot an escape \\"}`,
+	}
+	for _, doc := range docs {
+		var want NCCSummary
+		if err := json.Unmarshal([]byte(doc), &want); err != nil {
+			t.Fatalf("fixture %.40q: %v", doc, err)
+		}
+		for name, r := range map[string]io.Reader{
+			"whole":    strings.NewReader(doc),
+			"one byte": iotest.OneByteReader(strings.NewReader(doc)),
+		} {
+			got, err := decodeRunSummary(r, int64(len(doc)))
+			if err != nil || got != want.RunSummary {
+				t.Errorf("%s %.40q: got %.40q, %v; want %.40q", name, doc, got, err, want.RunSummary)
+			}
+		}
+	}
+	for _, bad := range []string{``, `[]`, `{"runSummary":"open`, `{"runSummary":"\q"}`, `{"runSummary":"x" "y"}`, `{"runSummary":"\u12"}`} {
+		if _, err := decodeRunSummary(strings.NewReader(bad), 0); !errors.Is(err, errBadSummaryJSON) {
+			t.Errorf("%q: err = %v, want errBadSummaryJSON", bad, err)
+		}
+	}
+}
+
+// BenchmarkGetRunSummary compares allocations of the buffered and streamed
+// summary fetch for an 8 MiB runSummary; run with -benchmem.
+func BenchmarkGetRunSummary(b *testing.B) {
+	payload, _ := json.Marshal(NCCSummary{RunSummary: strings.Repeat("WARN: x\n", 1<<20)})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(payload)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	client := NewNCCClient(u.Host, "admin", "secret", srv.Client(), Config{RequestTimeout: time.Minute})
+	path := filepath.Join(b.TempDir(), "c.summary.json")
+	// At debug level the buffered fetch also logs the whole body.
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := client.GetRunSummary(context.Background(), "t1"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, _, err := client.GetRunSummaryToFile(context.Background(), "t1", OSFS{}, path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestPollBackoffIntervals(t *testing.T) {
+	const base, maxInterval = 15 * time.Second, 2 * time.Minute
+	delays := func(mode string, pct int) (out []time.Duration) {
+		for polls := range 10 {
+			out = append(out, pollDelay(mode, base, maxInterval, polls, pct))
+		}
+		return out
+	}
+	s := time.Second
+	for _, tc := range []struct {
+		mode string
+		pct  int
+		want []time.Duration
+	}{
+		{"none", 40, []time.Duration{15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s}},
+		{"exponential", 40, []time.Duration{15 * s, 30 * s, 60 * s, 120 * s, 120 * s, 120 * s, 120 * s, 120 * s, 120 * s, 120 * s}},
+		{"stepped", 40, []time.Duration{15 * s, 15 * s, 15 * s, 15 * s, 30 * s, 30 * s, 30 * s, 30 * s, 45 * s, 45 * s}},
+		// Near completion the base interval is used again.
+		{"exponential", pollNearDone, []time.Duration{15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s, 15 * s}},
+	} {
+		if got := delays(tc.mode, tc.pct); !slices.Equal(got, tc.want) {
+			t.Errorf("%s at %d%%: %v, want %v", tc.mode, tc.pct, got, tc.want)
+		}
+	}
+	if d := pollDelay("exponential", base, base, 5, 0); d != base {
+		t.Errorf("max interval at the base: %s, want %s", d, base)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	m := newMockPrism(t)
+	m.set([]string{`{"percentage_complete":50,"progress_status":"Running"}`}, nil)
+	cfg := runConfig()
+	cfg.PollTimeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := m.run(cfg, NewMemFS())
+	if !errors.Is(err, &NCCError{Type: ErrorTypeTimeout}) || !strings.Contains(err.Error(), "poll timeout") {
+		t.Fatalf("err = %v, want a poll timeout", err)
+	}
+	if !strings.Contains(err.Error(), "50%") {
+		t.Errorf("error %q does not report the last progress", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("poll timeout took %s", elapsed)
+	}
+	if _, fetches := m.counts(); fetches != 0 {
+		t.Error("summary fetched after the poll timeout")
+	}
+
+	// The cluster deadline still wins when it is the shorter one, and is
+	// reported as the context error rather than a poll timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cfg.PollTimeout = time.Hour
+	_, err = m.runCtx(ctx, cfg, NewMemFS(), func(int) {})
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "poll timeout") {
+		t.Fatalf("err = %v, want the cluster deadline", err)
+	}
+}
+
+/************** Batch **************/
+
+const runningTask = `{"percentage_complete":10,"progress_status":"Running"}`
+
+// batchConfig is runConfig for runBatch over clusters, with console output
+// and progress bars off.
+func batchConfig(clusters ...string) Config {
+	cfg := runConfig()
+	cfg.Clusters = clusters
+	cfg.Timeout = time.Minute
+	cfg.MaxParallel = len(clusters)
+	cfg.Quiet = true
+	cfg.Progress = "none"
+	cfg.AggregatedFilename = "index.html"
+	return cfg
+}
+
+// batchSummaries reads the per-cluster statuses from the aggregated JSON,
+// which must list each cluster once.
+func batchSummaries(t *testing.T, fs FS, cfg Config) map[string]ClusterSummary {
+	t.Helper()
+	b, err := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct{ Clusters []ClusterSummary }
+	if err := json.Unmarshal(b, &report); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]ClusterSummary{}
+	for _, s := range report.Clusters {
+		if _, dup := out[s.Cluster]; dup {
+			t.Errorf("%s listed more than once in the aggregated JSON", s.Cluster)
+		}
+		out[s.Cluster] = s
+	}
+	return out
+}
+
+func TestMaxTotalRuntimeAbortsSlowClusters(t *testing.T) {
+	var clusters []string
+	var httpc HTTPClient
+	for range 3 {
+		m := newMockPrism(t)
+		m.set([]string{runningTask}, nil)
+		clusters = append(clusters, m.cluster)
+		httpc = m.srv.Client() // every httptest TLS server shares one cert
+	}
+	cfg := batchConfig(clusters...)
+	cfg.MaxParallel = 2 // the third cluster never gets a slot
+	cfg.MaxTotalRuntime = 100 * time.Millisecond
+
+	fs := NewMemFS()
+	start := time.Now()
+	err := runBatch(context.Background(), cfg, fs, httpc)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("runBatch took %s with a %s deadline", d, cfg.MaxTotalRuntime)
+	}
+	var merr *MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != len(clusters) {
+		t.Fatalf("runBatch = %v, want every cluster failed", err)
+	}
+	if !errors.Is(err, &NCCError{Type: ErrorTypeTimeout}) {
+		t.Errorf("runBatch error type = %s, want timeout", errorType(err))
+	}
+	for cl, s := range batchSummaries(t, fs, cfg) {
+		if s.Status != "aborted" || !strings.Contains(s.Error, "max total runtime") {
+			t.Errorf("%s: status %q error %q, want aborted by max total runtime", cl, s.Status, s.Error)
+		}
+	}
+}
+
+func TestClusterStatusTableListsEachClusterOnce(t *testing.T) {
+	ok := newMockPrism(t)
+	failed := newMockPrism(t)
+	failed.set([]string{`{"percentage_complete":100,"progress_status":"Failed"}`}, nil)
+	down := newMockPrism(t)
+	down.srv.Close()
+
+	cfg := batchConfig(ok.cluster, failed.cluster, down.cluster)
+	cfg.RetryMaxAttempts = 1
+	fs := NewMemFS()
+	if err := runBatch(context.Background(), cfg, fs, ok.srv.Client()); err == nil {
+		t.Fatal("runBatch succeeded with two failing clusters")
+	}
+
+	sums := batchSummaries(t, fs, cfg)
+	if len(sums) != 3 {
+		t.Fatalf("index.json lists %d clusters, want 3", len(sums))
+	}
+	for _, tc := range []struct {
+		cluster            string
+		status             string
+		reachable, started bool
+	}{
+		{ok.cluster, "ok", true, true},
+		{failed.cluster, "failed", true, true},
+		{down.cluster, "failed", false, false},
+	} {
+		s := sums[tc.cluster]
+		if s.Status != tc.status || s.Reachable != tc.reachable || s.Started != tc.started {
+			t.Errorf("%s: %+v, want status %s reachable %v started %v", tc.cluster, s, tc.status, tc.reachable, tc.started)
+		}
+	}
+
+	html, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, cfg.AggregatedFilename))
+	if n := bytes.Count(html, []byte(`<tr class="st-`)); n != 3 {
+		t.Errorf("status table has %d rows, want 3", n)
+	}
+}
+
+func TestBatchOrderIgnoresCompletionOrder(t *testing.T) {
+	var ms []*mockPrism
+	var clusters []string
+	for range 3 {
+		m := newMockPrism(t)
+		ms = append(ms, m)
+		clusters = append(clusters, m.cluster)
+	}
+	// run makes the cluster at slow finish last and returns the cluster
+	// order and results from index.json.
+	run := func(order string, slow int) (names []string, results string) {
+		t.Helper()
+		for i, m := range ms {
+			tasks := []string{`{"percentage_complete":100,"progress_status":"Succeeded"}`}
+			if i == slow {
+				tasks = append(slices.Repeat([]string{runningTask}, 50), tasks...)
+			}
+			m.set(tasks, nil)
+		}
+		cfg := batchConfig(clusters...)
+		cfg.SortOrder = order
+		fs := NewMemFS()
+		if err := runBatch(context.Background(), cfg, fs, ms[0].srv.Client()); err != nil {
+			t.Fatal(err)
+		}
+		b, err := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var report struct {
+			Clusters []ClusterSummary
+			Results  json.RawMessage
+		}
+		if err := json.Unmarshal(b, &report); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range report.Clusters {
+			names = append(names, s.Cluster)
+		}
+		return names, string(report.Results)
+	}
+
+	for _, order := range []string{"severity", "cluster", "original"} {
+		names, want := run(order, 0)
+		if !slices.Equal(names, clusters) {
+			t.Errorf("%s: clusters listed as %v, want configured order %v", order, names, clusters)
+		}
+		for slow := 1; slow < len(ms); slow++ {
+			if _, got := run(order, slow); got != want {
+				t.Errorf("%s: results changed when cluster %d finished last", order, slow)
+			}
+		}
+	}
+}
+
+func TestOnComplete(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook scripts are POSIX shell")
+	}
+	m := newMockPrism(t)
+	out := filepath.Join(t.TempDir(), "env")
+	cfg := batchConfig(m.cluster)
+	cfg.RunID = "run-1"
+	cfg.OnComplete = `env | grep '^NCC_' | sort > "` + out + `"`
+	if err := runBatch(context.Background(), cfg, NewMemFS(), m.srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("on-complete command did not run: %v", err)
+	}
+	env := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for _, want := range []string{
+		"NCC_RUN_ID=run-1",
+		"NCC_OUTPUT_DIR=/out",
+		"NCC_TOTAL=1",
+		"NCC_SUCCEEDED_COUNT=1",
+		"NCC_FAILED_COUNT=0",
+		"NCC_FAIL_COUNT=1",
+		"NCC_WARN_COUNT=1",
+		"NCC_ERR_COUNT=0",
+		"NCC_INFO_COUNT=0",
+	} {
+		if !slices.Contains(env, want) {
+			t.Errorf("%s missing from the hook environment %q", want, env)
+		}
+	}
+
+	cfg.OnComplete = "exit 3"
+	if err := runBatch(context.Background(), cfg, NewMemFS(), m.srv.Client()); err != nil {
+		t.Errorf("failing hook failed the run without --on-complete-strict: %v", err)
+	}
+	cfg.OnCompleteStrict = true
+	if err := runBatch(context.Background(), cfg, NewMemFS(), m.srv.Client()); err == nil || !strings.Contains(err.Error(), "on-complete") {
+		t.Errorf("err = %v, want the on-complete failure with --on-complete-strict", err)
+	}
+}
+
+func TestMinRecheckInterval(t *testing.T) {
+	m := newMockPrism(t)
+	starts := func() int {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.starts)
+	}
+	cfg := batchConfig(m.cluster)
+	cfg.MinRecheckInterval = time.Hour
+	cfg.RecheckCacheTTL = 24 * time.Hour
+	cfg.RecheckCache = filepath.Join(t.TempDir(), "recheck-cache.json")
+	fs := NewMemFS()
+	batch := func(fs FS) ClusterSummary {
+		t.Helper()
+		if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+			t.Fatal(err)
+		}
+		return batchSummaries(t, fs, cfg)[m.cluster]
+	}
+
+	if s := batch(fs); s.Cached || starts() != 1 {
+		t.Fatalf("first run: cached %v, %d starts", s.Cached, starts())
+	}
+	cache := loadRecheckCache(cfg.RecheckCache, cfg.RecheckCacheTTL, time.Now())
+	if e, ok := cache[m.cluster]; !ok || e.Filtered != "/out/"+sanitizeFilename(m.cluster)+".log" {
+		t.Fatalf("cache after a successful run = %+v", cache)
+	}
+
+	// Within the interval the last run is reused, not rerun.
+	if s := batch(fs); !s.Cached || s.Status != "ok" || starts() != 1 {
+		t.Errorf("recent run: cached %v status %s, %d starts; want reuse", s.Cached, s.Status, starts())
+	}
+	if _, err := fs.Stat(filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(m.cluster)+".log.html")); err != nil {
+		t.Errorf("reused run has no report: %v", err)
+	}
+
+	// A run older than the interval, or one whose log is gone, runs again.
+	cache[m.cluster] = recheckEntry{Time: time.Now().Add(-2 * time.Hour), Filtered: cache[m.cluster].Filtered}
+	if err := saveRecheckCache(cfg.RecheckCache, cache); err != nil {
+		t.Fatal(err)
+	}
+	if s := batch(fs); s.Cached || starts() != 2 {
+		t.Errorf("stale entry: cached %v, %d starts; want a rerun", s.Cached, starts())
+	}
+	if s := batch(NewMemFS()); s.Cached || starts() != 3 {
+		t.Errorf("missing log: cached %v, %d starts; want a rerun", s.Cached, starts())
+	}
+
+	// Entries past the TTL are dropped on load.
+	if got := loadRecheckCache(cfg.RecheckCache, time.Nanosecond, time.Now().Add(time.Minute)); len(got) != 0 {
+		t.Errorf("entries past the TTL kept: %v", got)
+	}
+}
+
+// TestBatchConcurrentClusters renders many clusters at once onto the real
+// filesystem, one of them panicking mid-poll. Run it with -race to check
+// that the workers and the collector share no unsynchronised state.
+func TestBatchConcurrentClusters(t *testing.T) {
+	var ms []*mockPrism
+	var clusters []string
+	for range 24 {
+		m := newMockPrism(t)
+		m.set([]string{runningTask, runningTask, `{"percentage_complete":100,"progress_status":"Succeeded"}`}, nil)
+		ms = append(ms, m)
+		clusters = append(clusters, m.cluster)
+	}
+	bad := clusters[7]
+	httpc := &recordingClient{HTTPClient: ms[0].srv.Client(), onDo: func(r *http.Request) {
+		if r.URL.Host == bad && strings.Contains(r.URL.Path, "/tasks/") {
+			panic("poll blew up")
+		}
+	}}
+
+	dir := t.TempDir()
+	cfg := batchConfig(clusters...)
+	cfg.OutputDirLogs = filepath.Join(dir, "logs")
+	cfg.OutputDirFiltered = filepath.Join(dir, "out")
+	cfg.OutputFormats = []string{"html", "csv", "jsonl"}
+	cfg.CombinedOutput = true
+	err := runBatch(context.Background(), cfg, OSFS{}, httpc)
+	if err == nil {
+		t.Fatal("runBatch succeeded with a panicking cluster")
+	}
+
+	sums := batchSummaries(t, OSFS{}, cfg)
+	if len(sums) != len(clusters) {
+		t.Fatalf("index.json lists %d clusters, want %d", len(sums), len(clusters))
+	}
+	for _, cl := range clusters {
+		want := "ok"
+		if cl == bad {
+			want = "failed"
+		}
+		if s := sums[cl]; s.Status != want {
+			t.Errorf("%s: status %s, want %s", cl, s.Status, want)
+		}
+		if cl == bad {
+			continue
+		}
+		base := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(cl))
+		for _, ext := range []string{".log.html", ".log.csv", ".log.jsonl"} {
+			if _, err := os.Stat(base + ext); err != nil {
+				t.Errorf("%s: %v", cl, err)
+			}
+		}
+	}
+	entries, _ := os.ReadDir(cfg.OutputDirFiltered)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestTemplateVars(t *testing.T) {
+	vars, err := parseTemplateVars([]string{"ticket=CHG-1", "operator=first", "operator=<b>ops</b>", "note=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"ticket": "CHG-1", "operator": "<b>ops</b>", "note": "a=b"}
+	if fmt.Sprint(vars) != fmt.Sprint(want) {
+		t.Errorf("vars = %v, want %v", vars, want)
+	}
+	for _, bad := range []string{"novalue", "=x", "bad key=x"} {
+		if _, err := parseTemplateVars([]string{bad}); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("%q: err = %v, want a config error", bad, err)
+		}
+	}
+
+	m := newMockPrism(t)
+	cfg := batchConfig(m.cluster)
+	cfg.TemplateVars = vars
+	fs := NewMemFS()
+	if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{cfg.AggregatedFilename, sanitizeFilename(m.cluster) + ".log.html"} {
+		html, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, name))
+		if !bytes.Contains(html, []byte("CHG-1")) || !bytes.Contains(html, []byte("&lt;b&gt;ops&lt;/b&gt;")) {
+			t.Errorf("%s: metadata missing or not escaped", name)
+		}
+		if bytes.Contains(html, []byte("<b>ops</b>")) {
+			t.Errorf("%s: metadata rendered as HTML", name)
+		}
+	}
+	b, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename)))
+	var report struct{ Metadata map[string]string }
+	if err := json.Unmarshal(b, &report); err != nil || fmt.Sprint(report.Metadata) != fmt.Sprint(want) {
+		t.Errorf("index.json metadata = %v, %v", report.Metadata, err)
+	}
+}
+
+/************** Logging **************/
+
+// captureLog sends the global logger to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	orig := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = orig })
+	return &buf
+}
+
+func TestRunStatsLogged(t *testing.T) {
+	buf := captureLog(t)
+	var out bytes.Buffer
+	agg := []AggBlock{{Severity: "FAIL"}, {Severity: "FAIL"}, {Severity: "WARN"}, {Severity: "INFO"}}
+	logRunStats(Console{W: &out}, 3, 1, agg, 90*time.Second)
+
+	var ev map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("log line %q: %v", buf, err)
+	}
+	want := map[string]float64{
+		"total_clusters": 3, "succeeded": 2, "failed": 1,
+		"fail_count": 2, "err_count": 0, "warn_count": 1, "info_count": 1,
+		"duration_seconds": 90,
+	}
+	for k, v := range want {
+		if ev[k] != v {
+			t.Errorf("%s = %v, want %v", k, ev[k], v)
+		}
+	}
+	if ev["message"] != "run summary" {
+		t.Errorf("message = %v", ev["message"])
+	}
+	if got := out.String(); got != "Clusters: 3 total, 2 succeeded, 1 failed | FAIL 2, ERR 0, WARN 1, INFO 1 | 1m30s\n" {
+		t.Errorf("console line = %q", got)
+	}
+
+	out.Reset()
+	logRunStats(Console{W: &out, Quiet: true}, 3, 1, agg, time.Second)
+	if out.Len() != 0 {
+		t.Errorf("--quiet printed %q", out.String())
+	}
+}
+
+func TestClusterTimeoutOverride(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("cluster-timeouts", []map[string]any{
+		{"cluster": "10.0.0.1", "timeout": "40m"},
+		{"cluster": "pe2.example.com:9441", "timeout": "5m"},
+	})
+	timeouts, err := loadClusterTimeouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Timeout: 15 * time.Minute, ClusterTimeouts: timeouts}
+	for cluster, want := range map[string]time.Duration{
+		"10.0.0.1":             40 * time.Minute,
+		"pe2.example.com:9441": 5 * time.Minute,
+		"10.0.0.2":             15 * time.Minute, // no entry: --timeout
+	} {
+		if got := clusterTimeout(cfg, cluster); got != want {
+			t.Errorf("clusterTimeout(%s) = %s, want %s", cluster, got, want)
+		}
+	}
+
+	for _, bad := range []string{"soon", "0s", "-1m"} {
+		viper.Set("cluster-timeouts", []map[string]any{{"cluster": "10.0.0.1", "timeout": bad}})
+		if _, err := loadClusterTimeouts(); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("timeout %q: err = %v, want a config error", bad, err)
+		}
+	}
+
+	// In a batch the override bounds its cluster; the other falls back.
+	slow, fast := newMockPrism(t), newMockPrism(t)
+	slow.set([]string{runningTask}, nil)
+	bcfg := batchConfig(slow.cluster, fast.cluster)
+	bcfg.ClusterTimeouts = map[string]time.Duration{slow.cluster: 50 * time.Millisecond}
+	fs := NewMemFS()
+	err = runBatch(context.Background(), bcfg, fs, slow.srv.Client())
+	var merr *MultiError
+	if !errors.As(err, &merr) || !slices.Equal(merr.Clusters(), []string{slow.cluster}) {
+		t.Fatalf("runBatch = %v, want only %s failed", err, slow.cluster)
+	}
+	if !errors.Is(err, &NCCError{Type: ErrorTypeTimeout}) {
+		t.Errorf("error type = %s, want timeout", errorType(err))
+	}
+	if s := batchSummaries(t, fs, bcfg)[fast.cluster]; s.Status != "ok" {
+		t.Errorf("%s: status %q, want ok under the --timeout fallback", fast.cluster, s.Status)
+	}
+}
+
+func TestSummaryOnlyOmitsDetail(t *testing.T) {
+	m := newMockPrism(t)
+	for _, summaryOnly := range []bool{false, true} {
+		cfg := batchConfig(m.cluster)
+		cfg.OutputFormats = []string{"html", "csv", "jsonl"}
+		cfg.SummaryOnly = summaryOnly
+		fs := NewMemFS()
+		if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+			t.Fatal(err)
+		}
+		entries, _ := fs.ReadDir(cfg.OutputDirFiltered)
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) == ".log" {
+				continue
+			}
+			data, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, e.Name()))
+			if has := bytes.Contains(data, []byte("Disk usage above 90%")); has == summaryOnly {
+				t.Errorf("summaryOnly=%v: %s has detail text: %v", summaryOnly, e.Name(), has)
+			}
+		}
+
+		b, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, aggregatedJSONName(cfg.AggregatedFilename)))
+		var report map[string]json.RawMessage
+		if err := json.Unmarshal(b, &report); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := report["results"]; ok == summaryOnly {
+			t.Errorf("summaryOnly=%v: results present: %v", summaryOnly, ok)
+		}
+		var counts map[string]int
+		if err := json.Unmarshal(report["summary"], &counts); err != nil || counts["FAIL"] != 1 || counts["WARN"] != 1 {
+			t.Errorf("summaryOnly=%v: summary = %s", summaryOnly, report["summary"])
+		}
+	}
+}
+
+/************** Elasticsearch **************/
+
+// bulkServer emulates the _bulk endpoint: it answers the first request with
+// 503, then records each action/document pair and rejects documents whose
+// check is "rejected".
+func bulkServer(t *testing.T) (*httptest.Server, func() []map[string]any) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls int
+	var lines []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" || r.Header.Get("Authorization") != "ApiKey k1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var items []string
+		dec := json.NewDecoder(r.Body)
+		for {
+			var action, doc map[string]any
+			if dec.Decode(&action) != nil || dec.Decode(&doc) != nil {
+				break
+			}
+			lines = append(lines, action, doc)
+			if doc["check"] == "rejected" {
+				items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"bad"}}}`)
+			} else {
+				items = append(items, `{"index":{"status":201}}`)
+			}
+		}
+		fmt.Fprintf(w, `{"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []map[string]any { mu.Lock(); defer mu.Unlock(); return slices.Clone(lines) }
+}
+
+func TestElasticBulk(t *testing.T) {
+	srv, lines := bulkServer(t)
+	cfg := Config{
+		ElasticURL: srv.URL + "/", ElasticIndex: "ncc", ElasticAPIKey: "k1", RunID: "run1",
+		TemplateVars:     map[string]string{"ticket": "CHG-1"},
+		RetryMaxAttempts: 2, RetryBaseDelay: time.Millisecond, RetryMaxDelay: time.Millisecond, RequestTimeout: 5 * time.Second,
+	}
+	rows := []AggBlock{
+		{Cluster: "c1", Severity: "FAIL", Check: "disk_usage_check", CheckID: "disk_usage_check"},
+		{Cluster: "c2", Severity: "WARN", Check: "ntp_check", CheckID: "ntp_check"},
+	}
+	n := NewElasticNotifier(cfg, srv.Client())
+	if err := n.Notify(context.Background(), rows, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got := lines()
+	if len(got) != 4 {
+		t.Fatalf("%d bulk lines indexed, want 4", len(got))
+	}
+	for i, r := range rows {
+		action, doc := got[2*i]["index"].(map[string]any), got[2*i+1]
+		if action["_index"] != "ncc" || action["_id"] != elasticDocID("run1", r) {
+			t.Errorf("row %d action = %v", i, action)
+		}
+		if doc["cluster"] != r.Cluster || doc["run_id"] != "run1" || doc["@timestamp"] == nil || doc["metadata"] == nil {
+			t.Errorf("row %d doc = %v", i, doc)
+		}
+	}
+	if elasticDocID("run1", rows[0]) == elasticDocID("run2", rows[0]) || elasticDocID("run1", rows[0]) == elasticDocID("run1", rows[1]) {
+		t.Error("document IDs do not separate runs and rows")
+	}
+	// Per-address rows share a CheckID but are separate documents.
+	perHost := []AggBlock{
+		{Cluster: "c1", Severity: "FAIL", Check: "Results of disk_usage_check on host 10.0.0.1:9440", CheckID: "disk_usage_check", Detail: "FAIL: /home 95% full"},
+		{Cluster: "c1", Severity: "FAIL", Check: "Results of disk_usage_check on host 10.0.0.2:9440", CheckID: "disk_usage_check", Detail: "FAIL: /home 95% full"},
+	}
+	if err := n.Notify(context.Background(), perHost, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[any]bool{}
+	for _, l := range lines()[len(got):] {
+		if action, ok := l["index"].(map[string]any); ok {
+			ids[action["_id"]] = true
+		}
+	}
+	if len(ids) != 2 {
+		t.Errorf("two per-address rows indexed as %d documents, want 2", len(ids))
+	}
+
+	// Rejected documents come back with HTTP 200 but still fail Notify.
+	rows = append(rows, AggBlock{Cluster: "c1", Check: "rejected"})
+	if err := n.Notify(context.Background(), rows, time.Now()); err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Errorf("Notify with a rejected document = %v", err)
+	}
+
+	// An unreachable index is logged but does not fail the run.
+	srv.Close()
+	m := newMockPrism(t)
+	bcfg := batchConfig(m.cluster)
+	bcfg.ElasticEnabled, bcfg.ElasticURL, bcfg.ElasticIndex = true, srv.URL, "ncc"
+	if err := runBatch(context.Background(), bcfg, NewMemFS(), m.srv.Client()); err != nil {
+		t.Errorf("runBatch with elastic down = %v", err)
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	rot := logRotation{MaxSize: 100, MaxBackups: 14, MaxAge: 90, Compress: false}
+	if err := rot.validate(); err != nil {
+		t.Fatal(err)
+	}
+	w := newLogFileWriter("/var/log/ncc.log", rot)
+	if w.Filename != "/var/log/ncc.log" || w.MaxSize != 100 || w.MaxBackups != 14 || w.MaxAge != 90 || w.Compress {
+		t.Errorf("lumberjack.Logger = %+v", w)
+	}
+	for _, bad := range []logRotation{{MaxSize: 0}, {MaxSize: 1, MaxBackups: -1}, {MaxSize: 1, MaxAge: -1}} {
+		if err := bad.validate(); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("%+v: err = %v, want a config error", bad, err)
+		}
+	}
+
+	orig := log.Logger
+	t.Cleanup(func() { log.Logger = orig })
+	path := filepath.Join(t.TempDir(), "logs", "ncc.log")
+	if err := setupFileLogger(path, rot, zerolog.InfoLevel, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	log.Info().Msg("rotation test")
+	if b, err := os.ReadFile(path); err != nil || !bytes.Contains(b, []byte("rotation test")) {
+		t.Errorf("log file = %q, %v", b, err)
+	}
+}
+
+func TestLogStdoutGetsFileEvents(t *testing.T) {
+	orig := log.Logger
+	t.Cleanup(func() { log.Logger = orig })
+	path := filepath.Join(t.TempDir(), "ncc.log")
+	var stdout bytes.Buffer
+	rot := logRotation{MaxSize: 1}
+	if err := setupFileLogger(path, rot, zerolog.InfoLevel, nil, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	log.Debug().Msg("below level")
+	log.Info().Str("cluster", "c1").Msg("both sinks")
+
+	file, _ := os.ReadFile(path)
+	for name, out := range map[string][]byte{"file": file, "stdout": stdout.Bytes()} {
+		lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+		if len(lines) != 1 {
+			t.Errorf("%s: %d lines, want only the info event: %q", name, len(lines), out)
+			continue
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(lines[0], &ev); err != nil || ev["message"] != "both sinks" || ev["cluster"] != "c1" {
+			t.Errorf("%s: event %s, %v", name, lines[0], err)
+		}
+	}
+}
+
+func TestConsoleColor(t *testing.T) {
+	orig := log.Logger
+	t.Cleanup(func() { log.Logger = orig; colorMode = "auto" })
+	for _, tc := range []struct {
+		name           string
+		noColor, force bool
+		noColorEnv     string
+		wantANSI       bool
+	}{
+		{"no-color", true, false, "", false},
+		{"force-color", false, true, "", true},
+		{"force-color beats NO_COLOR", false, true, "1", true},
+		{"NO_COLOR", false, false, "1", false},
+		{"auto off a terminal", false, false, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tc.noColorEnv)
+			setColorMode(tc.noColor, tc.force)
+			var mirror bytes.Buffer
+			if err := setupFileLogger(filepath.Join(t.TempDir(), "ncc.log"), logRotation{MaxSize: 1}, zerolog.InfoLevel, &mirror, nil); err != nil {
+				t.Fatal(err)
+			}
+			log.Warn().Str("cluster", "c1").Msg("colored?")
+			if got := strings.Contains(mirror.String(), "\x1b["); got != tc.wantANSI {
+				t.Errorf("ANSI in %q = %v, want %v", mirror.String(), got, tc.wantANSI)
+			}
+		})
+	}
+
+	_, err := bindConfigYAML(t, "clusters: 10.0.0.1\nno-color: true\nforce-color: true\n")
+	if !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+		t.Errorf("err = %v, want a config error for --no-color with --force-color", err)
+	}
+}
+
+func TestSeverityOverrides(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("severity-overrides", []map[string]any{
+		{"check": `pd_snapshot_check`, "severity": "fail"},
+		{"check": `snapshot`, "severity": "INFO"}, // shadowed by the rule above
+		{"check": `disk_usage`, "severity": "WARN"},
+	})
+	rules, err := loadSeverityOverrides()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []map[string]any{
+		{"check": "ntp", "severity": "CRITICAL"},
+		{"check": "ntp(", "severity": "FAIL"},
+		{"check": "", "severity": "FAIL"},
+	} {
+		viper.Set("severity-overrides", []map[string]any{bad})
+		if _, err := loadSeverityOverrides(); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("%v: err = %v, want a config error", bad, err)
+		}
+	}
+
+	// The WARN promoted to FAIL reaches every report; the disk FAIL becomes WARN.
+	m := newMockPrism(t)
+	cfg := batchConfig(m.cluster)
+	cfg.SeverityOverrides = rules
+	cfg.OutputFormats = []string{"csv"}
+	fs := NewMemFS()
+	// Severities do not feed the exit code, so the promotion leaves the run successful.
+	if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+		t.Fatalf("runBatch = %v, want success", err)
+	}
+	s := batchSummaries(t, fs, cfg)[m.cluster]
+	if s.Fail != 1 || s.Warn != 1 {
+		t.Errorf("summary FAIL %d WARN %d, want 1 and 1 after the overrides", s.Fail, s.Warn)
+	}
+	data, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(m.cluster)+".log.csv"))
+	recs, _ := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	got := map[string]string{}
+	for _, r := range recs[1:] {
+		got[checkID(r[1])] = r[0]
+	}
+	if got["pd_snapshot_check"] != "FAIL" || got["disk_usage_check"] != "WARN" {
+		t.Errorf("csv severities = %v", got)
+	}
+}
+
+func TestRampUpSpreadsStarts(t *testing.T) {
+	for _, tc := range []struct {
+		rampUp      time.Duration
+		parallel, n int
+		wantSlots   int
+		wantStep    time.Duration
+	}{
+		{20 * time.Second, 4, 10, 4, 5 * time.Second},
+		{20 * time.Second, 8, 2, 2, 10 * time.Second},
+		{0, 4, 10, 0, 0},
+		{20 * time.Second, 1, 10, 0, 0},
+	} {
+		slots, step := rampSchedule(tc.rampUp, tc.parallel, tc.n)
+		if slots != tc.wantSlots || step != tc.wantStep {
+			t.Errorf("rampSchedule(%s, %d, %d) = %d, %s; want %d, %s", tc.rampUp, tc.parallel, tc.n, slots, step, tc.wantSlots, tc.wantStep)
+		}
+	}
+
+	var clusters []string
+	var httpc HTTPClient
+	for range 3 {
+		m := newMockPrism(t)
+		clusters = append(clusters, m.cluster)
+		httpc = m.srv.Client()
+	}
+	var mu sync.Mutex
+	var starts []time.Time
+	rc := &recordingClient{HTTPClient: httpc, onDo: func(r *http.Request) {
+		if r.Method == "POST" {
+			mu.Lock()
+			starts = append(starts, time.Now())
+			mu.Unlock()
+		}
+	}}
+	cfg := batchConfig(clusters...)
+	cfg.RampUp = 300 * time.Millisecond // one start every 100ms
+	if err := runBatch(context.Background(), cfg, NewMemFS(), rc); err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) != 3 {
+		t.Fatalf("%d clusters started, want 3", len(starts))
+	}
+	slices.SortFunc(starts, time.Time.Compare)
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 80*time.Millisecond {
+			t.Errorf("start %d came %s after the previous one, want about 100ms", i, gap)
+		}
+	}
+
+	// Cancelling during the ramp stops waiting for the later slots.
+	cfg.RampUp = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	err := runBatch(ctx, cfg, NewMemFS(), httpc)
+	if d := time.Since(begin); d > 2*time.Second {
+		t.Errorf("cancelled ramp took %s", d)
+	}
+	var merr *MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != 2 {
+		t.Errorf("cancelled ramp = %v, want the two unstarted clusters aborted", err)
+	}
+}
+
+// captureStdout swaps os.Stdout for a pipe while fn runs and returns what
+// was written to it.
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestJSONOutputOnStdout(t *testing.T) {
+	ok, down := newMockPrism(t), newMockPrism(t)
+	down.srv.Close()
+	cfg := batchConfig(ok.cluster, down.cluster)
+	cfg.Quiet = false // console lines must go to stderr, not into the document
+	cfg.JSONOutput = true
+	cfg.RetryMaxAttempts = 1
+	cfg.RunID = "run1"
+	fs := NewMemFS()
+	var err error
+	stdout := captureStdout(t, func() { err = runBatch(context.Background(), cfg, fs, ok.srv.Client()) })
+	if err == nil {
+		t.Error("runBatch succeeded with a cluster down")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(stdout))
+	var res RunResult
+	if err := dec.Decode(&res); err != nil {
+		t.Fatalf("stdout %q: %v", stdout, err)
+	}
+	if dec.More() {
+		t.Errorf("stdout has more than one JSON document: %q", stdout)
+	}
+	if res.RunID != "run1" || res.Status != "failed" || !slices.Equal(res.FailedClusters, []string{down.cluster}) {
+		t.Errorf("result = %+v", res)
+	}
+	if len(res.Clusters) != 2 || res.Summary["FAIL"] != 1 || res.Summary["WARN"] != 1 {
+		t.Errorf("clusters %d, summary %v", len(res.Clusters), res.Summary)
+	}
+	want := filepath.Join(cfg.OutputDirFiltered, sanitizeFilename(ok.cluster)+".log.html")
+	if !slices.Contains(res.Files, want) {
+		t.Errorf("files %q lack %s", res.Files, want)
+	}
+}
+
+/************** Progress **************/
+
+// syncBuffer is a bytes.Buffer safe for a renderer goroutine to write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestProgressBarsResetPerIteration runs two --watch style iterations, each
+// on its own renderer: Wait must flush the final frame and stop drawing, so
+// the next iteration's bars are not drawn over.
+func TestProgressBarsResetPerIteration(t *testing.T) {
+	var out syncBuffer
+	for iter := range 2 {
+		before := len(out.String())
+		sink := newProgressSink("bars", &out)
+		bar := sink.Add("10.0.0.1")
+		bar.SetPhase("polling")
+		bar.SetPct(40 + iter*10)
+		bar.Finish(true)
+
+		done := make(chan struct{})
+		go func() { sink.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("iteration %d: Wait did not return", iter)
+		}
+		frame := out.String()[before:]
+		if !strings.Contains(frame, "100 %") {
+			t.Errorf("iteration %d: final frame %q lacks 100 %%", iter, frame)
+		}
+
+		// Nothing draws after Wait, so the next iteration starts clean.
+		n := len(out.String())
+		time.Sleep(250 * time.Millisecond)
+		if len(out.String()) != n {
+			t.Errorf("iteration %d: renderer still drawing after Wait", iter)
+		}
+	}
+
+	var lines bytes.Buffer
+	for range 2 {
+		sink := newProgressSink("json", &lines)
+		p := sink.Add("10.0.0.1")
+		p.SetPct(40)
+		sink.Wait()
+	}
+	var pcts []int
+	for _, l := range strings.Split(strings.TrimSpace(lines.String()), "\n") {
+		var pl progressLine
+		if err := json.Unmarshal([]byte(l), &pl); err != nil {
+			t.Fatal(err)
+		}
+		pcts = append(pcts, pl.Pct)
+	}
+	if !slices.Equal(pcts, []int{40, 40}) {
+		t.Errorf("json progress pcts = %v; each iteration should start from 0 and report 40", pcts)
+	}
+}
+
+/************** Request headers **************/
+
+func TestRequestIDHeader(t *testing.T) {
+	m := newMockPrism(t)
+	cfg := runConfig()
+	cfg.RunID = "run1"
+	rc := &recordingClient{HTTPClient: m.srv.Client()}
+	if _, err := runClusterWithBars(context.Background(), cfg, NewMemFS(), rc, m.cluster, func(int) {}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.reqs) < 3 {
+		t.Fatalf("%d requests sent, want start, poll and summary", len(rc.reqs))
+	}
+	want := clusterRequestID("run1", m.cluster)
+	for _, r := range rc.reqs {
+		if got := r.Header.Get(requestIDHeader); got != want {
+			t.Errorf("%s %s: %s = %q, want %q", r.Method, r.URL.Path, requestIDHeader, got, want)
+		}
+	}
+}
+
+func TestUserAgentHeader(t *testing.T) {
+	m := newMockPrism(t)
+	cfg := runConfig()
+	cfg.SkipNCCCheck = false // the pre-flight request carries it too
+	cfg.UserAgent = "ops-tooling/2.1"
+	rc := &recordingClient{HTTPClient: m.srv.Client()}
+	if _, err := runClusterWithBars(context.Background(), cfg, NewMemFS(), rc, m.cluster, func(int) {}, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rc.reqs {
+		if got := r.UserAgent(); got != cfg.UserAgent {
+			t.Errorf("%s %s: User-Agent = %q, want %q", r.Method, r.URL.Path, got, cfg.UserAgent)
+		}
+	}
+}
+
+/************** Start payload **************/
+
+func TestStartChecksPayload(t *testing.T) {
+	if got := string(startChecksPayload(Config{})); got != `{"sendEmail":false}` {
+		t.Errorf("default payload = %s", got)
+	}
+	if got := string(startChecksPayload(Config{NCCSendEmail: true})); got != `{"sendEmail":true}` {
+		t.Errorf("--ncc-send-email payload = %s", got)
+	}
+
+	m := newMockPrism(t)
+	cfg := runConfig()
+	cfg.NCCSendEmail = true
+	if _, err := m.run(cfg, NewMemFS()); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.starts) != 1 || m.starts[0] != `{"sendEmail":true}` {
+		t.Errorf("POST /v1/ncc/checks bodies = %q", m.starts)
+	}
+}
+
+/************** Full logs **************/
+
+func TestFetchRunLogsUnsupported(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	cfg := Config{RequestTimeout: 5 * time.Second}
+	client := NewNCCClient(u.Host, "admin", "secret", srv.Client(), cfg)
+	fs := NewMemFS()
+	err := client.FetchRunLogs(context.Background(), "task-1", fs, "/logs/c.ncc-full.log")
+	if !errors.Is(err, errFullLogsUnsupported) {
+		t.Fatalf("err = %v, want errFullLogsUnsupported", err)
+	}
+	if _, err := fs.Stat("/logs/c.ncc-full.log"); err == nil {
+		t.Fatal("partial full log left behind")
+	}
+}
+
+func TestFetchFullLogs(t *testing.T) {
+	m := newMockPrism(t)
+	m.fullLog = strings.Repeat("full NCC output line\n", 1000)
+	cfg := runConfig()
+	cfg.FetchFullLogs = true
+	fs := NewMemFS()
+	if _, err := m.run(cfg, fs); err != nil {
+		t.Fatal(err)
+	}
+	path := "/logs/" + sanitizeFilename(m.cluster) + ".ncc-full.log"
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		t.Fatalf("full log not written: %v", err)
+	}
+	if string(data) != m.fullLog {
+		t.Errorf("full log is %d bytes, want %d", len(data), len(m.fullLog))
+	}
+
+	// Without FAIL results there is nothing to download.
+	m.set(nil, []string{"Plugins run: 1\nDetailed information for pd_snapshot_check:\nWARN: Snapshot older than schedule\n"})
+	fs = NewMemFS()
+	if _, err := m.run(cfg, fs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(path); err == nil || m.logFetches != 0 {
+		t.Errorf("full log fetched %d times for a run without FAILs", m.logFetches)
+	}
+
+	// An unsupported endpoint leaves the cluster's result alone.
+	m.fullLog = ""
+	m.set(nil, []string{categorizedSummary})
+	if _, err := m.run(cfg, NewMemFS()); err != nil {
+		t.Errorf("missing full-log endpoint failed the cluster: %v", err)
+	}
+}
+
+/************** Parser **************/
+
+const categorizedSummary = `Running /health_checks/hardware_checks/disk_checks/disk_usage_check [ FAIL ]
+Detailed information for disk_usage_check:
+Node 10.0.0.1:
+FAIL: Disk usage above 90%
+Refer to KB 1540 (http://portal.nutanix.com/kb/1540) for details
+Plugin: Data Protection Checks
+Detailed information for pd_snapshot_check:
+WARN: Snapshot older than schedule
+Refer to KB 2000 for details
+`
+
+func TestCompressedLogRoundTrip(t *testing.T) {
+	fs := NewMemFS()
+	path, err := writeSummary(fs, "/logs", "10.0.0.1:9440", categorizedSummary, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/logs/10.0.0.1_9440.log.gz" {
+		t.Fatalf("path = %s", path)
+	}
+	raw, _ := fs.ReadFile(path)
+	if !bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		t.Fatal("log not gzip-compressed")
+	}
+	data, err := readLog(fs, path)
+	if err != nil || string(data) != categorizedSummary {
+		t.Fatalf("readLog = %q, %v", data, err)
+	}
+	// The filter reads the compressed log like a plain one.
+	if err := filterBlocksToFile(fs, path, "/out/c.log"); err != nil {
+		t.Fatal(err)
+	}
+	filtered, _ := fs.ReadFile("/out/c.log")
+	if blocks, _ := ParseSummary(string(filtered)); len(blocks) != 2 {
+		t.Fatalf("filtered %d blocks from the compressed log, want 2", len(blocks))
+	}
+}
+
+func TestParseLongLine(t *testing.T) {
+	long := "FAIL: affected entities " + strings.Repeat("x", maxScanLine+1)
+	summary := "Detailed information for big_check:\n" + long + "\nRefer to KB 1 for details\n" +
+		"Detailed information for next_check:\nWARN: after the long line\nRefer to KB 2 for details\n"
+	blocks, err := ParseSummary(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("%d blocks, want 2: the rest of the summary was dropped", len(blocks))
+	}
+	if !strings.Contains(blocks[0].DetailRaw, long) {
+		t.Error("long line truncated")
+	}
+	if blocks[1].Severity != "WARN" {
+		t.Errorf("block after the long line: %+v", blocks[1])
+	}
+}
+
+func TestFilteredLogKeepsCategories(t *testing.T) {
+	fs := NewMemFS()
+	if err := fs.WriteFile("/raw/c.log", []byte(categorizedSummary), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := filterBlocksToFile(fs, "/raw/c.log", "/out/c.log"); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ParseSummary(categorizedSummary)
+	data, _ := fs.ReadFile("/out/c.log")
+	filtered, _ := ParseSummary(string(data))
+	if len(raw) != 2 || len(filtered) != len(raw) {
+		t.Fatalf("got %d raw and %d filtered blocks, want 2 each", len(raw), len(filtered))
+	}
+	want := []string{"hardware", "Data Protection Checks"}
+	for i := range raw {
+		if raw[i].Category != want[i] || filtered[i].Category != want[i] {
+			t.Errorf("block %d: raw category %q, filtered %q, want %q", i, raw[i].Category, filtered[i].Category, want[i])
+		}
+		if filtered[i].Node != raw[i].Node || filtered[i].Severity != raw[i].Severity {
+			t.Errorf("block %d: filtered %+v differs from raw %+v", i, filtered[i], raw[i])
+		}
+	}
+}
+
+func TestCustomBlockTerminator(t *testing.T) {
+	start, end := reBlockStart, reBlockEnd
+	t.Cleanup(func() { reBlockStart, reBlockEnd = start, end })
+
+	const text = `Detailed information for ntp_check:
+WARN: NTP server unreachable
+---
+Detailed information for dns_check:
+FAIL: DNS lookup failed
+Refer to KB 1000 for details
+more dns detail
+---
+`
+	if err := setBlockPatterns("", `^---$`); err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := ParseSummary(text)
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	if blocks[0].CheckName != "Detailed information for ntp_check:" || blocks[0].Severity != "WARN" {
+		t.Errorf("block 0 = %+v", blocks[0])
+	}
+	// "Refer to" no longer ends the block, so the lines after it stay in.
+	if !strings.Contains(blocks[1].DetailRaw, "more dns detail") {
+		t.Errorf("block 1 cut at the default terminator: %q", blocks[1].DetailRaw)
+	}
+
+	if err := setBlockPatterns(`(`, ""); err == nil {
+		t.Error("invalid --block-start-regex accepted")
+	}
+	if reBlockEnd.String() != `^---$` {
+		t.Errorf("empty end pattern changed the terminator to %q", reBlockEnd)
+	}
+}
+
+// TestParseCommand runs the parse subcommand against the summaries in
+// testdata/parse.
+func TestParseCommand(t *testing.T) {
+	start, end := reBlockStart, reBlockEnd
+	t.Cleanup(func() { reBlockStart, reBlockEnd = start, end })
+	parse := func(stdin io.Reader, args ...string) (string, error) {
+		t.Helper()
+		cmd := newParseCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(io.Discard)
+		cmd.SetIn(stdin)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+	type result struct {
+		Total  int
+		Counts map[string]int
+		Blocks []struct {
+			Severity, Node, Category string
+			CheckID                  string `json:"check_id"`
+		}
+	}
+	parseJSON := func(args ...string) result {
+		t.Helper()
+		out, err := parse(nil, append([]string{"--json"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res result
+		if err := json.Unmarshal([]byte(out), &res); err != nil {
+			t.Fatalf("%s: %v", out, err)
+		}
+		return res
+	}
+
+	res := parseJSON("testdata/parse/mixed.log")
+	if want := map[string]int{"FAIL": 2, "ERR": 1, "WARN": 1, "INFO": 1}; res.Total != 5 || !maps.Equal(res.Counts, want) {
+		t.Errorf("mixed.log: total %d, counts %v; want 5, %v", res.Total, res.Counts, want)
+	}
+	var got []string
+	for _, b := range res.Blocks {
+		got = append(got, strings.Join([]string{b.Severity, b.CheckID, b.Node, b.Category}, " "))
+	}
+	want := []string{
+		"FAIL disk_usage_check 10.0.0.1 hardware",
+		"FAIL disk_usage_check 10.0.0.2 hardware",
+		"WARN ntp_check  system",
+		"ERR dns_check  network",
+		"INFO cvm_memory_check  system",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("mixed.log blocks:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	table, err := parse(nil, "--width", "20", "testdata/parse/mixed.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(table, "SEVERITY") || !strings.Contains(table, "5 block(s): FAIL=2 ERR=1 WARN=1 INFO=1") {
+		t.Errorf("table output:\n%s", table)
+	}
+	if !strings.Contains(table, "Node 10.0.0.1: FAIL…") || strings.Contains(table, "on /home") {
+		t.Errorf("details not cut to --width 20:\n%s", table)
+	}
+	data, _ := os.ReadFile("testdata/parse/mixed.log")
+	if stdin, err := parse(bytes.NewReader(data), "--width", "20", "-"); err != nil || stdin != table {
+		t.Errorf("stdin output differs from the file's (err %v):\n%s", err, stdin)
+	}
+
+	if res := parseJSON("testdata/parse/clean.log"); res.Total != 0 || len(res.Blocks) != 0 {
+		t.Errorf("clean.log: %d blocks, want none", res.Total)
+	}
+
+	// dashes.log ends blocks with "---" rather than a KB line.
+	if res := parseJSON("testdata/parse/dashes.log"); res.Total != 1 {
+		t.Errorf("dashes.log with the default patterns: %d blocks, want 1", res.Total)
+	}
+	if res := parseJSON("--block-end-regex", `^---$`, "testdata/parse/dashes.log"); res.Total != 2 || res.Counts["WARN"] != 1 || res.Counts["FAIL"] != 1 {
+		t.Errorf("dashes.log with --block-end-regex: total %d, counts %v", res.Total, res.Counts)
+	}
+
+	if _, err := parse(nil, "testdata/parse/missing.log"); err == nil {
+		t.Error("missing file accepted")
+	}
+	if _, err := parse(nil, "--block-start-regex", "(", "testdata/parse/mixed.log"); err == nil {
+		t.Error("invalid --block-start-regex accepted")
+	}
+}
+
+func TestRemediation(t *testing.T) {
+	for _, tc := range []struct{ line, rem, url string }{
+		{"Refer to KB 1540 (http://portal.nutanix.com/kb/1540) for details", "Refer to KB 1540 (http://portal.nutanix.com/kb/1540) for details", "http://portal.nutanix.com/kb/1540"},
+		{"Refer to KB 2000 for details", "Refer to KB 2000 for details", "https://portal.nutanix.com/kb/2000"},
+		{"  Please refer to KB#3059 for details.", "Please refer to KB#3059 for details.", "https://portal.nutanix.com/kb/3059"},
+		{"Refer to Nutanix KB-6412 for details", "Refer to Nutanix KB-6412 for details", "https://portal.nutanix.com/kb/6412"},
+		{"Refer to https://portal.nutanix.com/page/documents/kbs/details?targetId=kA0 for details", "Refer to https://portal.nutanix.com/page/documents/kbs/details?targetId=kA0 for details", "https://portal.nutanix.com/page/documents/kbs/details?targetId=kA0"},
+		{"Refer to the output above", "", ""},
+	} {
+		rem := remediationOf([]string{"FAIL: something", tc.line})
+		if rem != tc.rem {
+			t.Errorf("remediationOf(%q) = %q, want %q", tc.line, rem, tc.rem)
+		}
+		if u := remediationURL(rem); u != tc.url {
+			t.Errorf("remediationURL(%q) = %q, want %q", rem, u, tc.url)
+		}
+	}
+
+	// A block that runs to the end of the summary has no remediation.
+	blocks, _ := ParseSummary(categorizedSummary + "Detailed information for last_check:\nINFO: no KB line\n")
+	if n := len(blocks); n != 3 {
+		t.Fatalf("%d blocks, want 3", n)
+	}
+	if blocks[0].Remediation == "" || blocks[2].Remediation != "" {
+		t.Errorf("remediations = %q, %q; want the KB line, then none", blocks[0].Remediation, blocks[2].Remediation)
+	}
+}
+
+func TestCheckID(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"Detailed information for disk_usage_check:", "disk_usage_check"},
+		{"Detailed information for Disk Usage Check on node 10.0.0.1:", "disk_usage_check"},
+		{"Results of disk_usage_check on host 10.0.0.1:9440", "disk_usage_check"},
+		{"Detailed information for disk_usage_check on [fe80::5]:9440", "disk_usage_check"},
+		{"Detailed information for disk_usage_check on cvm 2001:db8::1", "disk_usage_check"},
+		{"dead:beef:cafe check", "dead_beef_cafe_check"},
+		{"foo::bar check", "foo_bar_check"},
+	}
+	for _, tt := range tests {
+		if got := checkID(tt.name); got != tt.want {
+			t.Errorf("checkID(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+/************** Filters **************/
+
+func TestDetectTimestampFormats(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	fixtures := []string{
+		"WARN: last snapshot at " + want.Format(time.RFC3339),
+		"WARN: last snapshot at 2024-05-01 12:30:00",
+		"WARN: last snapshot at 05/01/2024 12:30:00",
+		"WARN: last snapshot at Wed May  1 12:30:00 2024",
+		"WARN: last snapshot at " + want.Format(time.UnixDate),
+	}
+	for _, f := range fixtures {
+		got, ok := detectTimestamp(f)
+		if !ok || !got.Equal(want) {
+			t.Errorf("detectTimestamp(%q) = %v, %v; want %v", f, got, ok, want)
+		}
+	}
+	got, _ := detectTimestamp("first 2024-05-01 12:30:00, again 2024-05-02 08:00:00")
+	if want := time.Date(2024, 5, 2, 8, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("latest of two timestamps = %v, want %v", got, want)
+	}
+	if _, ok := detectTimestamp("WARN: no time here"); ok {
+		t.Error("timestamp found in text without one")
+	}
+}
+
+func TestSinceFilterBoundary(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.Local)
+	since, err := parseSince("24h", now)
+	if err != nil || !since.Equal(now.Add(-24*time.Hour)) {
+		t.Fatalf("parseSince(24h) = %v, %v", since, err)
+	}
+	if abs, err := parseSince("2024-05-01 12:00:00", now); err != nil || !abs.Equal(since) {
+		t.Fatalf("parseSince(absolute) = %v, %v", abs, err)
+	}
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Fatal("parseSince accepted an invalid value")
+	}
+
+	blocks := []ParsedBlock{
+		{CheckName: "at_boundary", DetectedAt: since},
+		{CheckName: "just_before", DetectedAt: since.Add(-time.Second)},
+		{CheckName: "after", DetectedAt: since.Add(time.Hour)},
+		{CheckName: "undated"},
+	}
+	names := func(bs []ParsedBlock) []string {
+		var out []string
+		for _, b := range bs {
+			out = append(out, b.CheckName)
+		}
+		return out
+	}
+	got := names(applyFilters(slices.Clone(blocks), Config{Since: since}))
+	if want := []string{"at_boundary", "after"}; !slices.Equal(got, want) {
+		t.Errorf("--since kept %v, want %v", got, want)
+	}
+	got = names(applyFilters(slices.Clone(blocks), Config{Since: since, SinceKeepUndated: true}))
+	if want := []string{"at_boundary", "after", "undated"}; !slices.Equal(got, want) {
+		t.Errorf("--since-keep-undated kept %v, want %v", got, want)
+	}
+}
+
+func TestCheckExcludeFilter(t *testing.T) {
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	blocks := []ParsedBlock{
+		{CheckName: "Detailed information for ntp_check:", DetectedAt: since.Add(time.Hour)},
+		{CheckName: "Detailed information for disk_usage_check:", DetectedAt: since.Add(time.Hour)},
+		{CheckName: "Detailed information for cvm_memory_check:", DetectedAt: since.Add(-time.Hour)},
+		{CheckName: "Detailed information for ntp_sync_check:"},
+	}
+	names := func(bs []ParsedBlock) (out []string) {
+		for _, b := range bs {
+			out = append(out, checkID(b.CheckName))
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"none", Config{}, names(blocks)},
+		{"exclude", Config{CheckExclude: compileCheckFilter("--filter-check-exclude", `ntp`)},
+			[]string{"disk_usage_check", "cvm_memory_check"}},
+		{"since and exclude", Config{Since: since, SinceKeepUndated: true, CheckExclude: compileCheckFilter("--filter-check-exclude", `^Detailed information for ntp_check:$`)},
+			[]string{"disk_usage_check", "ntp_sync_check"}},
+		{"invalid regex ignored", Config{CheckExclude: compileCheckFilter("--filter-check-exclude", `ntp(`)}, names(blocks)},
+	} {
+		if got := names(applyFilters(slices.Clone(blocks), tc.cfg)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: kept %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"10.0.0.1", "10.0.0.1"},
+		{"10.0.0.1:9440", "10.0.0.1_9440"},
+		{"[fe80::1]:9440", "_fe80__1__9440"},
+		{"../../etc/passwd", "______etc_passwd"},
+		{"..", "__"},
+		{".", "_"},
+		{"", "_"},
+		{`C:\Windows\x`, "C__Windows_x"},
+		{"a/b", "a_b"},
+		{"name with spaces\x00", "name_with_spaces_"},
+		{"prism.example.com", "prism.example.com"},
+	}
+	for _, tt := range tests {
+		got := sanitizeFilename(tt.in)
+		if got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if p := filepath.Join("/out", got+".log"); filepath.Dir(p) != "/out" {
+			t.Errorf("sanitizeFilename(%q) escapes the output directory: %s", tt.in, p)
+		}
+	}
+}
+
+/************** Redaction **************/
+
+func TestRedactPatterns(t *testing.T) {
+	rules, err := parseRedactions([]string{
+		`[\w.+-]+@[\w-]+\.[\w.]+=<email>`,
+		`\bSN-(\d{2})\d+=SN-${1}xxx`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseRedactions([]string{`([=x`}); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+		t.Errorf("invalid regex: err = %v, want a config error", err)
+	}
+
+	m := newMockPrism(t)
+	m.set(nil, []string{`Detailed information for admin@example.com_alert_check:
+WARN: alerts for admin@example.com are not delivered; chassis SN-1234567 affected
+Refer to KB 2000 for details
+`})
+	cfg := batchConfig(m.cluster)
+	cfg.OutputFormats = []string{"html", "csv", "jsonl"}
+	cfg.Redactions = rules
+	fs := NewMemFS()
+	if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := fs.ReadDir(cfg.OutputDirFiltered)
+	reports := 0
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".log" {
+			continue // the filtered log keeps NCC's text; reportFiles leaves it out
+		}
+		reports++
+		data, _ := fs.ReadFile(filepath.Join(cfg.OutputDirFiltered, e.Name()))
+		for _, secret := range []string{"admin@example.com", "SN-1234567"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s still contains %s", e.Name(), secret)
+			}
+		}
+		if !bytes.Contains(data, []byte("SN-12xxx")) {
+			t.Errorf("%s lacks the redacted serial", e.Name())
+		}
+	}
+	if reports < 5 { // html, csv, jsonl, aggregated html and json
+		t.Errorf("checked %d reports, want at least 5", reports)
+	}
+}
+
+/************** Report files **************/
+
+func TestReportFilesSkipsUnredactedLogs(t *testing.T) {
+	fs := NewMemFS()
+	for _, f := range []string{"/out/c1.log", "/out/c1.log.html", "/out/index.html"} {
+		_ = fs.WriteFile(f, []byte("x"), 0644)
+	}
+	cfg := Config{OutputDirFiltered: "/out", OutputFormats: []string{"html"}, AggregatedFilename: "index.html"}
+	if got := reportFiles(fs, cfg, []string{"c1"}); !slices.Contains(got, "/out/c1.log") {
+		t.Fatalf("without redactions the filtered log should be listed: %v", got)
+	}
+	rules, err := parseRedactions([]string{`secret=<redacted>`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Redactions = rules
+	got := reportFiles(fs, cfg, []string{"c1"})
+	if slices.Contains(got, "/out/c1.log") {
+		t.Fatalf("unredacted filtered log listed with --redact-pattern: %v", got)
+	}
+	if !slices.Contains(got, "/out/c1.log.html") || !slices.Contains(got, "/out/index.html") {
+		t.Fatalf("reports missing: %v", got)
+	}
+}
+
+// archiveMembers lists the member names of a zip or tar.gz archive.
+func archiveMembers(t *testing.T, data []byte, zipped bool) []string {
+	t.Helper()
+	var names []string
+	if zipped {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+}
+
+func TestArchiveContainsReports(t *testing.T) {
+	m := newMockPrism(t)
+	base := sanitizeFilename(m.cluster) + ".log"
+	// The filtered log is included as no --redact-pattern is set.
+	want := []string{base, base + ".csv", base + ".html", "index.html", "index.json", "manifest.json"}
+	for _, archive := range []string{"/archives/run.zip", "/archives/run.tar.gz"} {
+		cfg := batchConfig(m.cluster)
+		cfg.OutputFormats = []string{"html", "csv"}
+		cfg.Archive = archive
+		fs := NewMemFS()
+		if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+			t.Fatal(err)
+		}
+		data, err := fs.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := archiveMembers(t, data, strings.HasSuffix(archive, ".zip"))
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s members = %q, want %q", archive, got, want)
+		}
+		for _, name := range want[:5] {
+			if _, err := fs.Stat(filepath.Join(cfg.OutputDirFiltered, name)); err != nil {
+				t.Errorf("%s: original %s removed: %v", archive, name, err)
+			}
+		}
+	}
+}
+
+func TestCleanStaleKeepsUnselectedClusters(t *testing.T) {
+	m := newMockPrism(t)
+	cfg := batchConfig(m.cluster)
+	cfg.OutputFormats = []string{"html"}
+	cfg.CleanStale = true
+	// --select picked m.cluster out of the configured c1 and m.cluster.
+	cfg.ConfiguredClusters = []string{"c1", m.cluster}
+	fs := NewMemFS()
+	for _, f := range []string{"/out/c1.log.html", "/out/gone.log.html"} {
+		_ = fs.WriteFile(f, []byte("x"), 0644)
+	}
+	if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/out/c1.log.html"); err != nil {
+		t.Errorf("report of a configured but unselected cluster removed: %v", err)
+	}
+	if _, err := fs.Stat("/out/gone.log.html"); err == nil {
+		t.Error("report of a cluster no longer configured kept")
+	}
+}
+
+func TestAggregatedFilename(t *testing.T) {
+	m := newMockPrism(t)
+	cfg := batchConfig(m.cluster)
+	cfg.AggregatedFilename = "nightly.html"
+	cfg.Archive = "/archives/run.zip"
+	fs := NewMemFS()
+	if err := runBatch(context.Background(), cfg, fs, m.srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"nightly.html", "nightly.json"} {
+		if _, err := fs.Stat(filepath.Join(cfg.OutputDirFiltered, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+	for _, name := range []string{"index.html", "index.json"} {
+		if _, err := fs.Stat(filepath.Join(cfg.OutputDirFiltered, name)); err == nil {
+			t.Errorf("%s written alongside --aggregated-filename", name)
+		}
+	}
+	if sums := batchSummaries(t, fs, cfg); sums[m.cluster].Status != "ok" {
+		t.Errorf("nightly.json status = %+v", sums[m.cluster])
+	}
+	data, err := fs.ReadFile(cfg.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := archiveMembers(t, data, true); !slices.Contains(got, "nightly.html") || !slices.Contains(got, "nightly.json") {
+		t.Errorf("archive members %q lack the renamed report", got)
+	}
+
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{"", true}, // defaults to index.html
+		{"report.htm", true},
+		{"reports/nightly.html", false},
+		{`reports\nightly.html`, false},
+		{"..", false},
+		{"nightly.json", false},
+		{"combined.html", false},
+		{"manifest.html", false},
+	} {
+		cfg, err := bindConfigYAML(t, fmt.Sprintf("clusters: 10.0.0.1\naggregated-filename: %q\n", tc.name))
+		if tc.ok != (err == nil) {
+			t.Errorf("--aggregated-filename %q: err = %v", tc.name, err)
+		}
+		if tc.name == "" && cfg.AggregatedFilename != "index.html" {
+			t.Errorf("default name %q", cfg.AggregatedFilename)
+		}
+	}
+}
+
+func TestPruneRunDirs(t *testing.T) {
+	parent := t.TempDir()
+	base := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	var runs []string
+	for i := range 5 {
+		dir := filepath.Join(parent, "reports-"+base.Add(time.Duration(i)*time.Hour).Format(runDirTimeLayout))
+		if err := os.MkdirAll(filepath.Join(dir, "logs"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "index.html"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, dir)
+	}
+	ts := base.Add(-time.Hour).Format(runDirTimeLayout)
+	others := []string{
+		filepath.Join(parent, "reports-old"), // not a timestamp
+		filepath.Join(parent, "archive-"+ts), // another base
+		filepath.Join(parent, "reports-"+ts), // a file, not a directory
+	}
+	for _, d := range others[:2] {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(others[2], nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	current := runs[4]
+	removed, err := pruneRunDirs(OSFS{}, current, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Sort(removed); !slices.Equal(removed, runs[:2]) {
+		t.Errorf("removed %q, want the two oldest runs %q", removed, runs[:2])
+	}
+	for i, dir := range runs {
+		_, err := os.Stat(dir)
+		if kept := err == nil; kept != (i >= 2) {
+			t.Errorf("%s kept = %v", filepath.Base(dir), kept)
+		}
+	}
+	for _, p := range others {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("unrelated %s removed", filepath.Base(p))
+		}
+	}
+
+	// Already at the limit: nothing to do.
+	if removed, err := pruneRunDirs(OSFS{}, current, 3); err != nil || len(removed) != 0 {
+		t.Errorf("second prune removed %q, %v", removed, err)
+	}
+	if _, err := pruneRunDirs(OSFS{}, filepath.Join(parent, "reports-old"), 3); err == nil {
+		t.Error("pruned next to a directory without a timestamp")
+	}
+}
+
+/************** Config **************/
+
+func TestValidateOutputFormats(t *testing.T) {
+	valid := []string{"HTML", " csv", "JsonL"}
+	if err := validateOutputFormats(valid); err != nil {
+		t.Fatalf("valid formats: %v", err)
+	}
+	if !slices.Equal(valid, []string{"html", "csv", "jsonl"}) {
+		t.Errorf("formats not normalized in place: %q", valid)
+	}
+	for _, bad := range [][]string{{"pdf"}, {"html", "XML", "csv", "txt"}} {
+		err := validateOutputFormats(bad)
+		if !errors.Is(err, &NCCError{Type: ErrorTypeValidation}) {
+			t.Errorf("%q: err = %v, want a validation error", bad, err)
+			continue
+		}
+		for _, f := range bad {
+			if f != "html" && f != "csv" && !strings.Contains(err.Error(), f) {
+				t.Errorf("%q: error %q does not name %s", bad, err, f)
+			}
+		}
+	}
+}
+
+// TestConfigFilesMergeInOrder layers two --config files through bindConfig:
+// later scalars and lists replace earlier ones, unset keys fall through.
+func TestConfigFilesMergeInOrder(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	local := filepath.Join(dir, "local.yaml")
+	if err := os.WriteFile(base, []byte(`clusters: "10.0.0.1,10.0.0.2"
+username: base
+timeout: 10m
+cluster-timeouts:
+  - cluster: 10.0.0.1
+    timeout: 40m
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte(`clusters: "10.0.0.3"
+timeout: 20m
+cluster-timeouts:
+  - cluster: 10.0.0.3
+    timeout: 30m
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(viper.Reset)
+	newRootCmd() // binds the flag defaults
+	viper.Set("config", []string{base, local})
+	cfg, err := bindConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.ConfigFiles, []string{base, local}) {
+		t.Errorf("ConfigFiles = %q", cfg.ConfigFiles)
+	}
+	if cfg.Username != "base" {
+		t.Errorf("username = %q, want the base value", cfg.Username)
+	}
+	if cfg.Timeout != 20*time.Minute {
+		t.Errorf("timeout = %s, want the override", cfg.Timeout)
+	}
+	if !slices.Equal(cfg.Clusters, []string{"10.0.0.3"}) {
+		t.Errorf("clusters = %q, want the override's list only", cfg.Clusters)
+	}
+	if len(cfg.ClusterTimeouts) != 1 || clusterTimeout(cfg, "10.0.0.1:9440") != cfg.Timeout {
+		t.Errorf("cluster-timeouts = %v, want the override's list only", cfg.ClusterTimeouts)
+	}
+}
+
+// bindConfigYAML runs bindConfig with the flag defaults and a single config
+// file holding yaml.
+func bindConfigYAML(t *testing.T, yaml string) (Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(viper.Reset)
+	newRootCmd()
+	viper.Set("config", []string{path})
+	return bindConfig()
+}
+
+func TestRequireSecure(t *testing.T) {
+	_, err := bindConfigYAML(t, "clusters: 10.0.0.1\ninsecure-skip-verify: true\nrequire-secure: true\n")
+	if !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) || !strings.Contains(err.Error(), "--require-secure") {
+		t.Fatalf("err = %v, want a --require-secure config error", err)
+	}
+	if _, err := bindConfigYAML(t, "clusters: 10.0.0.1\nrequire-secure: true\n"); err != nil {
+		t.Fatalf("verified TLS with --require-secure: %v", err)
+	}
+
+	buf := captureLog(t)
+	var out bytes.Buffer
+	warnInsecure(Config{InsecureSkipVerify: true, Quiet: true}, &out)
+	if out.Len() != 0 || !strings.Contains(buf.String(), "verification is disabled") {
+		t.Errorf("--quiet: printed %q, logged %q; want logged only", out.String(), buf)
+	}
+	warnInsecure(Config{InsecureSkipVerify: true}, &out)
+	if !strings.Contains(out.String(), "WARNING: TLS certificate verification is DISABLED") {
+		t.Errorf("banner not printed: %q", out.String())
+	}
+}
+
+/************** Cluster resolution **************/
+
+func TestNormalizeCluster(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		host string
+		port int
+	}{
+		{"10.0.0.1", "10.0.0.1", 9440},
+		{"  10.0.0.1  ", "10.0.0.1", 9440},
+		{"https://10.0.0.1", "10.0.0.1", 9440},
+		{"HTTPS://10.0.0.1:9440/", "10.0.0.1", 9440},
+		{"10.0.0.1:9441", "10.0.0.1", 9441},
+		{"10.0.0.1/", "10.0.0.1", 9440},
+		{"https://pe1.example.com:9440/console/#page", "pe1.example.com", 9440},
+		{"pe1.example.com.", "pe1.example.com", 9440},
+	} {
+		host, port, err := normalizeCluster(tc.raw)
+		if err != nil || host != tc.host || port != tc.port {
+			t.Errorf("normalizeCluster(%q) = %q, %d, %v; want %q, %d", tc.raw, host, port, err, tc.host, tc.port)
+		}
+	}
+	for _, raw := range []string{
+		"",
+		"   ",
+		"https://https://10.0.0.1",
+		"ftp://10.0.0.1",
+		"admin@10.0.0.1",
+		"10.0.0.1:0",
+		"10.0.0.1:70000",
+		"10.0.0.1:abc",
+		"10.0.0.1:",
+		"pe_1.example.com",
+		"-pe1.example.com",
+	} {
+		_, _, err := normalizeCluster(raw)
+		if !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+			t.Errorf("normalizeCluster(%q): err = %v, want a config error", raw, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("%q", raw)) {
+			t.Errorf("normalizeCluster(%q): error %q does not name the entry", raw, err)
+		}
+	}
+	got, err := normalizeClusters([]string{"https://10.0.0.1/", "10.0.0.2:9441"})
+	if err != nil || !slices.Equal(got, []string{"10.0.0.1", "10.0.0.2:9441"}) {
+		t.Errorf("normalizeClusters = %q, %v", got, err)
+	}
+}
+
+func TestIPv6Clusters(t *testing.T) {
+	got, err := normalizeClusters([]string{"fd00::1", "[fd00::1]:9440", "https://[FD00::2]:9441/", "[fd00::3]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"fd00::1", "fd00::1", "[fd00::2]:9441", "fd00::3"}; !slices.Equal(got, want) {
+		t.Errorf("normalizeClusters = %q, want %q", got, want)
+	}
+
+	for cluster, want := range map[string]string{
+		"fd00::1":        "https://[fd00::1]:9440/PrismGateway/services/rest",
+		"[fd00::2]:9441": "https://[fd00::2]:9441/PrismGateway/services/rest",
+	} {
+		c := NewNCCClient(cluster, "u", "p", nil, Config{})
+		if c.baseURL != want {
+			t.Errorf("%s: baseURL = %s, want %s", cluster, c.baseURL, want)
+		}
+		if _, err := url.Parse(c.baseURL); err != nil {
+			t.Errorf("%s: %v", cluster, err)
+		}
+		if name := sanitizeFilename(cluster) + ".log.html"; strings.ContainsAny(name, `:[]/\`) {
+			t.Errorf("%s: filename %q", cluster, name)
+		}
+	}
+
+	// A request to a real IPv6 listener, where the host has one.
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":"6.5","nccVersion":"4.6.0"}`))
+	}))
+	srv.Listener = ln
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	cfg := Config{RequestTimeout: 5 * time.Second}
+	if _, err := NewNCCClient(u.Host, "u", "p", srv.Client(), cfg).NCCAvailabilityCheck(context.Background()); err != nil {
+		t.Errorf("request to %s: %v", u.Host, err)
+	}
+}
+
+func TestDedupeClustersRejectsFileCollisions(t *testing.T) {
+	got, err := dedupeClusters([]string{"c1", "c2", "c1"})
+	if err != nil || !slices.Equal(got, []string{"c1", "c2"}) {
+		t.Fatalf("exact duplicates: %v, %v", got, err)
+	}
+	// Both sanitize to 10.0.0.1_9440.log; the second differs only in case.
+	for _, clusters := range [][]string{
+		{"10.0.0.1:9440", "10.0.0.1_9440"},
+		{"Prism-A", "prism-a"},
+	} {
+		_, err := dedupeClusters(clusters)
+		if !errors.Is(err, &NCCError{Type: ErrorTypeValidation}) || !strings.Contains(err.Error(), clusters[1]) {
+			t.Errorf("%v: err = %v, want a validation error naming both", clusters, err)
+		}
+	}
+}
+
+func TestCheckOutputStdoutAfterResolution(t *testing.T) {
+	cfg := Config{OutputStdout: true, Clusters: []string{"10.0.0.1"}}
+	if err := checkOutputStdout(cfg); err != nil {
+		t.Fatalf("single cluster: %v", err)
+	}
+	// e.g. --prism-central discovered a second cluster after bindConfig ran
+	cfg.Clusters = append(cfg.Clusters, "10.0.0.2")
+	if err := checkOutputStdout(cfg); err == nil {
+		t.Fatal("--output-stdout accepted two resolved clusters")
+	}
+	cfg.OutputStdout = false
+	if err := checkOutputStdout(cfg); err != nil {
+		t.Fatalf("without --output-stdout: %v", err)
+	}
+}
+
+func TestClusterSource(t *testing.T) {
+	var mu sync.Mutex
+	body, status := `{"clusters":["https://10.0.0.1/","10.0.0.2:9441","not a host"]}`, http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t1" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := Config{
+		ClusterSourceURL:     srv.URL,
+		ClusterSourceHeaders: http.Header{"Authorization": {"Bearer t1"}},
+		ClusterSourceTimeout: 5 * time.Second,
+		ClusterSourceCache:   filepath.Join(t.TempDir(), "cache", "cluster-source.json"),
+		RetryMaxAttempts:     1,
+		RequestTimeout:       5 * time.Second,
+	}
+	want := []string{"10.0.0.1", "10.0.0.2:9441"}
+	got, err := loadClusterSource(context.Background(), srv.Client(), cfg)
+	if err != nil || !slices.Equal(got, want) {
+		t.Fatalf("loadClusterSource = %q, %v; want %q", got, err, want)
+	}
+
+	// A bare array is accepted too.
+	mu.Lock()
+	body = `["10.0.0.3"]`
+	mu.Unlock()
+	if got, err := fetchClusterSource(context.Background(), srv.Client(), cfg); err != nil || !slices.Equal(got, []string{"10.0.0.3"}) {
+		t.Errorf("bare array: %q, %v", got, err)
+	}
+
+	// When the endpoint fails, the list cached by the first load is used.
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	if got, err := loadClusterSource(context.Background(), srv.Client(), cfg); err != nil || !slices.Equal(got, want) {
+		t.Errorf("fallback to cache = %q, %v; want %q", got, err, want)
+	}
+	cfg.ClusterSourceCache = ""
+	if _, err := loadClusterSource(context.Background(), srv.Client(), cfg); err == nil {
+		t.Error("failed fetch without a cache should fail")
+	}
+
+	kept, dropped := scopeClusters([]string{"10.0.0.1", "10.0.0.9"}, want)
+	if !slices.Equal(kept, []string{"10.0.0.1"}) || !slices.Equal(dropped, []string{"10.0.0.9"}) {
+		t.Errorf("scopeClusters = %q, %q", kept, dropped)
+	}
+	if kept, _ := scopeClusters(nil, want); !slices.Equal(kept, want) {
+		t.Errorf("scopeClusters with no configured clusters = %q", kept)
+	}
+}
+
+// pcServer is a Prism Central whose clusters/list returns one entity per
+// address plus PC's own entry. It returns the PC's host:port.
+func pcServer(t *testing.T, addrs ...string) (string, *http.Client) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/nutanix/v3/clusters/list" {
+			http.NotFound(w, r)
+			return
+		}
+		entities := []map[string]any{{
+			"spec":   map[string]any{"name": "pc"},
+			"status": map[string]any{"resources": map[string]any{"config": map[string]any{"service_list": []string{"PRISM_CENTRAL"}}}},
+		}}
+		for _, a := range addrs {
+			entities = append(entities, map[string]any{
+				"spec":   map[string]any{"name": "pe-" + a},
+				"status": map[string]any{"resources": map[string]any{"network": map[string]any{"external_ip": a}}},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"metadata": map[string]any{"total_matches": len(entities)}, "entities": entities})
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return u.Host, srv.Client()
+}
+
+func TestSeveralPrismCentrals(t *testing.T) {
+	pc1, client := pcServer(t, "10.0.0.1", "10.0.0.2") // every httptest TLS server shares one cert
+	pc2, _ := pcServer(t, "10.0.0.2", "10.0.0.3", "2001:db8:0::4")
+	cfg := Config{PrismCentrals: []string{pc1, pc2}, Timeout: 5 * time.Second, RequestTimeout: 5 * time.Second}
+
+	clusters, origin, err := discoverPCClusters(context.Background(), cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "2001:db8::4"}; !slices.Equal(clusters, want) {
+		t.Errorf("clusters = %q, want %q", clusters, want)
+	}
+	want := map[string]string{"10.0.0.1": pc1, "10.0.0.2": pc1, "10.0.0.3": pc2, "2001:db8::4": pc2}
+	if !maps.Equal(origin, want) {
+		t.Errorf("origin = %v, want %v (overlap kept under the first PC)", origin, want)
+	}
+
+	labels := labelPCOrigin(map[string]map[string]string{
+		"10.0.0.1": {"site": "dc1"},
+		"10.0.0.3": {pcOriginLabel: "pinned"},
+	}, origin)
+	if l := labels["10.0.0.1"]; l["site"] != "dc1" || l[pcOriginLabel] != pc1 {
+		t.Errorf("10.0.0.1 labels = %v", l)
+	}
+	if l := labels["10.0.0.2"]; l[pcOriginLabel] != pc1 {
+		t.Errorf("10.0.0.2 labels = %v", l)
+	}
+	if l := labels["10.0.0.3"]; l[pcOriginLabel] != "pinned" {
+		t.Errorf("cluster-labels prism_central overridden: %v", l)
+	}
+
+	cfg.PrismCentrals = append(cfg.PrismCentrals, "127.0.0.1:1")
+	cfg.RetryMaxAttempts = 1
+	if _, _, err := discoverPCClusters(context.Background(), cfg, client); err == nil || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("err = %v, want the unreachable PC named", err)
+	}
+}
+
+/************** Keyring **************/
+
+// withStdin points os.Stdin at a pipe holding input for the test.
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.WriteString(input)
+	w.Close()
+	old := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = old; r.Close() })
+}
+
+func TestPasswordPrecedence(t *testing.T) {
+	keyring.MockInit()
+	pwFile := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(pwFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	base := Config{Username: "admin", Clusters: []string{"c1"}, UseKeyring: true}
+	if err := keyringSet(keyringAccount(base), "from-keyring"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = keyringDelete(keyringAccount(base)) })
+
+	tests := []struct {
+		name     string
+		explicit bool
+		password string
+		file     string
+		stdin    bool
+		want     string
+	}{
+		{"explicit --password wins", true, "from-flag", pwFile, true, "from-flag"},
+		{"--password-file before env/config", false, "from-env", pwFile, true, "from-file"},
+		{"env/config before --password-stdin", false, "from-env", "", true, "from-env"},
+		{"--password-stdin before keyring", false, "", "", true, "from-stdin"},
+		{"keyring last before the prompt", false, "", "", false, "from-keyring"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStdin(t, "from-stdin\n")
+			cfg := base
+			cfg.Password, cfg.PasswordFile, cfg.PasswordStdin = tt.password, tt.file, tt.stdin
+			got, err := promptPasswordIfEmpty(cfg, tt.explicit)
+			if err != nil || got != tt.want {
+				t.Fatalf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	_ = os.WriteFile(empty, []byte("\n"), 0600)
+	if _, err := promptPasswordIfEmpty(Config{PasswordFile: empty}, false); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+		t.Errorf("empty --password-file: err = %v, want a config error", err)
+	}
+	withStdin(t, "\n")
+	if _, err := promptPasswordIfEmpty(Config{PasswordStdin: true}, false); !errors.Is(err, &NCCError{Type: ErrorTypeConfig}) {
+		t.Errorf("empty --password-stdin: err = %v, want a config error", err)
+	}
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring.MockInit()
+	account := keyringAccount(Config{Username: "admin", Clusters: []string{"b", "a"}})
+	if account != "admin@a,b" {
+		t.Fatalf("account = %q", account)
+	}
+	if _, err := keyringGet(account); !errors.Is(err, errKeyringNotFound) {
+		t.Fatalf("empty keyring: err = %v, want errKeyringNotFound", err)
+	}
+	if err := keyringSet(account, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keyringGet(account); err != nil || got != "s3cret" {
+		t.Fatalf("keyringGet = %q, %v", got, err)
+	}
+	if err := keyringDelete(account); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyringGet(account); !errors.Is(err, errKeyringNotFound) {
+		t.Fatalf("after delete: err = %v, want errKeyringNotFound", err)
+	}
+	if err := keyringDelete(account); !errors.Is(err, errKeyringNotFound) {
+		t.Fatalf("delete of missing entry: err = %v, want errKeyringNotFound", err)
+	}
+}